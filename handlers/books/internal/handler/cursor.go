@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"bookoftheday/types"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// encodeCursor returns an opaque cursor for the key of the last book in a page.
+func encodeCursor(key types.BookItemKey) (string, error) {
+	b, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCursor returns the key that a cursor from encodeCursor was created from.
+func decodeCursor(cursor string) (types.BookItemKey, error) {
+	var key types.BookItemKey
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return key, err
+	}
+	if err := json.Unmarshal(b, &key); err != nil {
+		return key, err
+	}
+	if !listRegexp.MatchString(key.ListEncodedName) || !dateRegexp.MatchString(key.DateSelected) {
+		return key, errors.New("cursor has an invalid key")
+	}
+	return key, nil
+}
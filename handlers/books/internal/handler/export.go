@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"bookoftheday/types"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// exportColumns are the columns of a CSV export, in order, named after the JSON fields
+// of BestSellerBook.
+var exportColumns = []struct {
+	name  string
+	value func(b types.BestSellerBook) string
+}{
+	{"list_encoded_name", func(b types.BestSellerBook) string { return b.ListEncodedName }},
+	{"date_selected", func(b types.BestSellerBook) string { return b.DateSelected }},
+	{"list_published_date", func(b types.BestSellerBook) string { return b.ListPublishedDate }},
+	{"list_display_name", func(b types.BestSellerBook) string { return b.ListDisplayName }},
+	{"list_update_period", func(b types.BestSellerBook) string { return b.ListUpdatePeriod }},
+	{"primary_isbn10", func(b types.BestSellerBook) string { return b.PrimaryISBN10 }},
+	{"primary_isbn13", func(b types.BestSellerBook) string { return b.PrimaryISBN13 }},
+	{"title", func(b types.BestSellerBook) string { return b.Title }},
+	{"author", func(b types.BestSellerBook) string { return b.Author }},
+	{"publisher", func(b types.BestSellerBook) string { return b.Publisher }},
+	{"description", func(b types.BestSellerBook) string { return b.Description }},
+	{"rank", func(b types.BestSellerBook) string { return strconv.Itoa(b.Rank) }},
+	{"amazon_product_url", func(b types.BestSellerBook) string { return b.AmazonProductURL }},
+	{"image_url", func(b types.BestSellerBook) string { return b.ImageURL }},
+	{"image_width", func(b types.BestSellerBook) string { return strconv.Itoa(b.ImageWidth) }},
+	{"image_height", func(b types.BestSellerBook) string { return strconv.Itoa(b.ImageHeight) }},
+}
+
+// Content types of each export format.
+var exportContentTypes = map[string]string{
+	"csv":   "text/csv; charset=utf-8",
+	"jsonl": "application/jsonl; charset=utf-8",
+}
+
+// defaultExportPageSize is the most books in a page of an export.
+const defaultExportPageSize = 1000
+
+// maxExportPageBytes is the size after which a page of an export ends early. Lambda
+// responses are limited to 6 MB, including the rest of the response.
+const maxExportPageBytes = 4 << 20
+
+// GetExport returns the books matching the same filters as GetBooksOnDateInList, as CSV
+// with a header row or as JSON Lines, depending on the format parameter. API Gateway
+// can't stream a response, so an export is paged like GetBooksOnDateInList: each page
+// has up to exportPageSize books, or fewer if it reaches maxExportPageBytes, and links
+// to the next page with a Link header whose URL has the cursor parameter set.
+func (h *Handler) GetExport(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	input, errs := validateReq(req)
+	format := req.QueryStringParameters["format"]
+	if _, ok := exportContentTypes[format]; !ok {
+		errs = append(errs, ErrorInfo{"format", "format must be csv or jsonl", "query"})
+	}
+	if _, ok := req.QueryStringParameters["limit"]; ok {
+		errs = append(errs, ErrorInfo{"limit", "limit cannot be specified with export", "query"})
+	}
+	if len(errs) != 0 {
+		return response(http.StatusBadRequest, BestSellerBooksResponse{Errors: errs})
+	}
+
+	input.limit = int32(h.exportPageSize)
+	var books []types.BestSellerBook
+	var more bool
+	var err error
+	if input.list == nil && input.lookup == nil && input.from != nil {
+		books, more, err = h.queryDateRange(context.TODO(), input)
+	} else {
+		books, more, err = h.queryBooks(context.TODO(), input, int(input.limit))
+	}
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{}, err
+	}
+
+	var body bytes.Buffer
+	var write func(types.BestSellerBook) error
+	if format == "csv" {
+		w := csv.NewWriter(&body)
+		header := make([]string, len(exportColumns))
+		for i, c := range exportColumns {
+			header[i] = c.name
+		}
+		_ = w.Write(header)
+		row := make([]string, len(exportColumns))
+		write = func(b types.BestSellerBook) error {
+			for i, c := range exportColumns {
+				row[i] = c.value(b)
+			}
+			_ = w.Write(row)
+			w.Flush()
+			return w.Error()
+		}
+	} else {
+		enc := json.NewEncoder(&body)
+		write = func(b types.BestSellerBook) error {
+			return enc.Encode(b)
+		}
+	}
+
+	for i, b := range books {
+		if err := write(b); err != nil {
+			return events.APIGatewayV2HTTPResponse{}, fmt.Errorf("could not write export: %w", err)
+		}
+		if body.Len() >= maxExportPageBytes && i != len(books)-1 {
+			books, more = books[:i+1], true
+			break
+		}
+	}
+
+	res := events.APIGatewayV2HTTPResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"content-type":        exportContentTypes[format],
+			"content-disposition": fmt.Sprintf(`attachment; filename="books.%s"`, format),
+		},
+		Body: body.String(),
+	}
+	if more && len(books) != 0 {
+		last := books[len(books)-1]
+		next, err := encodeCursor(types.BookItemKey{ListEncodedName: last.ListEncodedName, DateSelected: last.DateSelected})
+		if err != nil {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+		query := url.Values{}
+		for k, v := range req.QueryStringParameters {
+			query.Set(k, v)
+		}
+		query.Set("cursor", next)
+		res.Headers["link"] = fmt.Sprintf(`</books/export?%s>; rel="next"`, query.Encode())
+	}
+	return h.cached(h.schedule, req, res, nil)
+}
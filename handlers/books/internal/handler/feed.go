@@ -0,0 +1,377 @@
+package handler
+
+import (
+	"bookoftheday/types"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// allListsFeed is the feed name for the books of every list.
+const allListsFeed = "all"
+
+// feedLimit is the most books in the feed of a list. allListsFeedDays is how many days
+// of books are in the all-lists feed, and allListsFeedLimit allows for a book from each
+// of the fewer than 60 lists the NYT publishes on every one of those days.
+const (
+	feedLimit         int32 = 50
+	allListsFeedDays        = 7
+	allListsFeedLimit int32 = allListsFeedDays * 60
+)
+
+const feedTitle = "Book of the Day"
+
+// Content types of each feed format, by the extension that requests it.
+var feedContentTypes = map[string]string{
+	"rss":  "application/rss+xml; charset=utf-8",
+	"atom": "application/atom+xml; charset=utf-8",
+	"json": "application/feed+json; charset=utf-8",
+}
+
+// GetFeed returns the recent books of a list, or of all lists, as an RSS 2.0, Atom 1.0,
+// or JSON Feed 1.1 document. The feed path parameter is the list name (or "all")
+// followed by the format's extension, such as hardcover-fiction.rss.
+func (h *Handler) GetFeed(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	feed := req.PathParameters["feed"]
+	ext := path.Ext(feed)
+	name := strings.TrimSuffix(feed, ext)
+	ext = strings.TrimPrefix(ext, ".")
+
+	contentType, ok := feedContentTypes[ext]
+	if !ok || !listRegexp.MatchString(name) {
+		return response(404, BestSellerBooksResponse{Errors: []ErrorInfo{
+			{"feed", "feed must be {list}.rss, {list}.atom, or {list}.json, where list can be all", "path"},
+		}})
+	}
+
+	input := requestParams{limit: feedLimit, descending: true}
+	var books []types.BestSellerBook
+	var err error
+	if name == allListsFeed {
+		today := h.now().UTC()
+		from := today.AddDate(0, 0, -(allListsFeedDays - 1)).Format(dateLayout)
+		to := today.Format(dateLayout)
+		input.from, input.to = &from, &to
+		input.limit = allListsFeedLimit
+		books, _, err = h.queryDateRange(context.TODO(), input)
+	} else {
+		input.list = &name
+		books, _, err = h.queryBooks(context.TODO(), input, int(input.limit))
+	}
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{}, err
+	}
+
+	f := newFeed(req, name, books)
+	if f.updated.IsZero() {
+		f.updated = h.now().UTC()
+	}
+	var body []byte
+	switch ext {
+	case "rss":
+		body, err = f.rss()
+	case "atom":
+		body, err = f.atom()
+	case "json":
+		body, err = f.jsonFeed()
+	}
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{}, fmt.Errorf("error marshalling %s feed: %w", ext, err)
+	}
+
+	return h.cached(h.schedule, req, events.APIGatewayV2HTTPResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"content-type": contentType,
+		},
+		Body: string(body),
+	}, nil)
+}
+
+// feed contains the data common to every feed format.
+type feed struct {
+	title       string
+	description string
+	selfURL     string
+	updated     time.Time
+	books       []types.BestSellerBook
+}
+
+func newFeed(req events.APIGatewayV2HTTPRequest, name string, books []types.BestSellerBook) feed {
+	f := feed{
+		title:       feedTitle,
+		description: "The book of the day from every New York Times Best Sellers list.",
+		selfURL:     fmt.Sprintf("https://%s/feed/%s", req.RequestContext.DomainName, url.PathEscape(req.PathParameters["feed"])),
+		books:       books,
+	}
+	if name != allListsFeed {
+		displayName := name
+		if len(books) != 0 && books[0].ListDisplayName != "" {
+			displayName = books[0].ListDisplayName
+		}
+		f.title = fmt.Sprintf("%s: %s", feedTitle, displayName)
+		f.description = fmt.Sprintf("The book of the day from the New York Times %s Best Sellers list.", displayName)
+	}
+	for _, b := range books {
+		if d := bookDate(b); d.After(f.updated) {
+			f.updated = d
+		}
+	}
+	return f
+}
+
+// bookID returns a tag URI for a book that stays the same for as long as it is in the
+// feed, because it is derived from the book's item key.
+func bookID(b types.BestSellerBook) string {
+	return fmt.Sprintf("tag:jtaylorsoftware.com,2022:bookoftheday/%s/%s", b.ListEncodedName, b.DateSelected)
+}
+
+func bookTitle(b types.BestSellerBook) string {
+	if b.Author == "" {
+		return b.Title
+	}
+	return fmt.Sprintf("%s by %s", b.Title, b.Author)
+}
+
+func bookDate(b types.BestSellerBook) time.Time {
+	d, _ := time.Parse(dateLayout, b.DateSelected)
+	return d
+}
+
+// imageType returns the MIME type of a cover image from its extension. NYT covers
+// are JPEGs unless their URL says otherwise.
+func imageType(imageURL string) string {
+	if u, err := url.Parse(imageURL); err == nil {
+		if t := mime.TypeByExtension(path.Ext(u.Path)); strings.HasPrefix(t, "image/") {
+			return t
+		}
+	}
+	return "image/jpeg"
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Copyright   string    `xml:"copyright"`
+	SelfLink    rssLink   `xml:"atom:link"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link,omitempty"`
+	Description string        `xml:"description,omitempty"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// rssEnclosure is a cover image. Its length is required but not known, so it is 0.
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int    `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func (f feed) rss() ([]byte, error) {
+	doc := rssDocument{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       f.title,
+			Link:        f.selfURL,
+			Description: f.description,
+			Copyright:   Attribution,
+			SelfLink:    rssLink{f.selfURL, "self", "application/rss+xml"},
+		},
+	}
+	for _, b := range f.books {
+		item := rssItem{
+			Title:       bookTitle(b),
+			Link:        b.AmazonProductURL,
+			Description: b.Description,
+			GUID:        rssGUID{false, bookID(b)},
+			PubDate:     bookDate(b).Format(time.RFC1123Z),
+		}
+		if b.ImageURL != "" {
+			item.Enclosure = &rssEnclosure{b.ImageURL, 0, imageType(b.ImageURL)}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return marshalXML(doc)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Rights  string      `xml:"rights"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// atomEntry always has content, because an entry without an alternate link must have
+// it (RFC 4287, section 4.1.1.1) and books may not have a link.
+type atomEntry struct {
+	ID        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Updated   string       `xml:"updated"`
+	Published string       `xml:"published"`
+	Authors   []atomPerson `xml:"author"`
+	Summary   string       `xml:"summary,omitempty"`
+	Content   atomContent  `xml:"content"`
+	Links     []atomLink   `xml:"link"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// atomEntryContent is the text content of a book's entry: its title and author, then its
+// description if it has one, and the attribution.
+func atomEntryContent(b types.BestSellerBook) string {
+	content := bookTitle(b) + "."
+	if b.Description != "" {
+		content += " " + b.Description
+	}
+	return content + " " + Attribution
+}
+
+func (f feed) atom() ([]byte, error) {
+	doc := atomFeed{
+		ID:      f.selfURL,
+		Title:   f.title,
+		Updated: f.updated.Format(time.RFC3339),
+		Author:  atomPerson{feedTitle},
+		Rights:  Attribution,
+		Links:   []atomLink{{Href: f.selfURL, Rel: "self", Type: "application/atom+xml"}},
+	}
+	for _, b := range f.books {
+		date := bookDate(b).Format(time.RFC3339)
+		entry := atomEntry{
+			ID:        bookID(b),
+			Title:     bookTitle(b),
+			Updated:   date,
+			Published: date,
+			Summary:   b.Description,
+			Content:   atomContent{"text", atomEntryContent(b)},
+		}
+		if b.Author != "" {
+			entry.Authors = []atomPerson{{b.Author}}
+		}
+		if b.AmazonProductURL != "" {
+			entry.Links = append(entry.Links, atomLink{Href: b.AmazonProductURL, Rel: "alternate", Type: "text/html"})
+		}
+		if b.ImageURL != "" {
+			entry.Links = append(entry.Links, atomLink{Href: b.ImageURL, Rel: "enclosure", Type: imageType(b.ImageURL)})
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return marshalXML(doc)
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description"`
+	Authors     []jsonAuthor   `json:"authors"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	Title         string           `json:"title"`
+	ContentText   string           `json:"content_text"`
+	Image         string           `json:"image,omitempty"`
+	DatePublished string           `json:"date_published"`
+	Authors       []jsonAuthor     `json:"authors,omitempty"`
+	Attachments   []jsonAttachment `json:"attachments,omitempty"`
+}
+
+type jsonAttachment struct {
+	URL      string `json:"url"`
+	MIMEType string `json:"mime_type"`
+}
+
+func (f feed) jsonFeed() ([]byte, error) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.title,
+		FeedURL:     f.selfURL,
+		Description: fmt.Sprintf("%s %s", f.description, Attribution),
+		Authors:     []jsonAuthor{{feedTitle}},
+		Items:       []jsonFeedItem{},
+	}
+	for _, b := range f.books {
+		item := jsonFeedItem{
+			ID:            bookID(b),
+			URL:           b.AmazonProductURL,
+			Title:         bookTitle(b),
+			ContentText:   b.Description,
+			Image:         b.ImageURL,
+			DatePublished: bookDate(b).Format(time.RFC3339),
+		}
+		if b.Author != "" {
+			item.Authors = []jsonAuthor{{b.Author}}
+		}
+		if b.ImageURL != "" {
+			item.Attachments = []jsonAttachment{{b.ImageURL, imageType(b.ImageURL)}}
+		}
+		doc.Items = append(doc.Items, item)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
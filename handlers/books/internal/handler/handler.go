@@ -2,19 +2,23 @@
 package handler
 
 import (
+	"bookoftheday/httpcache"
 	"bookoftheday/types"
 	"context"
 	"encoding/json"
 	"fmt"
 	"math"
+	"net/http"
 	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 // DynamoDBQueryPaginatorAPI is a convenience wrapper over DynamoDB query operations and is unit-testable.
@@ -33,15 +37,40 @@ type Handler struct {
 	queryClient       dynamodb.QueryAPIClient
 	newQueryPaginator DynamoDBNewQueryPaginatorAPI
 	tableName         string
+	now               func() time.Time
+	schedule          httpcache.Schedule
+	exportPageSize    int
 }
 
+// booksSchedule is when new books are selected. The state machine runs at midnight UTC,
+// and gets a book for one list at a time.
+var booksSchedule = httpcache.Daily(0, 0, 30*time.Minute)
+
 // New creates a new Handler instance.
 func New(qc dynamodb.QueryAPIClient, nqp DynamoDBNewQueryPaginatorAPI, tableName string) *Handler {
 	return &Handler{
 		qc,
 		nqp,
 		tableName,
+		time.Now,
+		booksSchedule,
+		defaultExportPageSize,
+	}
+}
+
+// Route calls the handler method for the route of req.
+func (h *Handler) Route(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	switch req.RouteKey {
+	case "GET /books":
+		return h.GetBooksOnDateInList(req)
+	case "GET /books/export":
+		return h.GetExport(req)
+	case "GET /books/today":
+		return h.GetBooksToday(req)
+	case "GET /feed/{feed}":
+		return h.GetFeed(req)
 	}
+	return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusNotFound}, nil
 }
 
 // BestSellerBooksResponse contains the response data from calling GetBooksOnDateInList successfully.
@@ -49,7 +78,14 @@ type BestSellerBooksResponse struct {
 	Count       *int                   `json:"count,omitempty"`
 	Attribution string                 `json:"attribution,omitempty"`
 	Books       []types.BestSellerBook `json:"books,omitempty"`
-	Errors      []ErrorInfo            `json:"errors,omitempty"`
+
+	// Next is passed in the cursor parameter to get the next page of books. It is
+	// empty on the last page.
+	Next string `json:"next,omitempty"`
+
+	// Date is the date that the books of GetBooksToday were selected on.
+	Date   string      `json:"date,omitempty"`
+	Errors []ErrorInfo `json:"errors,omitempty"`
 }
 
 // ErrorInfo contains information about errors in a request that resulted in an invalid response.
@@ -66,59 +102,195 @@ var listRegexp = regexp.MustCompile(`^[a-zA-Z]+(-[a-zA-Z]+)*$`)
 // limit is greater than this value.
 const defaultLimit int32 = 31
 
+// maxRangeDays is the most days that from and to can span without a list. Books are
+// only kept for a month, and each day in the range is a separate query.
+const maxRangeDays = 31
+
+const dateLayout = "2006-01-02"
+
+// lookup is a query for the books with a value of an indexed attribute.
+type lookup struct {
+	index     string
+	attribute string
+	value     string
+}
+
+// lookupParams are the query parameters that look books up by an index other than
+// the date. normalize returns the value to query for, or false if the parameter is
+// invalid.
+var lookupParams = []struct {
+	name      string
+	index     string
+	attribute string
+	message   string
+	normalize func(string) (string, bool)
+}{
+	{"isbn", "ISBNIndex", "PrimaryISBN13", "isbn must be a valid ISBN-10 or ISBN-13", toISBN13},
+	{"author", "AuthorIndex", "AuthorKey", "author must contain a letter or number", searchKey},
+	{"title", "TitleIndex", "TitleKey", "title must contain a letter or number", searchKey},
+}
+
+func searchKey(s string) (string, bool) {
+	key := types.SearchKey(s)
+	return key, key != ""
+}
+
 type requestParams struct {
 	limit      int32
 	date       *string
 	list       *string
 	dateOffset *string
+	from       *string
+	to         *string
+	descending bool
+	cursor     *types.BookItemKey
+	lookup     *lookup
 }
 
+// GetBooksOnDateInList returns a page of the books of the day for a list or date, or
+// with an ISBN, author, or title. Pages after the first are requested with the cursor
+// from the previous page.
 func (h *Handler) GetBooksOnDateInList(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
 	input, errors := validateReq(req)
 	if len(errors) != 0 {
 		return response(400, BestSellerBooksResponse{Errors: errors})
 	}
 
+	var books []types.BestSellerBook
+	var more bool
+	var err error
+	if input.list == nil && input.lookup == nil && input.from != nil {
+		books, more, err = h.queryDateRange(context.TODO(), input)
+	} else {
+		books, more, err = h.queryBooks(context.TODO(), input, int(input.limit))
+	}
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{}, err
+	}
+
+	count := len(books)
+	var next string
+	if more && count != 0 {
+		last := books[count-1]
+		next, err = encodeCursor(types.BookItemKey{ListEncodedName: last.ListEncodedName, DateSelected: last.DateSelected})
+		if err != nil {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+	}
+
+	res, err := response(200, BestSellerBooksResponse{
+		Count:       &count,
+		Attribution: Attribution,
+		Books:       books,
+		Next:        next,
+		Errors:      nil,
+	})
+	return h.cached(h.schedule, req, res, err)
+}
+
+const Attribution = types.Attribution
+
+// queryBooks reads up to limit books matching input, starting after its cursor. The
+// second return value reports whether there are more books after them.
+func (h *Handler) queryBooks(ctx context.Context, input requestParams, limit int) ([]types.BestSellerBook, bool, error) {
 	qInput := &dynamodb.QueryInput{
-		TableName: &h.tableName,
-		Limit:     &input.limit,
+		TableName:        &h.tableName,
+		Limit:            aws.Int32(int32(limit)),
+		ScanIndexForward: aws.Bool(!input.descending),
 	}
 
 	err := buildQuery(input, qInput)
 	if err != nil {
-		return events.APIGatewayV2HTTPResponse{}, fmt.Errorf("error building query: %w", err)
+		return nil, false, fmt.Errorf("error building query: %w", err)
+	}
+
+	if input.cursor != nil {
+		qInput.ExclusiveStartKey, err = attributevalue.MarshalMap(*input.cursor)
+		if err != nil {
+			return nil, false, fmt.Errorf("could not marshal cursor: %w", err)
+		}
+		// The start key of an index query also needs the index's key
+		if input.lookup != nil {
+			qInput.ExclusiveStartKey[input.lookup.attribute] = &ddbtypes.AttributeValueMemberS{Value: input.lookup.value}
+		}
 	}
 
 	p := h.newQueryPaginator(h.queryClient, qInput)
 
+	// Only read as many pages as it takes to fill the response
 	books := []types.BestSellerBook{}
-	for p.HasMorePages() {
-		out, err := p.NextPage(context.TODO())
+	for p.HasMorePages() && len(books) < limit {
+		out, err := p.NextPage(ctx)
 		if err != nil {
-			return events.APIGatewayV2HTTPResponse{}, fmt.Errorf("could not query books: %w", err)
+			return nil, false, fmt.Errorf("could not query books: %w", err)
 		}
 
 		if out.Count != 0 {
 			var data []types.BestSellerBook
 			err := attributevalue.UnmarshalListOfMaps(out.Items, &data)
 			if err != nil {
-				return events.APIGatewayV2HTTPResponse{}, fmt.Errorf("could not unmarshal books: %w", err)
+				return nil, false, fmt.Errorf("could not unmarshal books: %w", err)
 			}
 			books = append(books, data...)
 		}
 	}
 
-	count := clampMax(len(books), int(input.limit))
-	books = books[:count]
-	return response(200, BestSellerBooksResponse{
-		Count:       &count,
-		Attribution: Attribution,
-		Books:       books,
-		Errors:      nil,
-	})
+	count := clampMax(len(books), limit)
+	more := count < len(books) || p.HasMorePages()
+	return books[:count], more, nil
+}
+
+// queryDateRange reads up to input.limit books selected from input.from to input.to
+// in any list. DateSelectedIndex can only be queried for one date at a time, so
+// the dates are queried in turn, starting from the date of the cursor.
+func (h *Handler) queryDateRange(ctx context.Context, input requestParams) ([]types.BestSellerBook, bool, error) {
+	dates := datesBetween(*input.from, *input.to, input.descending)
+	start := 0
+	if input.cursor != nil {
+		for i, date := range dates {
+			if date == input.cursor.DateSelected {
+				start = i
+			}
+		}
+	}
+
+	books := []types.BestSellerBook{}
+	for i := start; i < len(dates); i++ {
+		day := input
+		day.from, day.to = nil, nil
+		day.date = &dates[i]
+		if i != start {
+			day.cursor = nil
+		}
+
+		dayBooks, more, err := h.queryBooks(ctx, day, int(input.limit)-len(books))
+		if err != nil {
+			return nil, false, err
+		}
+		books = append(books, dayBooks...)
+		if len(books) == int(input.limit) {
+			return books, more || i != len(dates)-1, nil
+		}
+	}
+	return books, false, nil
 }
 
-const Attribution = "Data provided by The New York Times: https://developer.nytimes.com"
+// datesBetween returns the dates from from to to inclusive, in descending order if descending is set.
+func datesBetween(from, to string, descending bool) []string {
+	start, _ := time.Parse(dateLayout, from)
+	end, _ := time.Parse(dateLayout, to)
+
+	var dates []string
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format(dateLayout))
+	}
+	if descending {
+		for i, j := 0, len(dates)-1; i < j; i, j = i+1, j-1 {
+			dates[i], dates[j] = dates[j], dates[i]
+		}
+	}
+	return dates
+}
 
 func buildQuery(input requestParams, query *dynamodb.QueryInput) error {
 	var kceList *expression.KeyConditionBuilder
@@ -128,10 +300,20 @@ func buildQuery(input requestParams, query *dynamodb.QueryInput) error {
 		kceList = &kce
 	}
 
-	// Assuming input has already been sanitized so only one of date or date-offset is set
+	// Assuming input has already been sanitized so only one of date, date-offset or
+	// a from and to range is set, and that a range only gets here with a list or lookup
 	if input.dateOffset != nil {
 		kce := expression.Key("DateSelected").GreaterThanEqual(expression.Value(*input.dateOffset))
 		kceDate = &kce
+	} else if input.from != nil && input.to != nil {
+		kce := expression.Key("DateSelected").Between(expression.Value(*input.from), expression.Value(*input.to))
+		kceDate = &kce
+	} else if input.from != nil {
+		kce := expression.Key("DateSelected").GreaterThanEqual(expression.Value(*input.from))
+		kceDate = &kce
+	} else if input.to != nil {
+		kce := expression.Key("DateSelected").LessThanEqual(expression.Value(*input.to))
+		kceDate = &kce
 	} else if input.date != nil {
 		kce := expression.Key("DateSelected").Equal(expression.Value(*input.date))
 		kceDate = &kce
@@ -143,6 +325,12 @@ func buildQuery(input requestParams, query *dynamodb.QueryInput) error {
 		if kceDate != nil {
 			kce = kce.And(*kceDate)
 		}
+	} else if input.lookup != nil {
+		kce = expression.Key(input.lookup.attribute).Equal(expression.Value(input.lookup.value))
+		if kceDate != nil {
+			kce = kce.And(*kceDate)
+		}
+		query.IndexName = aws.String(input.lookup.index)
 	} else {
 		// Assuming input has already been sanitized so one of list or date is set
 		kce = *kceDate
@@ -161,6 +349,12 @@ func buildQuery(input requestParams, query *dynamodb.QueryInput) error {
 	return nil
 }
 
+// isDate reports whether s is a valid date in the format of dateLayout.
+func isDate(s string) bool {
+	_, err := time.Parse(dateLayout, s)
+	return err == nil && dateRegexp.MatchString(s)
+}
+
 func validateReq(req events.APIGatewayV2HTTPRequest) (requestParams, []ErrorInfo) {
 	reqInput := requestParams{}
 	var errors []ErrorInfo
@@ -176,10 +370,10 @@ func validateReq(req events.APIGatewayV2HTTPRequest) (requestParams, []ErrorInfo
 	}
 
 	if qDate, ok := req.QueryStringParameters["date"]; ok {
-		if dateRegexp.MatchString(qDate) {
+		if isDate(qDate) {
 			reqInput.date = &qDate
 		} else {
-			errors = append(errors, ErrorInfo{"date", "date must be in the format yyyy-MM-dd", "query"})
+			errors = append(errors, ErrorInfo{"date", "date must be a date in the format yyyy-MM-dd", "query"})
 		}
 	}
 
@@ -192,24 +386,117 @@ func validateReq(req events.APIGatewayV2HTTPRequest) (requestParams, []ErrorInfo
 	}
 
 	if qDateOffset, ok := req.QueryStringParameters["date-offset"]; ok {
-		if dateRegexp.MatchString(qDateOffset) {
+		if isDate(qDateOffset) {
 			reqInput.dateOffset = &qDateOffset
 		} else {
-			errors = append(errors, ErrorInfo{"date-offset", "date-offset must be in the format yyyy-MM-dd", "query"})
+			errors = append(errors, ErrorInfo{"date-offset", "date-offset must be a date in the format yyyy-MM-dd", "query"})
+		}
+	}
+
+	for _, p := range []struct {
+		name  string
+		value **string
+	}{{"from", &reqInput.from}, {"to", &reqInput.to}} {
+		if qDate, ok := req.QueryStringParameters[p.name]; ok {
+			if isDate(qDate) {
+				*p.value = &qDate
+			} else {
+				errors = append(errors, ErrorInfo{p.name, fmt.Sprintf("%s must be a date in the format yyyy-MM-dd", p.name), "query"})
+			}
+		}
+	}
+
+	var lookups int
+	for _, p := range lookupParams {
+		if qValue, ok := req.QueryStringParameters[p.name]; ok {
+			lookups++
+			if value, valid := p.normalize(qValue); valid {
+				reqInput.lookup = &lookup{p.index, p.attribute, value}
+			} else {
+				errors = append(errors, ErrorInfo{p.name, p.message, "query"})
+			}
+		}
+	}
+
+	if qSort, ok := req.QueryStringParameters["sort"]; ok {
+		switch qSort {
+		case "asc":
+		case "desc":
+			reqInput.descending = true
+		default:
+			errors = append(errors, ErrorInfo{"sort", "sort must be asc or desc", "query"})
+		}
+	}
+
+	if qCursor, ok := req.QueryStringParameters["cursor"]; ok {
+		if key, err := decodeCursor(qCursor); err == nil {
+			reqInput.cursor = &key
+		} else {
+			errors = append(errors, ErrorInfo{"cursor", "cursor is invalid", "query"})
 		}
 	}
 
+	// The cursor's key has to be within the key condition of the query
+	if reqInput.cursor != nil {
+		if reqInput.list != nil && reqInput.cursor.ListEncodedName != *reqInput.list ||
+			reqInput.date != nil && reqInput.cursor.DateSelected != *reqInput.date ||
+			reqInput.from != nil && reqInput.cursor.DateSelected < *reqInput.from ||
+			reqInput.to != nil && reqInput.cursor.DateSelected > *reqInput.to {
+			errors = append(errors, ErrorInfo{"cursor", "cursor is not from a request with the same list and date", "query"})
+		}
+	}
+
+	if lookups > 1 {
+		errors = append(errors, ErrorInfo{Message: "only one of isbn, author, or title can be specified", Location: "query"})
+	}
+
+	if lookups != 0 && (reqInput.list != nil || reqInput.date != nil || reqInput.dateOffset != nil) {
+		errors = append(errors, ErrorInfo{Message: "isbn, author, and title cannot be specified with list, date, or date-offset", Location: "query"})
+	}
+
 	if reqInput.date != nil && reqInput.dateOffset != nil {
 		errors = append(errors, ErrorInfo{Message: "only one of date or date-offset can be specified", Location: "query"})
 	}
 
-	if reqInput.date == nil && reqInput.list == nil {
-		errors = append(errors, ErrorInfo{Message: "either list or date must be specified", Location: "query"})
+	hasRange := reqInput.from != nil || reqInput.to != nil
+	if hasRange && (reqInput.date != nil || reqInput.dateOffset != nil) {
+		errors = append(errors, ErrorInfo{Message: "from and to cannot be specified with date or date-offset", Location: "query"})
+	}
+
+	if reqInput.from != nil && reqInput.to != nil && *reqInput.from > *reqInput.to {
+		errors = append(errors, ErrorInfo{"to", "to must not be before from", "query"})
+	}
+
+	// Without a list or lookup, a range is read one date at a time from the DateSelectedIndex
+	if hasRange && reqInput.list == nil && lookups == 0 {
+		if reqInput.from == nil || reqInput.to == nil {
+			errors = append(errors, ErrorInfo{Message: "both from and to must be specified without list", Location: "query"})
+		} else if from, to := *reqInput.from, *reqInput.to; from <= to && len(datesBetween(from, to, false)) > maxRangeDays {
+			errors = append(errors, ErrorInfo{Message: fmt.Sprintf("from and to can span at most %d days without list", maxRangeDays), Location: "query"})
+		}
+	}
+
+	if reqInput.date == nil && reqInput.list == nil && !hasRange && lookups == 0 {
+		errors = append(errors, ErrorInfo{Message: "either list, date, from and to, or one of isbn, author, or title must be specified", Location: "query"})
 	}
 
 	return reqInput, errors
 }
 
+// cached adds caching headers for data that changes on schedule to a successful response,
+// or returns 304 Not Modified instead if the request's conditions show that the client
+// already has it.
+func (h *Handler) cached(schedule httpcache.Schedule, req events.APIGatewayV2HTTPRequest, res events.APIGatewayV2HTTPResponse, err error) (events.APIGatewayV2HTTPResponse, error) {
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+	if schedule.Apply(req.Headers, res.Headers, res.Body, h.now()) {
+		delete(res.Headers, "content-type")
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusNotModified, Headers: res.Headers}, nil
+	}
+	return res, nil
+}
+
 func clampMax(v, max int) int {
 	return int(math.Min(float64(v), float64(max)))
 }
@@ -1,16 +1,26 @@
 package handler
 
 import (
+	"bookoftheday/openapi"
 	books "bookoftheday/types"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -181,19 +191,31 @@ func TestHandler(t *testing.T) {
 			resBody := BestSellerBooksResponse{}
 			_ = json.Unmarshal([]byte(res.Body), &resBody)
 
-			if err != nil && !errors.Is(err, tc.err) {
-				t.Errorf("got error %v; expected %v", err, tc.err)
+			// The handler stops reading pages once it has enough books for the response,
+			// so an error on a later page is never reached
+			expPages, read := 0, 0
+			for expPages < tc.numPages && read < clampMax(tc.limit, int(defaultLimit)) {
+				read += clampMax(tc.countPerPage, tc.limit-read)
+				expPages++
+			}
+			expErr := tc.err
+			if tc.pageErrorNum >= expPages {
+				expErr = nil
+			}
+
+			if !errors.Is(err, expErr) {
+				t.Errorf("got error %v; expected %v", err, expErr)
 			}
 
-			if tc.err != nil && qs.p != tc.pageErrorNum {
+			if expErr != nil && qs.p != tc.pageErrorNum {
 				t.Errorf("handler continued processing despite error at page %d", tc.pageErrorNum)
 			}
 
-			if tc.err == nil && qs.p != tc.numPages {
-				t.Errorf("handler did not process all pages: got %d; num pages: %d", qs.p, tc.numPages)
+			if expErr == nil && qs.p != expPages {
+				t.Errorf("handler read wrong number of pages: got %d; expected %d", qs.p, expPages)
 			}
 
-			if tc.err == nil {
+			if expErr == nil {
 				outLen := len(resBody.Books)
 				expLen := int(math.Min(float64(tc.numPages*tc.countPerPage), math.Min(float64(tc.limit), float64(defaultLimit))))
 				if outLen != *resBody.Count {
@@ -206,7 +228,7 @@ func TestHandler(t *testing.T) {
 
 				for i, p := range pages {
 					s := i * tc.countPerPage
-					if s > tc.limit {
+					if s >= tc.limit {
 						break
 					}
 					e := clampMax(s+tc.countPerPage, tc.limit)
@@ -214,7 +236,7 @@ func TestHandler(t *testing.T) {
 						e = len(resBody.Books)
 					}
 					ep := clampMax(len(p), clampMax(tc.limit, int(defaultLimit)))
-					if diff := cmp.Diff(p[:ep], resBody.Books[s:e], cmpopts.IgnoreFields(books.BestSellerBook{}, "Expiration")); diff != "" {
+					if diff := cmp.Diff(p[:ep], resBody.Books[s:e], cmpopts.IgnoreFields(books.BestSellerBook{}, "Expiration", "TitleKey", "AuthorKey")); diff != "" {
 						t.Errorf("segment [%d:%d] of resBody.Books was not equal to input (page-%d)[:%d] (-want +got):\n%s", s, e, i, ep, diff)
 					}
 				}
@@ -324,3 +346,990 @@ func describePStr(s *string) string {
 	}
 	return *s
 }
+
+func TestCursor(t *testing.T) {
+	page := func(date string) []books.BestSellerBook {
+		var p []books.BestSellerBook
+		for _, list := range []string{"hardcover-fiction", "manga"} {
+			p = append(p, books.BestSellerBook{ListEncodedName: list, DateSelected: date})
+		}
+		return p
+	}
+	pages := [][]books.BestSellerBook{page("2022-06-01"), page("2022-06-02")}
+
+	get := func(t *testing.T, query map[string]string, startKey *books.BookItemKey) (int, BestSellerBooksResponse) {
+		qs := &stubDynamoDBQueryPaginatorAPI{np: len(pages), limit: 2, pages: pages}
+		nqp := func(client dynamodb.QueryAPIClient, params *dynamodb.QueryInput, optFns ...func(*dynamodb.QueryPaginatorOptions)) DynamoDBQueryPaginatorAPI {
+			var got *books.BookItemKey
+			if params.ExclusiveStartKey != nil {
+				got = &books.BookItemKey{}
+				if err := attributevalue.UnmarshalMap(params.ExclusiveStartKey, got); err != nil {
+					t.Fatalf("could not unmarshal ExclusiveStartKey: %v", err)
+				}
+			}
+			if diff := cmp.Diff(startKey, got); diff != "" {
+				t.Errorf("NewQueryPaginator: wrong params.ExclusiveStartKey (-want +got):\n%s", diff)
+			}
+			return qs
+		}
+		h := New(&dummyQueryAPIClient{}, nqp, TableName)
+
+		res, err := h.GetBooksOnDateInList(events.APIGatewayV2HTTPRequest{QueryStringParameters: query})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		var body BestSellerBooksResponse
+		_ = json.Unmarshal([]byte(res.Body), &body)
+		return res.StatusCode, body
+	}
+
+	t.Run("returns cursor for the last book when there are more pages", func(t *testing.T) {
+		status, body := get(t, map[string]string{"list": "manga", "limit": "2"}, nil)
+		if status != 200 {
+			t.Fatalf("unexpected StatusCode value: got %d; expected 200", status)
+		}
+		key, err := decodeCursor(body.Next)
+		if err != nil {
+			t.Fatalf("could not decode cursor %q: %v", body.Next, err)
+		}
+		want := books.BookItemKey{ListEncodedName: "manga", DateSelected: "2022-06-01"}
+		if diff := cmp.Diff(want, key); diff != "" {
+			t.Errorf("cursor has wrong key (-want +got):\n%s", diff)
+		}
+
+		// The cursor is passed on to DynamoDB as the key to start after
+		status, _ = get(t, map[string]string{"list": "manga", "limit": "2", "cursor": body.Next}, &want)
+		if status != 200 {
+			t.Errorf("unexpected StatusCode value: got %d; expected 200", status)
+		}
+	})
+
+	t.Run("returns no cursor on the last page", func(t *testing.T) {
+		_, body := get(t, map[string]string{"date": "2022-06-01", "limit": "5"}, nil)
+		if body.Next != "" {
+			t.Errorf("got cursor %q; expected none", body.Next)
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		query map[string]string
+	}{
+		{"malformed cursor", map[string]string{"list": "manga", "cursor": "not a cursor"}},
+		{"cursor with invalid key", map[string]string{"list": "manga", "cursor": mustEncodeCursor(t, books.BookItemKey{ListEncodedName: "manga", DateSelected: "today"})}},
+		{"cursor for another list", map[string]string{"list": "manga", "cursor": mustEncodeCursor(t, books.BookItemKey{ListEncodedName: "hardcover-fiction", DateSelected: "2022-06-01"})}},
+		{"cursor for another date", map[string]string{"date": "2022-06-02", "cursor": mustEncodeCursor(t, books.BookItemKey{ListEncodedName: "manga", DateSelected: "2022-06-01"})}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := New(&dummyQueryAPIClient{}, nil, TableName).GetBooksOnDateInList(events.APIGatewayV2HTTPRequest{QueryStringParameters: tc.query})
+			if err != nil {
+				t.Fatalf("unexpected error: got %v; expected nil", err)
+			}
+			var body BestSellerBooksResponse
+			_ = json.Unmarshal([]byte(res.Body), &body)
+			if res.StatusCode != 400 || containsError(body.Errors, "cursor") == -1 {
+				t.Errorf("got StatusCode %d with errors %v; expected 400 with cursor error", res.StatusCode, body.Errors)
+			}
+		})
+	}
+}
+
+func mustEncodeCursor(t *testing.T, key books.BookItemKey) string {
+	c, err := encodeCursor(key)
+	if err != nil {
+		t.Fatalf("could not encode cursor: %v", err)
+	}
+	return c
+}
+
+// recordingQueryPaginatorProvider returns the books in byDate for the date in each
+// query's key condition, and records the queries it was given.
+type recordingQueryPaginatorProvider struct {
+	byDate  map[string][]books.BestSellerBook
+	queries []*dynamodb.QueryInput
+}
+
+func (r *recordingQueryPaginatorProvider) newQueryPaginator(
+	client dynamodb.QueryAPIClient, params *dynamodb.QueryInput, optFns ...func(*dynamodb.QueryPaginatorOptions),
+) DynamoDBQueryPaginatorAPI {
+	r.queries = append(r.queries, params)
+
+	var page []books.BestSellerBook
+	for _, v := range params.ExpressionAttributeValues {
+		if s, ok := v.(*types.AttributeValueMemberS); ok {
+			page = append(page, r.byDate[s.Value]...)
+		}
+	}
+	if params.ExclusiveStartKey != nil {
+		var key books.BookItemKey
+		_ = attributevalue.UnmarshalMap(params.ExclusiveStartKey, &key)
+		for i, b := range page {
+			if b.ListEncodedName == key.ListEncodedName {
+				page = page[i+1:]
+				break
+			}
+		}
+	}
+	// Return the whole page regardless of Limit, so that the handler sees there are more books
+	return &stubDynamoDBQueryPaginatorAPI{np: 1, limit: len(page), pages: [][]books.BestSellerBook{page}}
+}
+
+func (r *recordingQueryPaginatorProvider) queriedValues() []string {
+	var values []string
+	for _, q := range r.queries {
+		var vs []string
+		for _, v := range q.ExpressionAttributeValues {
+			vs = append(vs, v.(*types.AttributeValueMemberS).Value)
+		}
+		sort.Strings(vs)
+		values = append(values, strings.Join(vs, ","))
+	}
+	return values
+}
+
+func TestDateRange(t *testing.T) {
+	byDate := map[string][]books.BestSellerBook{
+		"2022-06-01": {{ListEncodedName: "hardcover-fiction", DateSelected: "2022-06-01"}, {ListEncodedName: "manga", DateSelected: "2022-06-01"}},
+		"2022-06-03": {{ListEncodedName: "hardcover-fiction", DateSelected: "2022-06-03"}, {ListEncodedName: "manga", DateSelected: "2022-06-03"}},
+	}
+	get := func(t *testing.T, query map[string]string) (*recordingQueryPaginatorProvider, BestSellerBooksResponse) {
+		r := &recordingQueryPaginatorProvider{byDate: byDate}
+		res, err := New(&dummyQueryAPIClient{}, r.newQueryPaginator, TableName).GetBooksOnDateInList(events.APIGatewayV2HTTPRequest{QueryStringParameters: query})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		var body BestSellerBooksResponse
+		_ = json.Unmarshal([]byte(res.Body), &body)
+		if res.StatusCode != 200 {
+			t.Fatalf("unexpected StatusCode value: got %d; expected 200 (errors %v)", res.StatusCode, body.Errors)
+		}
+		return r, body
+	}
+
+	t.Run("uses BETWEEN with a list", func(t *testing.T) {
+		r, _ := get(t, map[string]string{"list": "manga", "from": "2022-06-01", "to": "2022-06-30", "sort": "desc"})
+		if len(r.queries) != 1 {
+			t.Fatalf("got %d queries; expected 1", len(r.queries))
+		}
+		q := r.queries[0]
+		if !strings.Contains(*q.KeyConditionExpression, "BETWEEN") {
+			t.Errorf("got KeyConditionExpression %s; expected BETWEEN condition", *q.KeyConditionExpression)
+		}
+		if q.IndexName != nil {
+			t.Errorf("got IndexName %s; expected query on table", *q.IndexName)
+		}
+		if q.ScanIndexForward == nil || *q.ScanIndexForward {
+			t.Errorf("got ScanIndexForward %v; expected false", q.ScanIndexForward)
+		}
+		if diff := cmp.Diff([]string{"2022-06-01,2022-06-30,manga"}, r.queriedValues()); diff != "" {
+			t.Errorf("wrong query values (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("queries each date without a list", func(t *testing.T) {
+		r, body := get(t, map[string]string{"from": "2022-06-01", "to": "2022-06-03", "sort": "desc", "limit": "3"})
+		for _, q := range r.queries {
+			if q.IndexName == nil || *q.IndexName != "DateSelectedIndex" {
+				t.Errorf("got IndexName %v; expected DateSelectedIndex", q.IndexName)
+			}
+		}
+		if diff := cmp.Diff([]string{"2022-06-03", "2022-06-02", "2022-06-01"}, r.queriedValues()); diff != "" {
+			t.Errorf("dates queried in wrong order (-want +got):\n%s", diff)
+		}
+		want := append(append([]books.BestSellerBook{}, byDate["2022-06-03"]...), byDate["2022-06-01"][0])
+		if diff := cmp.Diff(want, body.Books); diff != "" {
+			t.Errorf("wrong books (-want +got):\n%s", diff)
+		}
+
+		// The next page starts after the last book, on the same date
+		r, body = get(t, map[string]string{"from": "2022-06-01", "to": "2022-06-03", "sort": "desc", "limit": "3", "cursor": body.Next})
+		if diff := cmp.Diff([]string{"2022-06-01"}, r.queriedValues()); diff != "" {
+			t.Errorf("wrong dates queried for next page (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(byDate["2022-06-01"][1:], body.Books); diff != "" {
+			t.Errorf("wrong books on next page (-want +got):\n%s", diff)
+		}
+		if body.Next != "" {
+			t.Errorf("got cursor %q on last page; expected none", body.Next)
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		query map[string]string
+	}{
+		{"to before from", map[string]string{"list": "manga", "from": "2022-06-03", "to": "2022-06-01"}},
+		{"invalid from", map[string]string{"list": "manga", "from": "2022-02-30"}},
+		{"invalid to", map[string]string{"list": "manga", "to": "June"}},
+		{"range with date", map[string]string{"list": "manga", "date": "2022-06-01", "from": "2022-06-01"}},
+		{"range with date-offset", map[string]string{"list": "manga", "date-offset": "2022-06-01", "to": "2022-06-03"}},
+		{"open range without list", map[string]string{"from": "2022-06-01"}},
+		{"long range without list", map[string]string{"from": "2022-01-01", "to": "2022-06-01"}},
+		{"invalid sort", map[string]string{"list": "manga", "sort": "up"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := New(&dummyQueryAPIClient{}, nil, TableName).GetBooksOnDateInList(events.APIGatewayV2HTTPRequest{QueryStringParameters: tc.query})
+			if err != nil {
+				t.Fatalf("unexpected error: got %v; expected nil", err)
+			}
+			if res.StatusCode != 400 {
+				t.Errorf("unexpected StatusCode value: got %d; expected 400", res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestToISBN13(t *testing.T) {
+	testCases := []struct {
+		isbn  string
+		want  string
+		valid bool
+	}{
+		{"9780385737951", "9780385737951", true},
+		{"978-0-385-73795-1", "9780385737951", true},
+		{"0385737955", "9780385737951", true},
+		{"0 385 73795 5", "9780385737951", true},
+		{"080442957X", "9780804429573", true},
+		{"080442957x", "9780804429573", true},
+		{"0385737954", "", false},
+		{"9780385737952", "", false},
+		{"X385737955", "", false},
+		{"97803857379", "", false},
+		{"", "", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.isbn, func(t *testing.T) {
+			got, valid := toISBN13(tc.isbn)
+			if got != tc.want || valid != tc.valid {
+				t.Errorf("got %q, %v; expected %q, %v", got, valid, tc.want, tc.valid)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	byValue := map[string][]books.BestSellerBook{
+		"9780385737951": {{ListEncodedName: "young-adult", DateSelected: "2022-06-01", PrimaryISBN13: "9780385737951"}},
+		"delia owens": {
+			{ListEncodedName: "hardcover-fiction", DateSelected: "2022-06-01", Author: "Delia Owens"},
+			{ListEncodedName: "trade-fiction-paperback", DateSelected: "2022-06-02", Author: "Delia Owens"},
+		},
+		"where the crawdads sing": {{ListEncodedName: "hardcover-fiction", DateSelected: "2022-06-01", Title: "WHERE THE CRAWDADS SING"}},
+	}
+	get := func(t *testing.T, query map[string]string) (*recordingQueryPaginatorProvider, BestSellerBooksResponse) {
+		r := &recordingQueryPaginatorProvider{byDate: byValue}
+		res, err := New(&dummyQueryAPIClient{}, r.newQueryPaginator, TableName).GetBooksOnDateInList(events.APIGatewayV2HTTPRequest{QueryStringParameters: query})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		var body BestSellerBooksResponse
+		_ = json.Unmarshal([]byte(res.Body), &body)
+		if res.StatusCode != 200 {
+			t.Fatalf("unexpected StatusCode value: got %d; expected 200 (errors %v)", res.StatusCode, body.Errors)
+		}
+		if len(r.queries) != 1 {
+			t.Fatalf("got %d queries; expected 1", len(r.queries))
+		}
+		return r, body
+	}
+
+	lookupCases := []struct {
+		name  string
+		query map[string]string
+		index string
+		value string
+	}{
+		{"isbn-10 is converted to isbn-13", map[string]string{"isbn": "0-385-73795-5"}, "ISBNIndex", "9780385737951"},
+		{"isbn-13", map[string]string{"isbn": "9780385737951"}, "ISBNIndex", "9780385737951"},
+		{"author ignores case and punctuation", map[string]string{"author": "  delia OWENS."}, "AuthorIndex", "delia owens"},
+		{"title ignores case and punctuation", map[string]string{"title": "Where the Crawdads Sing!"}, "TitleIndex", "where the crawdads sing"},
+	}
+	for _, tc := range lookupCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, body := get(t, tc.query)
+			q := r.queries[0]
+			if q.IndexName == nil || *q.IndexName != tc.index {
+				t.Errorf("got IndexName %v; expected %s", q.IndexName, tc.index)
+			}
+			if diff := cmp.Diff([]string{tc.value}, r.queriedValues()); diff != "" {
+				t.Errorf("wrong values queried (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(byValue[tc.value], body.Books); diff != "" {
+				t.Errorf("wrong books (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("narrows a lookup with from and to", func(t *testing.T) {
+		r, _ := get(t, map[string]string{"author": "Delia Owens", "from": "2022-06-01", "to": "2022-06-30"})
+		q := r.queries[0]
+		if !strings.Contains(*q.KeyConditionExpression, "BETWEEN") {
+			t.Errorf("got KeyConditionExpression %s; expected BETWEEN condition", *q.KeyConditionExpression)
+		}
+		if diff := cmp.Diff([]string{"2022-06-01,2022-06-30,delia owens"}, r.queriedValues()); diff != "" {
+			t.Errorf("wrong values queried (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("pages with a cursor that includes the index key", func(t *testing.T) {
+		_, first := get(t, map[string]string{"author": "Delia Owens", "limit": "1"})
+		if first.Next == "" {
+			t.Fatal("got no cursor; expected one for the next page")
+		}
+
+		r, next := get(t, map[string]string{"author": "Delia Owens", "limit": "1", "cursor": first.Next})
+		want := map[string]string{"ListEncodedName": "hardcover-fiction", "DateSelected": "2022-06-01", "AuthorKey": "delia owens"}
+		var got map[string]string
+		_ = attributevalue.UnmarshalMap(r.queries[0].ExclusiveStartKey, &got)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("wrong ExclusiveStartKey (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(byValue["delia owens"][1:], next.Books); diff != "" {
+			t.Errorf("wrong books on next page (-want +got):\n%s", diff)
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		query map[string]string
+		field string
+	}{
+		{"invalid isbn", map[string]string{"isbn": "0385737954"}, "isbn"},
+		{"empty author", map[string]string{"author": "!!"}, "author"},
+		{"empty title", map[string]string{"title": ""}, "title"},
+		{"isbn and author", map[string]string{"isbn": "0385737955", "author": "Delia Owens"}, ""},
+		{"lookup with list", map[string]string{"title": "Where the Crawdads Sing", "list": "manga"}, ""},
+		{"lookup with date", map[string]string{"title": "Where the Crawdads Sing", "date": "2022-06-01"}, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := New(&dummyQueryAPIClient{}, nil, TableName).GetBooksOnDateInList(events.APIGatewayV2HTTPRequest{QueryStringParameters: tc.query})
+			if err != nil {
+				t.Fatalf("unexpected error: got %v; expected nil", err)
+			}
+			if res.StatusCode != 400 {
+				t.Errorf("unexpected StatusCode value: got %d; expected 400", res.StatusCode)
+			}
+			var body BestSellerBooksResponse
+			_ = json.Unmarshal([]byte(res.Body), &body)
+			if containsError(body.Errors, tc.field) == -1 {
+				t.Errorf("missing error for field %q: got %v", tc.field, body.Errors)
+			}
+		})
+	}
+}
+
+func TestFeed(t *testing.T) {
+	now := time.Date(2022, 6, 3, 12, 0, 0, 0, time.UTC)
+	byValue := map[string][]books.BestSellerBook{
+		"manga": {
+			{ListEncodedName: "manga", ListDisplayName: "Graphic Books and Manga", DateSelected: "2022-06-03", Title: "ONE PIECE, VOL. 100", Author: "Eiichiro Oda",
+				Description: "Luffy & the crew", AmazonProductURL: "https://www.amazon.com/dp/1974728633", ImageURL: "https://storage.googleapis.com/du-prd/books/images/9781974728633.jpg"},
+			{ListEncodedName: "manga", ListDisplayName: "Graphic Books and Manga", DateSelected: "2022-06-02", Title: "HEARTSTOPPER, VOL. 1", Author: "Alice Oseman"},
+		},
+		"2022-06-03": {{ListEncodedName: "hardcover-fiction", DateSelected: "2022-06-03", Title: "THE HOTEL NANTUCKET"}},
+		"2022-05-28": {{ListEncodedName: "hardcover-fiction", DateSelected: "2022-05-28", Title: "DREAM TOWN"}},
+	}
+	get := func(t *testing.T, feed string) (*recordingQueryPaginatorProvider, events.APIGatewayV2HTTPResponse) {
+		r := &recordingQueryPaginatorProvider{byDate: byValue}
+		h := New(&dummyQueryAPIClient{}, r.newQueryPaginator, TableName)
+		h.now = func() time.Time { return now }
+		res, err := h.Route(events.APIGatewayV2HTTPRequest{
+			RouteKey:       "GET /feed/{feed}",
+			PathParameters: map[string]string{"feed": feed},
+			RequestContext: events.APIGatewayV2HTTPRequestContext{DomainName: "api.example.com"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		return r, res
+	}
+
+	t.Run("rss", func(t *testing.T) {
+		_, res := get(t, "manga.rss")
+		if res.StatusCode != 200 || res.Headers["content-type"] != "application/rss+xml; charset=utf-8" {
+			t.Fatalf("got StatusCode %d and content-type %q; expected 200 RSS", res.StatusCode, res.Headers["content-type"])
+		}
+		var doc struct {
+			Version string `xml:"version,attr"`
+			Channel struct {
+				Title     string `xml:"title"`
+				Copyright string `xml:"copyright"`
+				Items     []struct {
+					Title     string `xml:"title"`
+					GUID      string `xml:"guid"`
+					PubDate   string `xml:"pubDate"`
+					Enclosure *struct {
+						URL  string `xml:"url,attr"`
+						Type string `xml:"type,attr"`
+					} `xml:"enclosure"`
+				} `xml:"item"`
+			} `xml:"channel"`
+		}
+		if err := xml.Unmarshal([]byte(res.Body), &doc); err != nil {
+			t.Fatalf("invalid XML: %v", err)
+		}
+		if doc.Version != "2.0" || doc.Channel.Title != "Book of the Day: Graphic Books and Manga" || doc.Channel.Copyright != Attribution {
+			t.Errorf("wrong channel: got version %q, title %q, copyright %q", doc.Version, doc.Channel.Title, doc.Channel.Copyright)
+		}
+		if len(doc.Channel.Items) != 2 {
+			t.Fatalf("got %d items; expected 2", len(doc.Channel.Items))
+		}
+		item := doc.Channel.Items[0]
+		if item.Title != "ONE PIECE, VOL. 100 by Eiichiro Oda" || item.GUID != "tag:jtaylorsoftware.com,2022:bookoftheday/manga/2022-06-03" ||
+			item.PubDate != "Fri, 03 Jun 2022 00:00:00 +0000" {
+			t.Errorf("wrong item: got %+v", item)
+		}
+		if item.Enclosure == nil || item.Enclosure.URL != byValue["manga"][0].ImageURL || item.Enclosure.Type != "image/jpeg" {
+			t.Errorf("wrong enclosure: got %+v", item.Enclosure)
+		}
+		if doc.Channel.Items[1].Enclosure != nil {
+			t.Errorf("got enclosure %+v for book without image; expected none", doc.Channel.Items[1].Enclosure)
+		}
+	})
+
+	t.Run("atom", func(t *testing.T) {
+		_, res := get(t, "manga.atom")
+		if res.StatusCode != 200 || res.Headers["content-type"] != "application/atom+xml; charset=utf-8" {
+			t.Fatalf("got StatusCode %d and content-type %q; expected 200 Atom", res.StatusCode, res.Headers["content-type"])
+		}
+		var doc struct {
+			XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+			Updated string   `xml:"updated"`
+			Rights  string   `xml:"rights"`
+			Entries []struct {
+				ID      string `xml:"id"`
+				Content struct {
+					Type  string `xml:"type,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"content"`
+				Links []struct {
+					Href string `xml:"href,attr"`
+					Rel  string `xml:"rel,attr"`
+				} `xml:"link"`
+			} `xml:"entry"`
+		}
+		if err := xml.Unmarshal([]byte(res.Body), &doc); err != nil {
+			t.Fatalf("invalid Atom feed: %v", err)
+		}
+		if doc.Updated != "2022-06-03T00:00:00Z" || doc.Rights != Attribution {
+			t.Errorf("wrong feed: got updated %q, rights %q", doc.Updated, doc.Rights)
+		}
+		if len(doc.Entries) != 2 || doc.Entries[1].ID != "tag:jtaylorsoftware.com,2022:bookoftheday/manga/2022-06-02" {
+			t.Fatalf("wrong entries: got %+v", doc.Entries)
+		}
+		var enclosure string
+		for _, l := range doc.Entries[0].Links {
+			if l.Rel == "enclosure" {
+				enclosure = l.Href
+			}
+		}
+		if enclosure != byValue["manga"][0].ImageURL {
+			t.Errorf("got enclosure %q; expected %q", enclosure, byValue["manga"][0].ImageURL)
+		}
+
+		// The second book has no link or description, so its entry needs content
+		wantContent := []string{
+			"ONE PIECE, VOL. 100 by Eiichiro Oda. Luffy & the crew " + Attribution,
+			"HEARTSTOPPER, VOL. 1 by Alice Oseman. " + Attribution,
+		}
+		for i, want := range wantContent {
+			if c := doc.Entries[i].Content; c.Type != "text" || c.Value != want {
+				t.Errorf("entry %d: got content %+v; expected text %q", i, c, want)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		_, res := get(t, "manga.json")
+		if res.StatusCode != 200 || res.Headers["content-type"] != "application/feed+json; charset=utf-8" {
+			t.Fatalf("got StatusCode %d and content-type %q; expected 200 JSON Feed", res.StatusCode, res.Headers["content-type"])
+		}
+		var doc jsonFeed
+		if err := json.Unmarshal([]byte(res.Body), &doc); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if doc.Version != "https://jsonfeed.org/version/1.1" || doc.FeedURL != "https://api.example.com/feed/manga.json" ||
+			!strings.Contains(doc.Description, Attribution) {
+			t.Errorf("wrong feed: got %+v", doc)
+		}
+		want := jsonFeedItem{
+			ID:            "tag:jtaylorsoftware.com,2022:bookoftheday/manga/2022-06-03",
+			URL:           "https://www.amazon.com/dp/1974728633",
+			Title:         "ONE PIECE, VOL. 100 by Eiichiro Oda",
+			ContentText:   "Luffy & the crew",
+			Image:         byValue["manga"][0].ImageURL,
+			DatePublished: "2022-06-03T00:00:00Z",
+			Authors:       []jsonAuthor{{"Eiichiro Oda"}},
+			Attachments:   []jsonAttachment{{byValue["manga"][0].ImageURL, "image/jpeg"}},
+		}
+		if len(doc.Items) != 2 {
+			t.Fatalf("got %d items; expected 2", len(doc.Items))
+		}
+		if diff := cmp.Diff(want, doc.Items[0]); diff != "" {
+			t.Errorf("wrong item (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("all lists reads the last week", func(t *testing.T) {
+		r, res := get(t, "all.json")
+		var doc jsonFeed
+		_ = json.Unmarshal([]byte(res.Body), &doc)
+		if len(doc.Items) != 2 || doc.Items[0].Title != "THE HOTEL NANTUCKET" || doc.Items[1].Title != "DREAM TOWN" {
+			t.Errorf("wrong items: got %+v", doc.Items)
+		}
+		want := []string{"2022-06-03", "2022-06-02", "2022-06-01", "2022-05-31", "2022-05-30", "2022-05-29", "2022-05-28"}
+		if diff := cmp.Diff(want, r.queriedValues()); diff != "" {
+			t.Errorf("wrong dates queried (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("all lists has every book of the last week", func(t *testing.T) {
+		byDate := map[string][]books.BestSellerBook{}
+		for day := 0; day < 7; day++ {
+			date := now.AddDate(0, 0, -day).Format("2006-01-02")
+			for list := 0; list < 10; list++ {
+				byDate[date] = append(byDate[date], books.BestSellerBook{ListEncodedName: fmt.Sprintf("list-%d", list), DateSelected: date})
+			}
+		}
+		r := &recordingQueryPaginatorProvider{byDate: byDate}
+		h := New(&dummyQueryAPIClient{}, r.newQueryPaginator, TableName)
+		h.now = func() time.Time { return now }
+		res, err := h.Route(events.APIGatewayV2HTTPRequest{
+			RouteKey:       "GET /feed/{feed}",
+			PathParameters: map[string]string{"feed": "all.json"},
+			RequestContext: events.APIGatewayV2HTTPRequestContext{DomainName: "api.example.com"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		var doc jsonFeed
+		_ = json.Unmarshal([]byte(res.Body), &doc)
+		if len(doc.Items) != 70 {
+			t.Errorf("got %d items; expected 70", len(doc.Items))
+		}
+	})
+
+	t.Run("empty feed is updated now", func(t *testing.T) {
+		_, res := get(t, "hardcover-nonfiction.atom")
+		if !strings.Contains(res.Body, "<updated>2022-06-03T12:00:00Z</updated>") {
+			t.Errorf("got feed without current updated time:\n%s", res.Body)
+		}
+	})
+
+	for _, feed := range []string{"manga", "manga.xml", "manga!.rss", ".rss"} {
+		t.Run("not found "+feed, func(t *testing.T) {
+			_, res := get(t, feed)
+			if res.StatusCode != 404 {
+				t.Errorf("unexpected StatusCode value: got %d; expected 404", res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestCaching(t *testing.T) {
+	now := time.Date(2022, 6, 3, 12, 0, 0, 0, time.UTC)
+	byValue := map[string][]books.BestSellerBook{
+		"manga": {{ListEncodedName: "manga", DateSelected: "2022-06-03"}},
+	}
+	get := func(t *testing.T, req events.APIGatewayV2HTTPRequest) events.APIGatewayV2HTTPResponse {
+		r := &recordingQueryPaginatorProvider{byDate: byValue}
+		h := New(&dummyQueryAPIClient{}, r.newQueryPaginator, TableName)
+		h.now = func() time.Time { return now }
+		res, err := h.Route(req)
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		return res
+	}
+
+	for _, req := range []events.APIGatewayV2HTTPRequest{
+		{RouteKey: "GET /books", QueryStringParameters: map[string]string{"list": "manga"}},
+		{RouteKey: "GET /feed/{feed}", PathParameters: map[string]string{"feed": "manga.rss"}},
+	} {
+		t.Run(req.RouteKey, func(t *testing.T) {
+			res := get(t, req)
+			if res.StatusCode != 200 {
+				t.Fatalf("unexpected StatusCode value: got %d; expected 200", res.StatusCode)
+			}
+			etag := res.Headers["etag"]
+			if etag == "" || res.Headers["last-modified"] != "Fri, 03 Jun 2022 00:00:00 GMT" || res.Headers["cache-control"] != "public, max-age=45000" {
+				t.Errorf("wrong caching headers: got %v", res.Headers)
+			}
+
+			req.Headers = map[string]string{"if-none-match": etag}
+			res = get(t, req)
+			if res.StatusCode != 304 || res.Body != "" || res.Headers["etag"] != etag {
+				t.Errorf("got StatusCode %d, body %q, and headers %v; expected 304 with etag %s", res.StatusCode, res.Body, res.Headers, etag)
+			}
+
+			req.Headers = map[string]string{"if-none-match": `"other"`}
+			if res = get(t, req); res.StatusCode != 200 {
+				t.Errorf("unexpected StatusCode value: got %d; expected 200", res.StatusCode)
+			}
+		})
+	}
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		res := get(t, events.APIGatewayV2HTTPRequest{RouteKey: "GET /books", QueryStringParameters: map[string]string{"list": "!"}})
+		if _, ok := res.Headers["etag"]; res.StatusCode != 400 || ok {
+			t.Errorf("got StatusCode %d and headers %v; expected 400 without etag", res.StatusCode, res.Headers)
+		}
+	})
+}
+
+func TestToday(t *testing.T) {
+	byDate := map[string][]books.BestSellerBook{
+		"2022-06-15": {{ListEncodedName: "hardcover-fiction", DateSelected: "2022-06-15"}, {ListEncodedName: "manga", DateSelected: "2022-06-15"}},
+		"2022-06-14": {{ListEncodedName: "manga", DateSelected: "2022-06-14"}},
+	}
+	get := func(t *testing.T, now time.Time, byDate map[string][]books.BestSellerBook, query map[string]string) (*recordingQueryPaginatorProvider, events.APIGatewayV2HTTPResponse, BestSellerBooksResponse) {
+		r := &recordingQueryPaginatorProvider{byDate: byDate}
+		h := New(&dummyQueryAPIClient{}, r.newQueryPaginator, TableName)
+		h.now = func() time.Time { return now }
+		res, err := h.Route(events.APIGatewayV2HTTPRequest{RouteKey: "GET /books/today", QueryStringParameters: query})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		var body BestSellerBooksResponse
+		_ = json.Unmarshal([]byte(res.Body), &body)
+		return r, res, body
+	}
+	noon := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name         string
+		now          time.Time
+		query        map[string]string
+		wantDate     string
+		wantQueries  []string
+		cacheControl string
+	}{
+		{"utc by default", noon, nil, "2022-06-15", []string{"2022-06-15"}, "public, max-age=43200"},
+		{"today in time zone", noon, map[string]string{"time_zone": "Asia/Tokyo"}, "2022-06-15", []string{"2022-06-15"}, "public, max-age=10800"},
+		{"yesterday in time zone", noon.Add(-8 * time.Hour), map[string]string{"time_zone": "America/Los_Angeles"}, "2022-06-14", []string{"2022-06-14"}, "public, max-age=10800"},
+		{"falls back when today has no books", noon.Add(4 * time.Hour), map[string]string{"time_zone": "Asia/Tokyo"}, "2022-06-15", []string{"2022-06-16", "2022-06-15"}, "public, max-age=30600"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, res, body := get(t, tc.now, byDate, tc.query)
+			if res.StatusCode != 200 {
+				t.Fatalf("unexpected StatusCode value: got %d; expected 200 (errors %v)", res.StatusCode, body.Errors)
+			}
+			if body.Date != tc.wantDate {
+				t.Errorf("got date %s; expected %s", body.Date, tc.wantDate)
+			}
+			if diff := cmp.Diff(byDate[tc.wantDate], body.Books); diff != "" {
+				t.Errorf("wrong books (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantQueries, r.queriedValues()); diff != "" {
+				t.Errorf("wrong dates queried (-want +got):\n%s", diff)
+			}
+			if res.Headers["cache-control"] != tc.cacheControl {
+				t.Errorf("got cache-control %q; expected %q", res.Headers["cache-control"], tc.cacheControl)
+			}
+		})
+	}
+
+	t.Run("no books in fallback window", func(t *testing.T) {
+		r, res, body := get(t, noon, nil, nil)
+		if res.StatusCode != 200 || *body.Count != 0 || body.Date != "" {
+			t.Errorf("got StatusCode %d, count %d and date %q; expected 200 with no books or date", res.StatusCode, *body.Count, body.Date)
+		}
+		if len(r.queries) != maxTodayFallbackDays+1 {
+			t.Errorf("got %d queries; expected %d", len(r.queries), maxTodayFallbackDays+1)
+		}
+	})
+
+	for _, tz := range []string{"Mars/Olympus_Mons", "Local", ""} {
+		t.Run("invalid time zone "+tz, func(t *testing.T) {
+			_, res, body := get(t, noon, byDate, map[string]string{"time_zone": tz})
+			if res.StatusCode != 400 || containsError(body.Errors, "time_zone") == -1 {
+				t.Errorf("got StatusCode %d and errors %v; expected 400 for time_zone", res.StatusCode, body.Errors)
+			}
+		})
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatalf("could not load OpenAPI document: %v", err)
+	}
+
+	routes := []string{"GET /books", "GET /books/export", "GET /books/today", "GET /feed/{feed}"}
+	if got := doc.Routes("books"); !cmp.Equal(got, routes) {
+		t.Errorf("wrong routes in document: got %v; expected %v", got, routes)
+	}
+
+	schemas := map[string]interface{}{
+		"BestSellerBooksResponse": BestSellerBooksResponse{},
+		"BestSellerBook":          books.BestSellerBook{},
+		"ErrorInfo":               ErrorInfo{},
+	}
+	for name, v := range schemas {
+		if err := doc.CheckType(name, v); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, route := range routes {
+		t.Run(route, func(t *testing.T) {
+			op, ok := doc.Operation(route)
+			if !ok {
+				t.Fatalf("missing operation %s", route)
+			}
+			for _, name := range op.ResponseSchemas() {
+				if _, ok := schemas[name]; !ok {
+					t.Errorf("response schema %s isn't checked against a type", name)
+				}
+			}
+
+			errs := op.CheckParameters(openapi.Request{}, func(r openapi.Request) ([]string, error) {
+				p := &recordingQueryPaginatorProvider{}
+				res, err := New(&dummyQueryAPIClient{}, p.newQueryPaginator, TableName).Route(events.APIGatewayV2HTTPRequest{
+					RouteKey:              route,
+					QueryStringParameters: r.Query,
+					PathParameters:        r.Path,
+					Headers:               r.Headers,
+				})
+				if err != nil || res.Headers["content-type"] != "application/json" {
+					return nil, err
+				}
+				var body BestSellerBooksResponse
+				if err := json.Unmarshal([]byte(res.Body), &body); err != nil {
+					return nil, err
+				}
+				var fields []string
+				for _, e := range body.Errors {
+					fields = append(fields, e.Field)
+				}
+				return fields, nil
+			})
+			for _, err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	var pages [][]books.BestSellerBook
+	var all []books.BestSellerBook
+	for p := 0; p < 3; p++ {
+		var page []books.BestSellerBook
+		for i := 0; i < 20; i++ {
+			b := books.BestSellerBook{
+				ListEncodedName: "manga",
+				DateSelected:    fmt.Sprintf("2022-06-%02d", p*10+i%10+1),
+				Title:           fmt.Sprintf("Book %d, \"Part\" %d", p, i),
+				Description:     "Line one\nline two",
+				Rank:            i + 1,
+				ImageWidth:      128,
+			}
+			page = append(page, b)
+			all = append(all, b)
+		}
+		pages = append(pages, page)
+	}
+	export := func(t *testing.T, query map[string]string) (events.APIGatewayV2HTTPResponse, []*dynamodb.QueryInput) {
+		var queries []*dynamodb.QueryInput
+		nqp := func(client dynamodb.QueryAPIClient, params *dynamodb.QueryInput, optFns ...func(*dynamodb.QueryPaginatorOptions)) DynamoDBQueryPaginatorAPI {
+			queries = append(queries, params)
+			return &stubDynamoDBQueryPaginatorAPI{np: len(pages), limit: math.MaxInt32, pages: pages}
+		}
+		res, err := New(&dummyQueryAPIClient{}, nqp, TableName).Route(events.APIGatewayV2HTTPRequest{RouteKey: "GET /books/export", QueryStringParameters: query})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		return res, queries
+	}
+
+	t.Run("csv has every book and field", func(t *testing.T) {
+		res, queries := export(t, map[string]string{"list": "manga", "format": "csv"})
+		if res.StatusCode != 200 || res.Headers["content-type"] != "text/csv; charset=utf-8" {
+			t.Fatalf("wrong response: got %d %v", res.StatusCode, res.Headers)
+		}
+		if len(queries) != 1 || aws.ToInt32(queries[0].Limit) != defaultExportPageSize {
+			t.Errorf("got queries %v; expected one query for a page of the export", queries)
+		}
+		if link, ok := res.Headers["link"]; ok {
+			t.Errorf("got link %s; expected none on the last page", link)
+		}
+
+		rows, err := csv.NewReader(strings.NewReader(res.Body)).ReadAll()
+		if err != nil {
+			t.Fatalf("could not read csv: %v", err)
+		}
+		// Columns are the JSON fields of a book, in order
+		var header []string
+		bt := reflect.TypeOf(books.BestSellerBook{})
+		for i := 0; i < bt.NumField(); i++ {
+			if name := bt.Field(i).Tag.Get("json"); name != "-" {
+				header = append(header, strings.Split(name, ",")[0])
+			}
+		}
+		if !cmp.Equal(rows[0], header) {
+			t.Errorf("wrong header: got %v; expected %v", rows[0], header)
+		}
+		if len(rows) != len(all)+1 {
+			t.Fatalf("got %d rows; expected %d", len(rows)-1, len(all))
+		}
+		for i, b := range all {
+			row := rows[i+1]
+			if row[1] != b.DateSelected || row[7] != b.Title || row[10] != b.Description || row[11] != strconv.Itoa(b.Rank) || row[14] != "128" {
+				t.Errorf("wrong row %d: got %v; expected %+v", i, row, b)
+			}
+		}
+	})
+
+	t.Run("jsonl has a book per line", func(t *testing.T) {
+		res, _ := export(t, map[string]string{"list": "manga", "format": "jsonl", "sort": "desc"})
+		if res.StatusCode != 200 || res.Headers["content-disposition"] != `attachment; filename="books.jsonl"` {
+			t.Fatalf("wrong response: got %d %v", res.StatusCode, res.Headers)
+		}
+		lines := strings.Split(strings.TrimSuffix(res.Body, "\n"), "\n")
+		var got []books.BestSellerBook
+		for _, line := range lines {
+			var b books.BestSellerBook
+			if err := json.Unmarshal([]byte(line), &b); err != nil {
+				t.Fatalf("could not unmarshal line %q: %v", line, err)
+			}
+			got = append(got, b)
+		}
+		if diff := cmp.Diff(all, got); diff != "" {
+			t.Errorf("wrong books (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("range without list is read a date at a time", func(t *testing.T) {
+		r := &recordingQueryPaginatorProvider{byDate: map[string][]books.BestSellerBook{
+			"2022-06-01": {{ListEncodedName: "manga", DateSelected: "2022-06-01"}},
+			"2022-06-03": {{ListEncodedName: "hardcover-fiction", DateSelected: "2022-06-03"}},
+		}}
+		res, err := New(&dummyQueryAPIClient{}, r.newQueryPaginator, TableName).GetExport(events.APIGatewayV2HTTPRequest{
+			QueryStringParameters: map[string]string{"from": "2022-06-01", "to": "2022-06-03", "sort": "desc", "format": "jsonl"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		if want := []string{"2022-06-03", "2022-06-02", "2022-06-01"}; !cmp.Equal(r.queriedValues(), want) {
+			t.Errorf("got queries %v; expected %v", r.queriedValues(), want)
+		}
+		if strings.Count(res.Body, "\n") != 2 || strings.Index(res.Body, "2022-06-03") > strings.Index(res.Body, "2022-06-01") {
+			t.Errorf("wrong body: got %s", res.Body)
+		}
+	})
+
+	// nextQuery returns the query parameters of the next page that res links to, or
+	// nil if it's the last page
+	nextQuery := func(t *testing.T, res events.APIGatewayV2HTTPResponse) map[string]string {
+		link, ok := res.Headers["link"]
+		if !ok {
+			return nil
+		}
+		m := regexp.MustCompile(`^</books/export\?(.+)>; rel="next"$`).FindStringSubmatch(link)
+		if m == nil {
+			t.Fatalf("got link %q; expected a link to the next page", link)
+		}
+		values, err := url.ParseQuery(m[1])
+		if err != nil {
+			t.Fatalf("could not parse query of link %q: %v", link, err)
+		}
+		query := map[string]string{}
+		for k := range values {
+			query[k] = values.Get(k)
+		}
+		return query
+	}
+
+	t.Run("pages link to the next page", func(t *testing.T) {
+		r := &recordingQueryPaginatorProvider{byDate: map[string][]books.BestSellerBook{
+			"2022-06-01": {{ListEncodedName: "hardcover-fiction", DateSelected: "2022-06-01"}, {ListEncodedName: "manga", DateSelected: "2022-06-01"}},
+			"2022-06-03": {{ListEncodedName: "manga", DateSelected: "2022-06-03"}},
+		}}
+		h := New(&dummyQueryAPIClient{}, r.newQueryPaginator, TableName)
+		h.exportPageSize = 2
+
+		query := map[string]string{"from": "2022-06-01", "to": "2022-06-03", "format": "jsonl"}
+		var got []string
+		for pages := 0; query != nil; pages++ {
+			if pages == 3 {
+				t.Fatalf("got more than 2 pages; expected 2")
+			}
+			res, err := h.GetExport(events.APIGatewayV2HTTPRequest{QueryStringParameters: query})
+			if err != nil || res.StatusCode != 200 {
+				t.Fatalf("got %d, %v; expected 200", res.StatusCode, err)
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(res.Body, "\n"), "\n") {
+				var b books.BestSellerBook
+				if err := json.Unmarshal([]byte(line), &b); err != nil {
+					t.Fatalf("could not unmarshal line %q: %v", line, err)
+				}
+				got = append(got, b.DateSelected+"/"+b.ListEncodedName)
+			}
+			query = nextQuery(t, res)
+			if query != nil && (query["from"] != "2022-06-01" || query["format"] != "jsonl") {
+				t.Errorf("got next page query %v; expected the same filters", query)
+			}
+		}
+		want := []string{"2022-06-01/hardcover-fiction", "2022-06-01/manga", "2022-06-03/manga"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("wrong books (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("large books end the page early", func(t *testing.T) {
+		var large []books.BestSellerBook
+		for i := 0; i < 6; i++ {
+			large = append(large, books.BestSellerBook{
+				ListEncodedName: "manga", DateSelected: fmt.Sprintf("2022-06-%02d", i+1), Description: strings.Repeat("a", 1<<20),
+			})
+		}
+		nqp := func(client dynamodb.QueryAPIClient, params *dynamodb.QueryInput, optFns ...func(*dynamodb.QueryPaginatorOptions)) DynamoDBQueryPaginatorAPI {
+			return &stubDynamoDBQueryPaginatorAPI{np: 1, limit: len(large), pages: [][]books.BestSellerBook{large}}
+		}
+		res, err := New(&dummyQueryAPIClient{}, nqp, TableName).GetExport(events.APIGatewayV2HTTPRequest{
+			QueryStringParameters: map[string]string{"list": "manga", "format": "csv"},
+		})
+		if err != nil || res.StatusCode != 200 {
+			t.Fatalf("got %d, %v; expected 200", res.StatusCode, err)
+		}
+		if len(res.Body) > 5<<20 {
+			t.Errorf("got %d byte page; expected it to end after %d bytes", len(res.Body), maxExportPageBytes)
+		}
+		rows, _ := csv.NewReader(strings.NewReader(res.Body)).ReadAll()
+		query := nextQuery(t, res)
+		if query == nil {
+			t.Fatalf("got no link to the next page")
+		}
+		cursor, err := decodeCursor(query["cursor"])
+		if err != nil || cursor.DateSelected != rows[len(rows)-1][1] {
+			t.Errorf("got cursor %+v, %v; expected the key of the last book, on %s", cursor, err, rows[len(rows)-1][1])
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		query map[string]string
+		field string
+	}{
+		{"missing format", map[string]string{"list": "manga"}, "format"},
+		{"unknown format", map[string]string{"list": "manga", "format": "xlsx"}, "format"},
+		{"limit", map[string]string{"list": "manga", "format": "csv", "limit": "10"}, "limit"},
+		{"invalid cursor", map[string]string{"list": "manga", "format": "csv", "cursor": "abc"}, "cursor"},
+		{"invalid filter", map[string]string{"list": "manga", "format": "csv", "date": "2022-02-30"}, "date"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, _ := export(t, tc.query)
+			var body BestSellerBooksResponse
+			_ = json.Unmarshal([]byte(res.Body), &body)
+			if res.StatusCode != 400 || containsError(body.Errors, tc.field) == -1 {
+				t.Errorf("got %d %s; expected 400 with an error for %s", res.StatusCode, res.Body, tc.field)
+			}
+		})
+	}
+}
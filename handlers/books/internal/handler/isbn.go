@@ -0,0 +1,55 @@
+package handler
+
+import "strings"
+
+// toISBN13 returns the ISBN-13 for an ISBN-10 or ISBN-13, which may contain hyphens or
+// spaces. The second return value is false if isbn is not a valid ISBN.
+func toISBN13(isbn string) (string, bool) {
+	isbn = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, c := range isbn {
+			var d int
+			switch {
+			case c >= '0' && c <= '9':
+				d = int(c - '0')
+			case c == 'X' && i == 9:
+				d = 10
+			default:
+				return "", false
+			}
+			sum += (10 - i) * d
+		}
+		if sum%11 != 0 {
+			return "", false
+		}
+		isbn13 := "978" + isbn[:9]
+		return isbn13 + string(rune('0'+isbn13CheckDigit(isbn13))), true
+	case 13:
+		for _, c := range isbn {
+			if c < '0' || c > '9' {
+				return "", false
+			}
+		}
+		if isbn13CheckDigit(isbn[:12]) != int(isbn[12]-'0') {
+			return "", false
+		}
+		return isbn, true
+	}
+	return "", false
+}
+
+// isbn13CheckDigit returns the check digit for the first 12 digits of an ISBN-13.
+func isbn13CheckDigit(digits string) int {
+	sum := 0
+	for i, c := range digits[:12] {
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"bookoftheday/httpcache"
+	"bookoftheday/types"
+	"context"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxTodayFallbackDays is how many days before today GetBooksToday looks for books
+// when today's haven't been selected yet.
+const maxTodayFallbackDays = 7
+
+// GetBooksToday returns the books of the day for today in the time zone of the time_zone
+// parameter, or UTC by default. If today's books haven't been selected yet, it returns
+// the books of the most recent day that has them. The response's date is the date that
+// was served.
+func (h *Handler) GetBooksToday(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	loc := time.UTC
+	if qTZ, ok := req.QueryStringParameters["time_zone"]; ok {
+		// "Local" and "" are accepted by LoadLocation, but aren't meaningful to callers
+		if l, err := time.LoadLocation(qTZ); err == nil && qTZ != "Local" && qTZ != "" {
+			loc = l
+		} else {
+			return response(http.StatusBadRequest, BestSellerBooksResponse{Errors: []ErrorInfo{
+				{"time_zone", "time_zone must be an IANA time zone name such as America/New_York", "query"},
+			}})
+		}
+	}
+
+	today := h.now().In(loc)
+	var books []types.BestSellerBook
+	var date string
+	for i := 0; i <= maxTodayFallbackDays && len(books) == 0; i++ {
+		date = today.AddDate(0, 0, -i).Format(dateLayout)
+		var err error
+		books, err = h.booksOn(context.TODO(), date)
+		if err != nil {
+			return events.APIGatewayV2HTTPResponse{}, err
+		}
+	}
+	if len(books) == 0 {
+		date = ""
+	}
+
+	count := len(books)
+	res, err := response(200, BestSellerBooksResponse{
+		Count:       &count,
+		Attribution: Attribution,
+		Books:       books,
+		Date:        date,
+	})
+	// The response also changes when the day changes in the caller's time zone
+	return h.cached(h.schedule.And(httpcache.DailyIn(loc, 0, 0, 0)), req, res, err)
+}
+
+// booksOn returns every book selected on date.
+func (h *Handler) booksOn(ctx context.Context, date string) ([]types.BestSellerBook, error) {
+	books, _, err := h.queryBooks(ctx, requestParams{date: &date}, math.MaxInt32)
+	return books, err
+}
@@ -6,6 +6,10 @@ import (
 	"log"
 	"os"
 
+	// Embed the time zone database so that callers' time zones can be loaded
+	// regardless of what the Lambda runtime provides
+	_ "time/tzdata"
+
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -25,5 +29,5 @@ func main() {
 	}
 	h := handler.New(ddbClient, p, os.Getenv("BOOKS_TABLE_NAME"))
 
-	lambda.Start(h.GetBooksOnDateInList)
+	lambda.Start(h.Route)
 }
@@ -2,8 +2,11 @@ require (
 	github.com/aws/aws-lambda-go v1.23.0
 	github.com/aws/aws-sdk-go-v2 v1.16.5
 	github.com/aws/aws-sdk-go-v2/config v1.15.11
+	github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue v1.9.4
+	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.15.7
 	github.com/aws/aws-sdk-go-v2/service/sesv2 v1.13.7
 	github.com/aws/aws-sdk-go-v2/service/sqs v1.18.6
+	github.com/google/go-cmp v0.5.8
 )
 
 replace gopkg.in/yaml.v2 => gopkg.in/yaml.v2 v2.2.8
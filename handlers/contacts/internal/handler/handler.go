@@ -9,9 +9,14 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2"
 	sestypes "github.com/aws/aws-sdk-go-v2/service/sesv2/types"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
@@ -36,42 +41,163 @@ type SQSSendMessageAPI interface {
 		optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
 }
 
+// DynamoDBScanPaginatorAPI is a convenience wrapper over DynamoDB scan operations and is unit-testable.
+type DynamoDBScanPaginatorAPI interface {
+	HasMorePages() bool
+	NextPage(ctx context.Context, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+// DynamoDBNewScanPaginatorAPI is a type that allows creating instances of DynamoDBScanPaginatorAPI.
+type DynamoDBNewScanPaginatorAPI func(
+	client dynamodb.ScanAPIClient, params *dynamodb.ScanInput, optFns ...func(*dynamodb.ScanPaginatorOptions),
+) DynamoDBScanPaginatorAPI
+
+// DynamoDBQueryPaginatorAPI is a convenience wrapper over DynamoDB query operations and is unit-testable.
+type DynamoDBQueryPaginatorAPI interface {
+	HasMorePages() bool
+	NextPage(ctx context.Context, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// DynamoDBNewQueryPaginatorAPI is a type that allows creating instances of DynamoDBQueryPaginatorAPI.
+type DynamoDBNewQueryPaginatorAPI func(
+	client dynamodb.QueryAPIClient, params *dynamodb.QueryInput, optFns ...func(*dynamodb.QueryPaginatorOptions),
+) DynamoDBQueryPaginatorAPI
+
+// DynamoDBPutItemAPI provides a unit-testable interface to access the DynamoDB PutItem API.
+type DynamoDBPutItemAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoDBUpdateItemAPI provides a unit-testable interface to access the DynamoDB UpdateItem API.
+type DynamoDBUpdateItemAPI interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
 // Handler provides the Lambda implementation list contacts and send them to an SQS queue.
 type Handler struct {
-	lcAPI           sesv2.ListContactsAPIClient
-	newLCPaginator  SESv2NewListContactsPaginatorAPI
-	contactListName string
-	smAPI           SQSSendMessageAPI
-	queueURL        string
-	rng             *rand.Rand
-}
-
-// New creates a new Handler instance.
-func New(
-	lcAPI sesv2.ListContactsAPIClient,
-	newLCPaginator SESv2NewListContactsPaginatorAPI,
-	contactListName string, smAPI SQSSendMessageAPI,
-	queueURL string,
-	rng *rand.Rand,
-) *Handler {
-	return &Handler{
-		lcAPI:           lcAPI,
-		newLCPaginator:  newLCPaginator,
-		contactListName: contactListName,
-		smAPI:           smAPI,
-		queueURL:        queueURL,
-		rng:             rng,
+	lcAPI                sesv2.ListContactsAPIClient
+	newLCPaginator       SESv2NewListContactsPaginatorAPI
+	contactListName      string
+	smAPI                SQSSendMessageAPI
+	queueURL             string
+	scanAPI              dynamodb.ScanAPIClient
+	newScanPaginator     DynamoDBNewScanPaginatorAPI
+	subscribersTableName string
+	queryAPI             dynamodb.QueryAPIClient
+	newQueryPaginator    DynamoDBNewQueryPaginatorAPI
+	booksTableName       string
+	putItemAPI           DynamoDBPutItemAPI
+	historyTableName     string
+	historyLookback      time.Duration
+	updateItemAPI        DynamoDBUpdateItemAPI
+	runsTableName        string
+	repeatFallback       string
+	defaultList          string
+	rng                  *rand.Rand
+	now                  func() time.Time
+}
+
+// Config provides configuration options for a Handler.
+type Config struct {
+	ListContactsAPI          sesv2.ListContactsAPIClient
+	NewListContactsPaginator SESv2NewListContactsPaginatorAPI
+	ContactListName          string
+	SendMessageAPI           SQSSendMessageAPI
+	QueueURL                 string
+	ScanAPI                  dynamodb.ScanAPIClient
+	NewScanPaginator         DynamoDBNewScanPaginatorAPI
+
+	// SubscribersTableName is the table that subscriber preferences are read from.
+	SubscribersTableName string
+
+	QueryAPI          dynamodb.QueryAPIClient
+	NewQueryPaginator DynamoDBNewQueryPaginatorAPI
+
+	// BooksTableName is the table that previous days' books are read from for weekly
+	// digests, and that the day's books are read from on hourly runs.
+	BooksTableName string
+
+	PutItemAPI DynamoDBPutItemAPI
+
+	// HistoryTableName is the table that the books sent to each contact are recorded in,
+	// so that a contact isn't sent the same book twice.
+	HistoryTableName string
+
+	// HistoryLookback is how far back the delivery history is checked for repeats.
+	// Zero means the whole history.
+	HistoryLookback time.Duration
+
+	UpdateItemAPI DynamoDBUpdateItemAPI
+
+	// RunsTableName is the table that the state machine marks each day's books as
+	// selected in, and that each run claims the delivery hours it sends in.
+	RunsTableName string
+
+	// RepeatFallback is FallbackRepeat, FallbackAnyList or FallbackSkip, and decides
+	// what a contact gets when every book they could get was already sent to them.
+	// It defaults to FallbackRepeat.
+	RepeatFallback string
+
+	// DefaultList is the encoded name of the list to pick a book from when none of
+	// a subscriber's lists have a book.
+	DefaultList string
+
+	Rand *rand.Rand
+
+	// Now returns the current time, which decides which subscribers are due and whether
+	// their pause has ended. It defaults to time.Now.
+	Now func() time.Time
+}
+
+// New creates a new Handler instance. It returns an error if cfg.RepeatFallback isn't
+// one of the fallback strategies.
+func New(cfg Config) (*Handler, error) {
+	h := &Handler{
+		lcAPI:                cfg.ListContactsAPI,
+		newLCPaginator:       cfg.NewListContactsPaginator,
+		contactListName:      cfg.ContactListName,
+		smAPI:                cfg.SendMessageAPI,
+		queueURL:             cfg.QueueURL,
+		scanAPI:              cfg.ScanAPI,
+		newScanPaginator:     cfg.NewScanPaginator,
+		subscribersTableName: cfg.SubscribersTableName,
+		queryAPI:             cfg.QueryAPI,
+		newQueryPaginator:    cfg.NewQueryPaginator,
+		booksTableName:       cfg.BooksTableName,
+		putItemAPI:           cfg.PutItemAPI,
+		historyTableName:     cfg.HistoryTableName,
+		historyLookback:      cfg.HistoryLookback,
+		updateItemAPI:        cfg.UpdateItemAPI,
+		runsTableName:        cfg.RunsTableName,
+		repeatFallback:       cfg.RepeatFallback,
+		defaultList:          cfg.DefaultList,
+		rng:                  cfg.Rand,
+		now:                  cfg.Now,
+	}
+	if h.now == nil {
+		h.now = time.Now
+	}
+	switch h.repeatFallback {
+	case "":
+		h.repeatFallback = FallbackRepeat
+	case FallbackRepeat, FallbackAnyList, FallbackSkip:
+	default:
+		return nil, fmt.Errorf("invalid repeat fallback %q: expected %s, %s or %s", h.repeatFallback, FallbackRepeat, FallbackAnyList, FallbackSkip)
 	}
+	return h, nil
 }
 
 type result struct {
 	contactEmail string
 	messageID    *string
 	err          error
+
+	// historyErr is set when the contact was sent but their delivery history
+	// couldn't be updated.
+	historyErr error
 }
 
-func sendContact(ctx context.Context, contact sestypes.Contact, book books.BestSellerBook, api SQSSendMessageAPI, queueURL string) (*sqs.SendMessageOutput, error) {
-	mb := books.SQSBookMessageBody{ContactEmail: *contact.EmailAddress, Book: book}
+func sendContact(ctx context.Context, contact sestypes.Contact, mb books.SQSBookMessageBody, api SQSSendMessageAPI, queueURL string) (*sqs.SendMessageOutput, error) {
 	b, err := json.Marshal(mb)
 	if err != nil {
 		return nil, fmt.Errorf("could not marshal message body: %w", err)
@@ -93,10 +219,265 @@ func sendContact(ctx context.Context, contact sestypes.Contact, book books.BestS
 	})
 }
 
-// EnqueueContacts gets the list of contacts and sends them to an SQS queue.
+// getSubscribers reads the preferences of every subscriber, keyed by email address.
+func (h *Handler) getSubscribers(ctx context.Context) (map[string]books.Subscriber, error) {
+	p := h.newScanPaginator(h.scanAPI, &dynamodb.ScanInput{
+		TableName: &h.subscribersTableName,
+	})
+
+	subscribers := map[string]books.Subscriber{}
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not get subscribers: %w", err)
+		}
+
+		var data []books.Subscriber
+		err = attributevalue.UnmarshalListOfMaps(out.Items, &data)
+		if err != nil {
+			return nil, fmt.Errorf("could not unmarshal subscribers: %w", err)
+		}
+		for _, s := range data {
+			subscribers[s.EmailAddress] = s
+		}
+	}
+
+	return subscribers, nil
+}
+
+// isDeliveryHour reports whether the run at local, in the subscriber's time zone, is
+// the first run of the day at or after the subscriber's delivery hour. A delivery hour
+// skipped by a daylight saving time change is sent at the next hour instead, and one
+// that is repeated is only sent the first time.
+func isDeliveryHour(local time.Time, hour int) bool {
+	if local.Hour() < hour {
+		return false
+	}
+	prev := local.Add(-time.Hour)
+	return prev.Day() != local.Day() || prev.Hour() < hour
+}
+
+// isPaused reports whether the subscriber shouldn't get a book on date (YYYY-MM-DD).
+// Subscribers that paused until a date resume once that date is reached, even if they
+// were also paused indefinitely before setting it.
+func isPaused(sub books.Subscriber, date string) bool {
+	if sub.PausedUntil != "" {
+		return date < sub.PausedUntil
+	}
+	return sub.Paused
+}
+
+// ymdLayout is the format of the DateSelected attribute of books.
+const ymdLayout = "2006-01-02"
+
+// dayOfBooks contains the books selected on one day, also grouped by list.
+type dayOfBooks struct {
+	books  []books.BestSellerBook
+	byList map[string][]books.BestSellerBook
+}
+
+func newDayOfBooks(bookList []books.BestSellerBook) dayOfBooks {
+	byList := map[string][]books.BestSellerBook{}
+	for _, b := range bookList {
+		byList[b.ListEncodedName] = append(byList[b.ListEncodedName], b)
+	}
+	return dayOfBooks{bookList, byList}
+}
+
+// pickBook chooses a random book that isn't in seen from the subscriber's preferred
+// lists. A subscriber without preferences can get a book from any list, and a subscriber
+// whose lists have no new books gets a book from the default list. When every book
+// was seen, the repeat fallback decides the book. The second return value is false if
+// there was no book to choose from.
+func (h *Handler) pickBook(day dayOfBooks, sub books.Subscriber, seen map[string]bool) (books.BestSellerBook, bool) {
+	var preferred [][]books.BestSellerBook
+	if len(sub.Lists) == 0 {
+		preferred = [][]books.BestSellerBook{day.books}
+	} else {
+		var fromLists []books.BestSellerBook
+		for _, list := range sub.Lists {
+			fromLists = append(fromLists, day.byList[list]...)
+		}
+		preferred = [][]books.BestSellerBook{fromLists, day.byList[h.defaultList]}
+	}
+
+	for _, candidates := range preferred {
+		if u := unseen(candidates, seen); len(u) != 0 {
+			return u[h.rng.Intn(len(u))], true
+		}
+	}
+
+	switch h.repeatFallback {
+	case FallbackRepeat:
+		for _, candidates := range preferred {
+			if len(candidates) != 0 {
+				return candidates[h.rng.Intn(len(candidates))], true
+			}
+		}
+	case FallbackAnyList:
+		if u := unseen(day.books, seen); len(u) != 0 {
+			return u[h.rng.Intn(len(u))], true
+		}
+	}
+	return books.BestSellerBook{}, false
+}
+
+// fromDefaultList reports whether b was picked from the default list instead of one of
+// the subscriber's lists.
+func (h *Handler) fromDefaultList(sub books.Subscriber, b books.BestSellerBook) bool {
+	if len(sub.Lists) == 0 || b.ListEncodedName != h.defaultList {
+		return false
+	}
+	for _, list := range sub.Lists {
+		if list == b.ListEncodedName {
+			return false
+		}
+	}
+	return true
+}
+
+// pickDigest chooses one book from each day of the week for a weekly subscriber,
+// in the same way as pickBook. A book is only included once, and days without a book
+// for the subscriber are skipped.
+func (h *Handler) pickDigest(week []dayOfBooks, sub books.Subscriber, seen map[string]bool) []books.BestSellerBook {
+	var digest []books.BestSellerBook
+	for _, day := range week {
+		if book, ok := h.pickBook(day, sub, seen); ok {
+			digest = append(digest, book)
+			if book.PrimaryISBN13 != "" {
+				seen[book.PrimaryISBN13] = true
+			}
+		}
+	}
+	return digest
+}
+
+// getBooksOn reads the books selected on date (YYYY-MM-DD) from the books table.
+func (h *Handler) getBooksOn(ctx context.Context, date string) ([]books.BestSellerBook, error) {
+	p := h.newQueryPaginator(h.queryAPI, &dynamodb.QueryInput{
+		TableName:              &h.booksTableName,
+		IndexName:              aws.String("DateSelectedIndex"),
+		KeyConditionExpression: aws.String("DateSelected = :date"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":date": &ddbtypes.AttributeValueMemberS{Value: date},
+		},
+	})
+
+	var bookList []books.BestSellerBook
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not query books on %s: %w", date, err)
+		}
+
+		var data []books.BestSellerBook
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &data); err != nil {
+			return nil, fmt.Errorf("could not unmarshal books: %w", err)
+		}
+		bookList = append(bookList, data...)
+	}
+	return bookList, nil
+}
+
+// getDayOfBooks returns the books selected on date from days, reading them from the
+// books table the first time a run needs them.
+func (h *Handler) getDayOfBooks(ctx context.Context, days map[string]dayOfBooks, date time.Time) (dayOfBooks, error) {
+	key := date.Format(ymdLayout)
+	if day, ok := days[key]; ok {
+		return day, nil
+	}
+	bookList, err := h.getBooksOn(ctx, key)
+	if err != nil {
+		return dayOfBooks{}, err
+	}
+	days[key] = newDayOfBooks(bookList)
+	return days[key], nil
+}
+
+// getWeekOfBooks returns the books selected during the week ending on end, oldest first.
+func (h *Handler) getWeekOfBooks(ctx context.Context, days map[string]dayOfBooks, end time.Time) ([]dayOfBooks, error) {
+	week := make([]dayOfBooks, 7)
+	for i := range week {
+		day, err := h.getDayOfBooks(ctx, days, end.AddDate(0, 0, i-6))
+		if err != nil {
+			return nil, err
+		}
+		week[i] = day
+	}
+	return week, nil
+}
+
+// locations caches the time zones of subscribers during a run.
+type locations map[string]*time.Location
+
+// localTime returns t in the subscriber's time zone. Subscribers with an unknown
+// time zone are treated as being in UTC.
+func (l locations) localTime(sub books.Subscriber, t time.Time) time.Time {
+	loc, ok := l[sub.TimeZone]
+	if !ok {
+		var err error
+		loc, err = time.LoadLocation(sub.TimeZone)
+		if err != nil {
+			log.Printf("unknown time zone %q for contact %s, using UTC: %v", sub.TimeZone, sub.EmailAddress, err)
+			loc = time.UTC
+		}
+		l[sub.TimeZone] = loc
+	}
+	return t.In(loc)
+}
+
+// EnqueueContacts gets the list of contacts, pairs each contact with a book from
+// the lists they subscribed to that they haven't received before, and sends them
+// to an SQS queue. The books are recorded in each contact's delivery history. Contacts that
+// paused delivery are skipped until they resume. Weekly contacts are only sent
+// on their delivery day, with one book for each day of the past week.
+//
+// Each run only sends the contacts whose delivery hour in their time zone is one of the
+// hours it claims, which is usually just the hour of the run. The daily state machine
+// passes in the day's new books and runs for midnight UTC. The hourly runs for the rest
+// of the day have no input, and read the day's books from the books table instead. No
+// hours are claimed until the state machine has selected the day's books, so the runs
+// before then send nothing and leave their contacts for the next run.
+//
+// Contacts are treated as being on their local date throughout: it decides whether they
+// are paused or it's their delivery day, which day's books they get, and the start of
+// their history look-back. Contacts whose local date is ahead of UTC get the day's books.
 func (h *Handler) EnqueueContacts(bookList []books.BestSellerBook) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := h.now().UTC()
+	today := startOfDay(now)
+	runTime := today
 	if len(bookList) == 0 {
-		return errors.New("cannot process input - books list was empty")
+		runTime = now.Truncate(time.Hour)
+	}
+
+	hours, err := h.claimHours(ctx, runTime)
+	if err != nil {
+		return err
+	}
+	if len(hours) == 0 {
+		log.Printf("sent no contacts for %s: the books of %s aren't selected yet, or another run already sent them",
+			runTime.Format(time.RFC3339), today.Format(ymdLayout))
+		return nil
+	}
+
+	if len(bookList) == 0 {
+		bookList, err = h.getBooksOn(ctx, today.Format(ymdLayout))
+		if err != nil {
+			return err
+		}
+		if len(bookList) == 0 {
+			return fmt.Errorf("cannot process input - no books were selected on %s", today.Format(ymdLayout))
+		}
+	}
+	days := map[string]dayOfBooks{today.Format(ymdLayout): newDayOfBooks(bookList)}
+	locs := locations{}
+
+	subscribers, err := h.getSubscribers(ctx)
+	if err != nil {
+		return err
 	}
 
 	p := h.newLCPaginator(h.lcAPI, &sesv2.ListContactsInput{
@@ -105,31 +486,105 @@ func (h *Handler) EnqueueContacts(bookList []books.BestSellerBook) error {
 
 	results := make(chan result)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	var wg sync.WaitGroup
 
+	var due []dueContact
+	paused := 0
+	notDue := 0
+	otherHour := 0
 	for p.HasMorePages() {
 		out, err := p.NextPage(ctx)
 		if err != nil {
 			return fmt.Errorf("error getting page of contacts: %w", err)
 		}
 
-		wg.Add(len(out.Contacts))
 		for _, c := range out.Contacts {
-			n := h.rng.Intn(len(bookList))
-			book := bookList[n]
-			go func(contact sestypes.Contact) {
-				defer wg.Done()
-				out, err := sendContact(ctx, contact, book, h.smAPI, h.queueURL)
-				result := result{err: err, contactEmail: *contact.EmailAddress}
-				if out != nil {
-					result.messageID = out.MessageId
+			sub := subscribers[*c.EmailAddress]
+			local, ok := locs.dueTime(sub, hours)
+			if !ok {
+				otherHour++
+				continue
+			}
+			if isPaused(sub, local.Format(ymdLayout)) {
+				paused++
+				continue
+			}
+
+			weekly := sub.Frequency == books.FrequencyWeekly
+			if weekly && sub.DeliveryDay != strings.ToLower(local.Weekday().String()) {
+				notDue++
+				continue
+			}
+			date := startOfDay(local)
+			booksDate := date
+			if booksDate.After(today) {
+				booksDate = today
+			}
+			due = append(due, dueContact{contact: c, sub: sub, date: date, booksDate: booksDate})
+		}
+	}
+
+	if err := h.getSeenBooksOfDue(ctx, due); err != nil {
+		return err
+	}
+
+	unmatched := 0
+	fallback := 0
+	for _, dc := range due {
+		c, sub := dc.contact, dc.sub
+		mb := books.SQSBookMessageBody{ContactEmail: *c.EmailAddress}
+		if sub.Frequency == books.FrequencyWeekly {
+			week, err := h.getWeekOfBooks(ctx, days, dc.booksDate)
+			if err != nil {
+				return err
+			}
+			mb.Digest = h.pickDigest(week, sub, dc.seen)
+			if len(mb.Digest) == 0 {
+				unmatched++
+				log.Printf("no books for weekly contact %s: lists %v and default list %s had no new books this week", *c.EmailAddress, sub.Lists, h.defaultList)
+				continue
+			}
+			for _, b := range mb.Digest {
+				if h.fromDefaultList(sub, b) {
+					fallback++
+					break
 				}
-				results <- result
-			}(c)
+			}
+		} else {
+			day, err := h.getDayOfBooks(ctx, days, dc.booksDate)
+			if err != nil {
+				return err
+			}
+			book, ok := h.pickBook(day, sub, dc.seen)
+			if !ok {
+				unmatched++
+				log.Printf("no book for contact %s: lists %v and default list %s had no new books", *c.EmailAddress, sub.Lists, h.defaultList)
+				continue
+			}
+			mb.Book = book
+			if h.fromDefaultList(sub, book) {
+				fallback++
+			}
 		}
+
+		wg.Add(1)
+		date := dc.date.Format(ymdLayout)
+		go func(contact sestypes.Contact) {
+			defer wg.Done()
+			out, err := sendContact(ctx, contact, mb, h.smAPI, h.queueURL)
+			result := result{err: err, contactEmail: *contact.EmailAddress}
+			if out != nil {
+				result.messageID = out.MessageId
+			}
+			if err == nil {
+				sent := mb.Digest
+				if len(sent) == 0 {
+					sent = []books.BestSellerBook{mb.Book}
+				}
+				result.historyErr = h.recordDelivery(ctx, *contact.EmailAddress, date, sent)
+			}
+			results <- result
+		}(c)
 	}
 
 	go func() {
@@ -138,13 +593,25 @@ func (h *Handler) EnqueueContacts(bookList []books.BestSellerBook) error {
 	}()
 
 	errs := 0
+	sent := 0
+	unrecorded := 0
 	for r := range results {
 		if r.err != nil {
 			errs++
 			log.Printf("error sending contact %s to SQS:\n %v", r.contactEmail, r)
+		} else {
+			sent++
+		}
+		// The message was already sent, so don't fail the run and send it again
+		if r.historyErr != nil {
+			unrecorded++
+			log.Printf("error recording delivery history of contact %s: %v", r.contactEmail, r.historyErr)
 		}
 	}
 
+	log.Printf("sent %d contacts to SQS for %s, %d contacts are due at another hour, %d contacts are paused, %d weekly contacts are not due, %d contacts had no matching book, %d contacts got a book from the default list, %d deliveries were not recorded, %d errors",
+		sent, runTime.Format(time.RFC3339), otherHour, paused, notDue, unmatched, fallback, unrecorded, errs)
+
 	if errs != 0 {
 		return errors.New("there were errors sending SQS messages, check log output")
 	}
@@ -0,0 +1,742 @@
+package handler
+
+import (
+	books "bookoftheday/types"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	sestypes "github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/google/go-cmp/cmp"
+)
+
+type dummyListContactsAPIClient struct{}
+
+func (d *dummyListContactsAPIClient) ListContacts(context.Context, *sesv2.ListContactsInput, ...func(*sesv2.Options)) (*sesv2.ListContactsOutput, error) {
+	return nil, nil
+}
+
+type stubSESv2ListContactsPaginatorAPI struct {
+	pages [][]string
+	p     int
+}
+
+func (s *stubSESv2ListContactsPaginatorAPI) HasMorePages() bool {
+	return s.p != len(s.pages)
+}
+
+func (s *stubSESv2ListContactsPaginatorAPI) NextPage(ctx context.Context, optFns ...func(*sesv2.Options)) (*sesv2.ListContactsOutput, error) {
+	page := s.pages[s.p]
+	s.p++
+
+	contacts := make([]sestypes.Contact, len(page))
+	for i, email := range page {
+		contacts[i] = sestypes.Contact{EmailAddress: aws.String(email), LastUpdatedTimestamp: aws.Time(time.Now())}
+	}
+	return &sesv2.ListContactsOutput{Contacts: contacts}, nil
+}
+
+type dummyScanAPIClient struct{}
+
+func (d *dummyScanAPIClient) Scan(context.Context, *dynamodb.ScanInput, ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return nil, nil
+}
+
+type stubDynamoDBScanPaginatorAPI struct {
+	subscribers []books.Subscriber
+	done        bool
+}
+
+func (s *stubDynamoDBScanPaginatorAPI) HasMorePages() bool {
+	return !s.done
+}
+
+func (s *stubDynamoDBScanPaginatorAPI) NextPage(ctx context.Context, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	s.done = true
+	items := []map[string]types.AttributeValue{}
+	for _, sub := range s.subscribers {
+		item, _ := attributevalue.MarshalMap(sub)
+		items = append(items, item)
+	}
+	return &dynamodb.ScanOutput{Count: int32(len(items)), Items: items}, nil
+}
+
+type dummyQueryAPIClient struct{}
+
+func (d *dummyQueryAPIClient) Query(context.Context, *dynamodb.QueryInput, ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return nil, nil
+}
+
+type stubDynamoDBQueryPaginatorAPI struct {
+	items []map[string]types.AttributeValue
+	done  bool
+}
+
+func (s *stubDynamoDBQueryPaginatorAPI) HasMorePages() bool {
+	return !s.done
+}
+
+func (s *stubDynamoDBQueryPaginatorAPI) NextPage(ctx context.Context, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	s.done = true
+	return &dynamodb.QueryOutput{Count: int32(len(s.items)), Items: s.items}, nil
+}
+
+// fakeDeliveryHistory is an in-memory delivery history table.
+type fakeDeliveryHistory struct {
+	mu      sync.Mutex
+	records []books.DeliveryRecord
+}
+
+func (f *fakeDeliveryHistory) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	var r books.DeliveryRecord
+	if err := attributevalue.UnmarshalMap(params.Item, &r); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records = append(f.records, r)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// query returns the records matching the key condition and look-back filter used by the handler.
+func (f *fakeDeliveryHistory) query(params *dynamodb.QueryInput) []map[string]types.AttributeValue {
+	email := params.ExpressionAttributeValues[":email"].(*types.AttributeValueMemberS).Value
+	since := ""
+	if v, ok := params.ExpressionAttributeValues[":since"]; ok {
+		since = v.(*types.AttributeValueMemberS).Value
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	items := []map[string]types.AttributeValue{}
+	for _, r := range f.records {
+		if r.ContactEmail == email && r.DateSent >= since {
+			item, _ := attributevalue.MarshalMap(r)
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// sent returns the ISBN13s recorded for email.
+func (f *fakeDeliveryHistory) sent(email string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var isbns []string
+	for _, r := range f.records {
+		if r.ContactEmail == email {
+			isbns = append(isbns, r.PrimaryISBN13)
+		}
+	}
+	return isbns
+}
+
+// fakeDeliveryRuns is an in-memory runs table with the item of one day.
+type fakeDeliveryRuns struct {
+	mu          sync.Mutex
+	selected    bool
+	sentThrough string
+}
+
+func (f *fakeDeliveryRuns) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	hour := params.ExpressionAttributeValues[":hour"].(*types.AttributeValueMemberS).Value
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.selected || (f.sentThrough != "" && f.sentThrough >= hour) {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	out := &dynamodb.UpdateItemOutput{}
+	if f.sentThrough != "" {
+		out.Attributes = map[string]types.AttributeValue{"SentThrough": &types.AttributeValueMemberS{Value: f.sentThrough}}
+	}
+	f.sentThrough = hour
+	return out, nil
+}
+
+type fakeSQSSendMessageAPI struct {
+	mu      sync.Mutex
+	sent    map[string]books.BestSellerBook
+	digests map[string][]books.BestSellerBook
+}
+
+func (f *fakeSQSSendMessageAPI) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	var body books.SQSBookMessageBody
+	_ = json.Unmarshal([]byte(*params.MessageBody), &body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(body.Digest) != 0 {
+		f.digests[body.ContactEmail] = body.Digest
+	} else {
+		f.sent[body.ContactEmail] = body.Book
+	}
+	return &sqs.SendMessageOutput{MessageId: aws.String(body.ContactEmail)}, nil
+}
+
+func newFakeSQSSendMessageAPI() *fakeSQSSendMessageAPI {
+	return &fakeSQSSendMessageAPI{sent: map[string]books.BestSellerBook{}, digests: map[string][]books.BestSellerBook{}}
+}
+
+const SubscribersTableName = "Subscribers"
+const BooksTableName = "Books"
+const HistoryTableName = "DeliveryHistory"
+const RunsTableName = "DeliveryRuns"
+const DefaultList = "hardcover-fiction"
+
+// newHandler creates a Handler whose books table has the books in pastBooks, keyed by DateSelected.
+func newHandler(t *testing.T, contacts [][]string, subscribers []books.Subscriber, pastBooks map[string][]books.BestSellerBook, sqsAPI SQSSendMessageAPI) *Handler {
+	return newHandlerWithHistory(t, contacts, subscribers, pastBooks, &fakeDeliveryHistory{}, Config{}, sqsAPI)
+}
+
+// newHandlerWithHistory creates a Handler like newHandler, with the given delivery history
+// and the history options, clock and runs table from cfg. The runs table defaults to one
+// where the day's books are selected and no hours were sent.
+func newHandlerWithHistory(t *testing.T, contacts [][]string, subscribers []books.Subscriber, pastBooks map[string][]books.BestSellerBook,
+	history *fakeDeliveryHistory, cfg Config, sqsAPI SQSSendMessageAPI) *Handler {
+	now := cfg.Now
+	if now == nil {
+		now = func() time.Time {
+			return time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+		}
+	}
+	runs := cfg.UpdateItemAPI
+	if runs == nil {
+		runs = &fakeDeliveryRuns{selected: true}
+	}
+	h, err := New(Config{
+		ListContactsAPI: &dummyListContactsAPIClient{},
+		NewListContactsPaginator: func(client sesv2.ListContactsAPIClient, params *sesv2.ListContactsInput, optFns ...func(*sesv2.ListContactsPaginatorOptions)) SESv2ListContactsPaginatorAPI {
+			return &stubSESv2ListContactsPaginatorAPI{pages: contacts}
+		},
+		ContactListName: "contacts",
+		SendMessageAPI:  sqsAPI,
+		QueueURL:        "queue",
+		ScanAPI:         &dummyScanAPIClient{},
+		NewScanPaginator: func(client dynamodb.ScanAPIClient, params *dynamodb.ScanInput, optFns ...func(*dynamodb.ScanPaginatorOptions)) DynamoDBScanPaginatorAPI {
+			if *params.TableName != SubscribersTableName {
+				t.Errorf("NewScanPaginator: got params.TableName with value %s; expected %s", *params.TableName, SubscribersTableName)
+			}
+			return &stubDynamoDBScanPaginatorAPI{subscribers: subscribers}
+		},
+		SubscribersTableName: SubscribersTableName,
+		QueryAPI:             &dummyQueryAPIClient{},
+		NewQueryPaginator: func(client dynamodb.QueryAPIClient, params *dynamodb.QueryInput, optFns ...func(*dynamodb.QueryPaginatorOptions)) DynamoDBQueryPaginatorAPI {
+			switch *params.TableName {
+			case HistoryTableName:
+				return &stubDynamoDBQueryPaginatorAPI{items: history.query(params)}
+			case BooksTableName:
+				date := params.ExpressionAttributeValues[":date"].(*types.AttributeValueMemberS).Value
+				items := []map[string]types.AttributeValue{}
+				for _, b := range pastBooks[date] {
+					item, _ := attributevalue.MarshalMap(b)
+					items = append(items, item)
+				}
+				return &stubDynamoDBQueryPaginatorAPI{items: items}
+			}
+			t.Errorf("NewQueryPaginator: got unexpected params.TableName with value %s", *params.TableName)
+			return &stubDynamoDBQueryPaginatorAPI{}
+		},
+		BooksTableName:   BooksTableName,
+		PutItemAPI:       history,
+		HistoryTableName: HistoryTableName,
+		HistoryLookback:  cfg.HistoryLookback,
+		UpdateItemAPI:    runs,
+		RunsTableName:    RunsTableName,
+		RepeatFallback:   cfg.RepeatFallback,
+		DefaultList:      DefaultList,
+		Rand:             rand.New(rand.NewSource(1)),
+		Now:              now,
+	})
+	if err != nil {
+		t.Fatalf("New: got err %v; expected nil", err)
+	}
+	return h
+}
+
+func TestNew(t *testing.T) {
+	for _, fallback := range []string{"", FallbackRepeat, FallbackAnyList, FallbackSkip} {
+		if _, err := New(Config{RepeatFallback: fallback}); err != nil {
+			t.Errorf("RepeatFallback %q: got err %v; expected nil", fallback, err)
+		}
+	}
+	if _, err := New(Config{RepeatFallback: "any"}); err == nil {
+		t.Error("RepeatFallback \"any\": got nil err; expected an error")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	bookList := []books.BestSellerBook{
+		{ListEncodedName: "hardcover-fiction", Title: "Fiction"},
+		{ListEncodedName: "hardcover-nonfiction", Title: "Nonfiction"},
+		{ListEncodedName: "manga", Title: "Manga"},
+	}
+	byList := map[string]books.BestSellerBook{}
+	for _, b := range bookList {
+		byList[b.ListEncodedName] = b
+	}
+
+	t.Run("pairs contacts with books from their lists", func(t *testing.T) {
+		subscribers := []books.Subscriber{
+			{EmailAddress: "manga@example.com", Lists: []string{"manga"}},
+			{EmailAddress: "nonfiction@example.com", Lists: []string{"hardcover-nonfiction", "unknown"}},
+			{EmailAddress: "default@example.com", Lists: []string{"unknown"}},
+		}
+		contacts := [][]string{
+			{"manga@example.com", "nonfiction@example.com"},
+			{"default@example.com"},
+		}
+		f := newFakeSQSSendMessageAPI()
+		h := newHandler(t, contacts, subscribers, nil, f)
+
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(os.Stderr)
+		if err := h.EnqueueContacts(bookList); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+
+		want := map[string]books.BestSellerBook{
+			"manga@example.com":      byList["manga"],
+			"nonfiction@example.com": byList["hardcover-nonfiction"],
+			"default@example.com":    byList[DefaultList],
+		}
+		if diff := cmp.Diff(want, f.sent); diff != "" {
+			t.Errorf("contacts paired with wrong books (-want +got):\n%s", diff)
+		}
+		if !strings.Contains(logs.String(), "1 contacts got a book from the default list") {
+			t.Errorf("summary doesn't count the contact that got a book from the default list:\n%s", logs.String())
+		}
+	})
+
+	t.Run("contacts without preferences get a book from any list", func(t *testing.T) {
+		contacts := [][]string{{"any1@example.com", "any2@example.com", "any3@example.com"}}
+		f := newFakeSQSSendMessageAPI()
+		h := newHandler(t, contacts, nil, nil, f)
+
+		if err := h.EnqueueContacts(bookList); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+
+		if len(f.sent) != 3 {
+			t.Fatalf("sent %d contacts; expected 3", len(f.sent))
+		}
+		for email, b := range f.sent {
+			if _, ok := byList[b.ListEncodedName]; !ok {
+				t.Errorf("contact %s got book from unexpected list %s", email, b.ListEncodedName)
+			}
+		}
+	})
+
+	t.Run("skips contacts with no matching book", func(t *testing.T) {
+		subscribers := []books.Subscriber{
+			{EmailAddress: "unmatched@example.com", Lists: []string{"unknown"}},
+			{EmailAddress: "manga@example.com", Lists: []string{"manga"}},
+		}
+		contacts := [][]string{{"unmatched@example.com", "manga@example.com"}}
+		f := newFakeSQSSendMessageAPI()
+		h := newHandler(t, contacts, subscribers, nil, f)
+
+		// The default list has no book in this input
+		if err := h.EnqueueContacts(bookList[1:]); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+
+		want := map[string]books.BestSellerBook{
+			"manga@example.com": byList["manga"],
+		}
+		if diff := cmp.Diff(want, f.sent); diff != "" {
+			t.Errorf("contacts paired with wrong books (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("skips paused contacts", func(t *testing.T) {
+		subscribers := []books.Subscriber{
+			{EmailAddress: "paused@example.com", Lists: []string{"manga"}, Paused: true},
+			{EmailAddress: "vacation@example.com", Lists: []string{"manga"}, PausedUntil: "2022-06-16"},
+			{EmailAddress: "resumed@example.com", Lists: []string{"manga"}, PausedUntil: "2022-06-15"},
+			// Saved before setting paused_until cleared paused
+			{EmailAddress: "resumed-indefinite@example.com", Lists: []string{"manga"}, Paused: true, PausedUntil: "2022-06-15"},
+			{EmailAddress: "manga@example.com", Lists: []string{"manga"}},
+		}
+		contacts := [][]string{{"paused@example.com", "vacation@example.com", "resumed@example.com", "resumed-indefinite@example.com", "manga@example.com"}}
+		f := newFakeSQSSendMessageAPI()
+		h := newHandler(t, contacts, subscribers, nil, f)
+
+		if err := h.EnqueueContacts(bookList); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+
+		want := map[string]books.BestSellerBook{
+			"resumed@example.com":            byList["manga"],
+			"resumed-indefinite@example.com": byList["manga"],
+			"manga@example.com":              byList["manga"],
+		}
+		if diff := cmp.Diff(want, f.sent); diff != "" {
+			t.Errorf("contacts paired with wrong books (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("sends weekly contacts a digest on their day", func(t *testing.T) {
+		// The handler's clock is on Wednesday, 2022-06-15
+		subscribers := []books.Subscriber{
+			{EmailAddress: "weekly@example.com", Lists: []string{"manga"}, Frequency: books.FrequencyWeekly, DeliveryDay: "wednesday"},
+			{EmailAddress: "other-day@example.com", Frequency: books.FrequencyWeekly, DeliveryDay: "monday"},
+			{EmailAddress: "daily@example.com", Lists: []string{"manga"}, Frequency: books.FrequencyDaily},
+		}
+		contacts := [][]string{{"weekly@example.com", "other-day@example.com", "daily@example.com"}}
+		pastBooks := map[string][]books.BestSellerBook{
+			"2022-06-08": {{ListEncodedName: "manga", Title: "Too Old"}},
+			"2022-06-09": {{ListEncodedName: "manga", Title: "Thursday"}},
+			"2022-06-12": {{ListEncodedName: "hardcover-fiction", Title: "Sunday Fiction"}, {ListEncodedName: "manga", Title: "Sunday"}},
+			"2022-06-14": {{ListEncodedName: "hardcover-nonfiction", Title: "Tuesday Nonfiction"}},
+		}
+		f := newFakeSQSSendMessageAPI()
+		h := newHandler(t, contacts, subscribers, pastBooks, f)
+
+		if err := h.EnqueueContacts(bookList); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+
+		wantSent := map[string]books.BestSellerBook{
+			"daily@example.com": byList["manga"],
+		}
+		if diff := cmp.Diff(wantSent, f.sent); diff != "" {
+			t.Errorf("daily contacts paired with wrong books (-want +got):\n%s", diff)
+		}
+
+		// Tuesday has no book from the subscriber's lists or the default list
+		wantDigests := map[string][]books.BestSellerBook{
+			"weekly@example.com": {
+				{ListEncodedName: "manga", Title: "Thursday"},
+				{ListEncodedName: "manga", Title: "Sunday"},
+				byList["manga"],
+			},
+		}
+		if diff := cmp.Diff(wantDigests, f.digests); diff != "" {
+			t.Errorf("weekly contacts got wrong digests (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("sends contacts at their delivery hour", func(t *testing.T) {
+		// The handler's clock is at 12:00 UTC, and hourly runs read today's books
+		subscribers := []books.Subscriber{
+			{EmailAddress: "default@example.com", Lists: []string{"manga"}},
+			{EmailAddress: "utc@example.com", Lists: []string{"manga"}, DeliveryHour: 12},
+			{EmailAddress: "tokyo@example.com", Lists: []string{"manga"}, TimeZone: "Asia/Tokyo", DeliveryHour: 21},
+			{EmailAddress: "tokyo-morning@example.com", Lists: []string{"manga"}, TimeZone: "Asia/Tokyo", DeliveryHour: 8},
+			{EmailAddress: "la@example.com", Lists: []string{"manga"}, TimeZone: "America/Los_Angeles", DeliveryHour: 5},
+			{EmailAddress: "la-paused@example.com", Lists: []string{"manga"}, TimeZone: "America/Los_Angeles", DeliveryHour: 5, PausedUntil: "2022-06-16"},
+			{EmailAddress: "la-weekly@example.com", TimeZone: "America/Los_Angeles", DeliveryHour: 5, Frequency: books.FrequencyWeekly, DeliveryDay: "wednesday"},
+		}
+		contacts := [][]string{{
+			"default@example.com", "utc@example.com", "tokyo@example.com", "tokyo-morning@example.com",
+			"la@example.com", "la-paused@example.com", "la-weekly@example.com",
+		}}
+		pastBooks := map[string][]books.BestSellerBook{
+			"2022-06-15": bookList,
+		}
+		f := newFakeSQSSendMessageAPI()
+		runs := &fakeDeliveryRuns{selected: true, sentThrough: "2022-06-15T11:00:00Z"}
+		h := newHandlerWithHistory(t, contacts, subscribers, pastBooks, &fakeDeliveryHistory{}, Config{UpdateItemAPI: runs}, f)
+
+		if err := h.EnqueueContacts(nil); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+
+		want := map[string]books.BestSellerBook{
+			"utc@example.com":   byList["manga"],
+			"tokyo@example.com": byList["manga"],
+			"la@example.com":    byList["manga"],
+		}
+		if diff := cmp.Diff(want, f.sent); diff != "" {
+			t.Errorf("contacts paired with wrong books (-want +got):\n%s", diff)
+		}
+		if _, ok := f.digests["la-weekly@example.com"]; !ok {
+			t.Errorf("weekly contact on their local delivery day did not get a digest")
+		}
+	})
+
+	t.Run("sends contacts once when daylight saving time skips or repeats their hour", func(t *testing.T) {
+		subscribers := []books.Subscriber{
+			{EmailAddress: "skipped@example.com", Lists: []string{"manga"}, TimeZone: "America/New_York", DeliveryHour: 2},
+			{EmailAddress: "repeated@example.com", Lists: []string{"manga"}, TimeZone: "America/New_York", DeliveryHour: 1},
+		}
+		contacts := [][]string{{"skipped@example.com", "repeated@example.com"}}
+		pastBooks := map[string][]books.BestSellerBook{
+			"2022-03-13": bookList,
+			"2022-11-06": bookList,
+		}
+		testCases := []struct {
+			now  time.Time
+			want []string
+		}{
+			// 2:00 EST is skipped, and 3:00 EDT follows 1:00 EST
+			{time.Date(2022, 3, 13, 7, 0, 0, 0, time.UTC), []string{"skipped@example.com"}},
+			// 1:00 EDT is followed by 1:00 EST
+			{time.Date(2022, 11, 6, 5, 0, 0, 0, time.UTC), []string{"repeated@example.com"}},
+			{time.Date(2022, 11, 6, 6, 0, 0, 0, time.UTC), nil},
+		}
+		for _, tc := range testCases {
+			f := newFakeSQSSendMessageAPI()
+			now := tc.now
+			runs := &fakeDeliveryRuns{selected: true, sentThrough: now.Add(-time.Hour).Format(time.RFC3339)}
+			h := newHandlerWithHistory(t, contacts, subscribers, pastBooks, &fakeDeliveryHistory{}, Config{Now: func() time.Time { return now }, UpdateItemAPI: runs}, f)
+			if err := h.EnqueueContacts(nil); err != nil {
+				t.Fatalf("%s: got error %v; expected nil", now, err)
+			}
+
+			var got []string
+			for email := range f.sent {
+				got = append(got, email)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s: wrong contacts sent (-want +got):\n%s", now, diff)
+			}
+		}
+	})
+
+	t.Run("sends contacts due at midnight UTC with the state machine's books", func(t *testing.T) {
+		subscribers := []books.Subscriber{
+			{EmailAddress: "default@example.com", Lists: []string{"manga"}},
+			{EmailAddress: "utc@example.com", Lists: []string{"manga"}, DeliveryHour: 12},
+			{EmailAddress: "tokyo@example.com", Lists: []string{"manga"}, TimeZone: "Asia/Tokyo", DeliveryHour: 9},
+		}
+		contacts := [][]string{{"default@example.com", "utc@example.com", "tokyo@example.com"}}
+		f := newFakeSQSSendMessageAPI()
+		h := newHandler(t, contacts, subscribers, nil, f)
+
+		if err := h.EnqueueContacts(bookList); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+
+		want := map[string]books.BestSellerBook{
+			"default@example.com": byList["manga"],
+			"tokyo@example.com":   byList["manga"],
+		}
+		if diff := cmp.Diff(want, f.sent); diff != "" {
+			t.Errorf("contacts paired with wrong books (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("defers contacts until the day's books are selected", func(t *testing.T) {
+		subscribers := []books.Subscriber{
+			{EmailAddress: "default@example.com", Lists: []string{"manga"}},
+			{EmailAddress: "noon@example.com", Lists: []string{"manga"}, DeliveryHour: 12},
+			{EmailAddress: "one@example.com", Lists: []string{"manga"}, DeliveryHour: 13},
+			{EmailAddress: "later@example.com", Lists: []string{"manga"}, DeliveryHour: 14},
+		}
+		contacts := [][]string{{"default@example.com", "noon@example.com", "one@example.com", "later@example.com"}}
+		pastBooks := map[string][]books.BestSellerBook{
+			"2022-06-15": bookList,
+		}
+		runs := &fakeDeliveryRuns{}
+		now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+		cfg := Config{Now: func() time.Time { return now }, UpdateItemAPI: runs}
+
+		f := newFakeSQSSendMessageAPI()
+		h := newHandlerWithHistory(t, contacts, subscribers, pastBooks, &fakeDeliveryHistory{}, cfg, f)
+		if err := h.EnqueueContacts(nil); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+		if len(f.sent) != 0 {
+			t.Errorf("sent %v before the books were selected; expected none", f.sent)
+		}
+
+		// The first run after the books are selected sends every hour up to its own
+		runs.selected = true
+		now = time.Date(2022, 6, 15, 13, 0, 0, 0, time.UTC)
+		h = newHandlerWithHistory(t, contacts, subscribers, pastBooks, &fakeDeliveryHistory{}, cfg, f)
+		if err := h.EnqueueContacts(nil); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+		want := map[string]books.BestSellerBook{
+			"default@example.com": byList["manga"],
+			"noon@example.com":    byList["manga"],
+			"one@example.com":     byList["manga"],
+		}
+		if diff := cmp.Diff(want, f.sent); diff != "" {
+			t.Errorf("contacts paired with wrong books (-want +got):\n%s", diff)
+		}
+
+		// A repeated run of the same hour sends nothing
+		f = newFakeSQSSendMessageAPI()
+		h = newHandlerWithHistory(t, contacts, subscribers, pastBooks, &fakeDeliveryHistory{}, cfg, f)
+		if err := h.EnqueueContacts(nil); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+		if len(f.sent) != 0 {
+			t.Errorf("sent %v again; expected none", f.sent)
+		}
+	})
+
+	t.Run("uses the contact's local date", func(t *testing.T) {
+		subscribers := []books.Subscriber{
+			// 20:00 on Tuesday, 2022-06-14
+			{EmailAddress: "la@example.com", Lists: []string{"manga"}, TimeZone: "America/Los_Angeles", DeliveryHour: 20},
+			{EmailAddress: "la-weekly@example.com", Lists: []string{"manga"}, TimeZone: "America/Los_Angeles", DeliveryHour: 20,
+				Frequency: books.FrequencyWeekly, DeliveryDay: "tuesday"},
+			// 15:00 on Wednesday, 2022-06-15
+			{EmailAddress: "auckland@example.com", Lists: []string{"manga"}, TimeZone: "Pacific/Auckland", DeliveryHour: 15},
+		}
+		contacts := [][]string{{"la@example.com", "la-weekly@example.com", "auckland@example.com"}}
+		pastBooks := map[string][]books.BestSellerBook{
+			"2022-06-14": {
+				{ListEncodedName: "manga", Title: "Seen Tuesday", PrimaryISBN13: "1"},
+				{ListEncodedName: "manga", Title: "New Tuesday", PrimaryISBN13: "2"},
+			},
+			"2022-06-15": {{ListEncodedName: "manga", Title: "Wednesday", PrimaryISBN13: "3"}},
+		}
+		// Inside the look-back window from the local date, but not from the UTC date
+		history := &fakeDeliveryHistory{records: []books.DeliveryRecord{
+			{ContactEmail: "la@example.com", DateSent: "2022-05-15", BestSellerBook: books.BestSellerBook{PrimaryISBN13: "1"}},
+		}}
+		now := time.Date(2022, 6, 15, 3, 0, 0, 0, time.UTC)
+		runs := &fakeDeliveryRuns{selected: true, sentThrough: "2022-06-15T02:00:00Z"}
+		cfg := Config{Now: func() time.Time { return now }, UpdateItemAPI: runs, HistoryLookback: 30 * 24 * time.Hour}
+		f := newFakeSQSSendMessageAPI()
+		h := newHandlerWithHistory(t, contacts, subscribers, pastBooks, history, cfg, f)
+
+		if err := h.EnqueueContacts(nil); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+
+		want := map[string]books.BestSellerBook{
+			"la@example.com":       pastBooks["2022-06-14"][1],
+			"auckland@example.com": pastBooks["2022-06-15"][0],
+		}
+		if diff := cmp.Diff(want, f.sent); diff != "" {
+			t.Errorf("contacts paired with wrong books (-want +got):\n%s", diff)
+		}
+		if digest := f.digests["la-weekly@example.com"]; len(digest) == 0 || digest[len(digest)-1].Title == "Wednesday" {
+			t.Errorf("weekly contact got digest %v; expected one ending on their local date", digest)
+		}
+		for _, r := range history.records {
+			if r.ContactEmail == "la@example.com" && r.PrimaryISBN13 == "2" && r.DateSent != "2022-06-14" {
+				t.Errorf("got DateSent %s; expected the local date 2022-06-14", r.DateSent)
+			}
+		}
+	})
+
+	t.Run("records the books sent to contacts", func(t *testing.T) {
+		subscribers := []books.Subscriber{
+			{EmailAddress: "daily@example.com", Lists: []string{"manga"}},
+			{EmailAddress: "weekly@example.com", Lists: []string{"manga"}, Frequency: books.FrequencyWeekly, DeliveryDay: "wednesday"},
+		}
+		contacts := [][]string{{"daily@example.com", "weekly@example.com"}}
+		pastBooks := map[string][]books.BestSellerBook{
+			"2022-06-12": {{ListEncodedName: "manga", Title: "Sunday", PrimaryISBN13: "12"}},
+		}
+		isbnBooks := []books.BestSellerBook{{ListEncodedName: "manga", Title: "Manga", PrimaryISBN13: "15"}}
+		history := &fakeDeliveryHistory{}
+		f := newFakeSQSSendMessageAPI()
+		h := newHandlerWithHistory(t, contacts, subscribers, pastBooks, history, Config{}, f)
+
+		if err := h.EnqueueContacts(isbnBooks); err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+
+		if diff := cmp.Diff([]string{"15"}, history.sent("daily@example.com")); diff != "" {
+			t.Errorf("wrong history for daily contact (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{"12", "15"}, history.sent("weekly@example.com")); diff != "" {
+			t.Errorf("wrong history for weekly contact (-want +got):\n%s", diff)
+		}
+		for _, r := range history.records {
+			if r.DateSent != "2022-06-15" {
+				t.Errorf("got DateSent %s; expected 2022-06-15", r.DateSent)
+			}
+		}
+	})
+
+	t.Run("does not repeat books", func(t *testing.T) {
+		todaysBooks := []books.BestSellerBook{
+			{ListEncodedName: "hardcover-fiction", Title: "Fiction", PrimaryISBN13: "1"},
+			{ListEncodedName: "manga", Title: "Seen Manga", PrimaryISBN13: "2"},
+			{ListEncodedName: "manga", Title: "New Manga", PrimaryISBN13: "3"},
+			{ListEncodedName: "hardcover-nonfiction", Title: "Seen Nonfiction", PrimaryISBN13: "4"},
+		}
+		byTitle := map[string]books.BestSellerBook{}
+		for _, b := range todaysBooks {
+			byTitle[b.Title] = b
+		}
+		subscribers := []books.Subscriber{
+			{EmailAddress: "manga@example.com", Lists: []string{"manga"}},
+			{EmailAddress: "nonfiction@example.com", Lists: []string{"hardcover-nonfiction"}},
+			{EmailAddress: "all-seen@example.com", Lists: []string{"hardcover-nonfiction"}},
+			{EmailAddress: "long-ago@example.com", Lists: []string{"hardcover-nonfiction"}},
+		}
+		contacts := [][]string{{"manga@example.com", "nonfiction@example.com", "all-seen@example.com", "long-ago@example.com"}}
+		records := []books.DeliveryRecord{
+			{ContactEmail: "manga@example.com", DateSent: "2022-06-01", BestSellerBook: books.BestSellerBook{PrimaryISBN13: "2"}},
+			{ContactEmail: "nonfiction@example.com", DateSent: "2022-06-01", BestSellerBook: books.BestSellerBook{PrimaryISBN13: "4"}},
+			{ContactEmail: "all-seen@example.com", DateSent: "2022-06-01", BestSellerBook: books.BestSellerBook{PrimaryISBN13: "1"}},
+			{ContactEmail: "all-seen@example.com", DateSent: "2022-06-01", BestSellerBook: books.BestSellerBook{PrimaryISBN13: "4"}},
+			{ContactEmail: "all-seen@example.com", DateSent: "2022-06-01", BestSellerBook: books.BestSellerBook{PrimaryISBN13: "2"}},
+			{ContactEmail: "long-ago@example.com", DateSent: "2021-01-01", BestSellerBook: books.BestSellerBook{PrimaryISBN13: "4"}},
+		}
+		lookback := 90 * 24 * time.Hour
+
+		testCases := []struct {
+			fallback string
+			want     map[string]books.BestSellerBook
+		}{
+			{FallbackRepeat, map[string]books.BestSellerBook{
+				"manga@example.com":      byTitle["New Manga"],
+				"nonfiction@example.com": byTitle["Fiction"],
+				"all-seen@example.com":   byTitle["Seen Nonfiction"],
+				"long-ago@example.com":   byTitle["Seen Nonfiction"],
+			}},
+			{FallbackAnyList, map[string]books.BestSellerBook{
+				"manga@example.com":      byTitle["New Manga"],
+				"nonfiction@example.com": byTitle["Fiction"],
+				"all-seen@example.com":   byTitle["New Manga"],
+				"long-ago@example.com":   byTitle["Seen Nonfiction"],
+			}},
+			{FallbackSkip, map[string]books.BestSellerBook{
+				"manga@example.com":      byTitle["New Manga"],
+				"nonfiction@example.com": byTitle["Fiction"],
+				"long-ago@example.com":   byTitle["Seen Nonfiction"],
+			}},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.fallback, func(t *testing.T) {
+				history := &fakeDeliveryHistory{records: append([]books.DeliveryRecord{}, records...)}
+				f := newFakeSQSSendMessageAPI()
+				h := newHandlerWithHistory(t, contacts, subscribers, nil, history, Config{HistoryLookback: lookback, RepeatFallback: tc.fallback}, f)
+
+				if err := h.EnqueueContacts(todaysBooks); err != nil {
+					t.Fatalf("got error %v; expected nil", err)
+				}
+
+				if diff := cmp.Diff(tc.want, f.sent); diff != "" {
+					t.Errorf("contacts paired with wrong books (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
+
+	t.Run("returns error when no books were selected today", func(t *testing.T) {
+		f := newFakeSQSSendMessageAPI()
+		h := newHandler(t, nil, nil, nil, f)
+
+		if err := h.EnqueueContacts(nil); err == nil {
+			t.Errorf("got nil error; expected non-nil")
+		}
+	})
+}
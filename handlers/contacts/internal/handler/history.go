@@ -0,0 +1,146 @@
+package handler
+
+import (
+	books "bookoftheday/types"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	sestypes "github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// Strategies for pairing a contact when every candidate book was already sent to them.
+const (
+	// FallbackRepeat sends a book the contact already received from the lists they
+	// would normally get a book from. It is the default.
+	FallbackRepeat = "repeat"
+
+	// FallbackAnyList sends a new book from any list, or nothing if there is none.
+	FallbackAnyList = "any-list"
+
+	// FallbackSkip sends nothing to the contact that day.
+	FallbackSkip = "skip"
+)
+
+// historyQueries is the number of delivery histories that are queried at once.
+const historyQueries = 16
+
+// dueContact is a contact that gets a book on this run, with the books that were
+// already sent to them.
+type dueContact struct {
+	contact sestypes.Contact
+	sub     books.Subscriber
+	seen    map[string]bool
+
+	// date is the contact's local date, and booksDate is the date of the books they get.
+	date      time.Time
+	booksDate time.Time
+}
+
+// getSeenBooksOfDue sets the seen books of every contact in due, querying their delivery
+// histories concurrently so that a run with many due contacts doesn't time out.
+func (h *Handler) getSeenBooksOfDue(ctx context.Context, due []dueContact) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := make(chan struct{}, historyQueries)
+	errs := make(chan error, len(due))
+	var wg sync.WaitGroup
+	for i := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(dc *dueContact) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			seen, err := h.getSeenBooks(ctx, *dc.contact.EmailAddress, dc.date)
+			if err != nil {
+				errs <- err
+				cancel()
+				return
+			}
+			dc.seen = seen
+		}(&due[i])
+	}
+	wg.Wait()
+	close(errs)
+
+	// Receiving from the closed channel is nil if every query succeeded
+	return <-errs
+}
+
+// getSeenBooks returns the ISBN13s of the books sent to email since the start of the
+// history look-back window ending on date, the contact's local date.
+func (h *Handler) getSeenBooks(ctx context.Context, email string, date time.Time) (map[string]bool, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              &h.historyTableName,
+		KeyConditionExpression: aws.String("ContactEmail = :email"),
+		ProjectionExpression:   aws.String("PrimaryISBN13"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":email": &ddbtypes.AttributeValueMemberS{Value: email},
+		},
+	}
+	if h.historyLookback > 0 {
+		since := date.Add(-h.historyLookback).Format(ymdLayout)
+		input.FilterExpression = aws.String("DateSent >= :since")
+		input.ExpressionAttributeValues[":since"] = &ddbtypes.AttributeValueMemberS{Value: since}
+	}
+
+	seen := map[string]bool{}
+	p := h.newQueryPaginator(h.queryAPI, input)
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not query delivery history of %s: %w", email, err)
+		}
+
+		var data []books.DeliveryRecord
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &data); err != nil {
+			return nil, fmt.Errorf("could not unmarshal delivery history: %w", err)
+		}
+		for _, r := range data {
+			seen[r.PrimaryISBN13] = true
+		}
+	}
+	return seen, nil
+}
+
+// unseen returns the books that aren't in seen. Books without an ISBN13 can't be
+// told apart, so they are never considered seen.
+func unseen(bookList []books.BestSellerBook, seen map[string]bool) []books.BestSellerBook {
+	var u []books.BestSellerBook
+	for _, b := range bookList {
+		if b.PrimaryISBN13 == "" || !seen[b.PrimaryISBN13] {
+			u = append(u, b)
+		}
+	}
+	return u
+}
+
+// recordDelivery adds the books sent to email to its delivery history.
+func (h *Handler) recordDelivery(ctx context.Context, email string, date string, sent []books.BestSellerBook) error {
+	for _, b := range sent {
+		if b.PrimaryISBN13 == "" {
+			continue
+		}
+
+		item, err := attributevalue.MarshalMap(books.DeliveryRecord{ContactEmail: email, DateSent: date, BestSellerBook: b})
+		if err != nil {
+			return fmt.Errorf("could not marshal delivery record: %w", err)
+		}
+		_, err = h.putItemAPI.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: &h.historyTableName,
+			Item:      item,
+		})
+		if err != nil {
+			return fmt.Errorf("could not record delivery of %s: %w", b.PrimaryISBN13, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	books "bookoftheday/types"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// claimHours claims the delivery hours up to runTime that no run has sent yet on its day,
+// and returns them oldest first. The state machine marks the day's books as selected in
+// the runs table once it has picked them, and no hours can be claimed before then, so
+// the contacts due in those hours are sent by the first run afterwards instead of getting
+// no book or only some of the day's books. The first claim of the day includes midnight.
+// Nothing is returned if the books aren't selected yet or runTime was already claimed.
+func (h *Handler) claimHours(ctx context.Context, runTime time.Time) ([]time.Time, error) {
+	hour := runTime.Format(time.RFC3339)
+	out, err := h.updateItemAPI.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &h.runsTableName,
+		Key: map[string]ddbtypes.AttributeValue{
+			"DateSelected": &ddbtypes.AttributeValueMemberS{Value: runTime.Format(ymdLayout)},
+		},
+		UpdateExpression:    aws.String("SET SentThrough = :hour"),
+		ConditionExpression: aws.String("attribute_exists(SelectedAt) AND (attribute_not_exists(SentThrough) OR SentThrough < :hour)"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":hour": &ddbtypes.AttributeValueMemberS{Value: hour},
+		},
+		ReturnValues: ddbtypes.ReturnValueUpdatedOld,
+	})
+	var condErr *ddbtypes.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not claim delivery hours through %s: %w", hour, err)
+	}
+
+	// The hour before midnight belongs to the previous day
+	from := startOfDay(runTime).Add(-time.Hour)
+	if v, ok := out.Attributes["SentThrough"].(*ddbtypes.AttributeValueMemberS); ok {
+		from, err = time.Parse(time.RFC3339, v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse delivery hours sent through %q: %w", v.Value, err)
+		}
+	}
+
+	var hours []time.Time
+	for t := from.Add(time.Hour); !t.After(runTime); t = t.Add(time.Hour) {
+		hours = append(hours, t)
+	}
+	return hours, nil
+}
+
+// dueTime returns the subscriber's local time of the first run in hours that is their
+// delivery hour. The second return value is false if none of them is.
+func (l locations) dueTime(sub books.Subscriber, hours []time.Time) (time.Time, bool) {
+	for _, t := range hours {
+		if local := l.localTime(sub, t); isDeliveryHour(local, sub.DeliveryHour) {
+			return local, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// startOfDay returns midnight UTC of t's date, ignoring its time zone.
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
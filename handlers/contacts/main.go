@@ -6,10 +6,16 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"strconv"
 	"time"
 
+	// Embed the time zone database so that subscribers' time zones can be loaded
+	// regardless of what the Lambda runtime provides
+	_ "time/tzdata"
+
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
@@ -29,8 +35,51 @@ func main() {
 
 	sqsClient := sqs.NewFromConfig(cfg)
 
+	ddbClient := dynamodb.NewFromConfig(cfg)
+	newScanPaginator := func(
+		client dynamodb.ScanAPIClient, params *dynamodb.ScanInput, optFns ...func(*dynamodb.ScanPaginatorOptions),
+	) handler.DynamoDBScanPaginatorAPI {
+		return dynamodb.NewScanPaginator(client, params, optFns...)
+	}
+	newQueryPaginator := func(
+		client dynamodb.QueryAPIClient, params *dynamodb.QueryInput, optFns ...func(*dynamodb.QueryPaginatorOptions),
+	) handler.DynamoDBQueryPaginatorAPI {
+		return dynamodb.NewQueryPaginator(client, params, optFns...)
+	}
+
+	var lookbackDays int
+	if v := os.Getenv("HISTORY_LOOKBACK_DAYS"); v != "" {
+		lookbackDays, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatalln("invalid HISTORY_LOOKBACK_DAYS: " + err.Error())
+		}
+	}
+
 	s := rand.NewSource(time.Now().UnixNano())
 	r := rand.New(s)
-	h := handler.New(sesClient, newListContactsPaginator, os.Getenv("CONTACT_LIST_NAME"), sqsClient, os.Getenv("EMAIL_QUEUE_URL"), r)
+	h, err := handler.New(handler.Config{
+		ListContactsAPI:          sesClient,
+		NewListContactsPaginator: newListContactsPaginator,
+		ContactListName:          os.Getenv("CONTACT_LIST_NAME"),
+		SendMessageAPI:           sqsClient,
+		QueueURL:                 os.Getenv("EMAIL_QUEUE_URL"),
+		ScanAPI:                  ddbClient,
+		NewScanPaginator:         newScanPaginator,
+		SubscribersTableName:     os.Getenv("SUBSCRIBERS_TABLE_NAME"),
+		QueryAPI:                 ddbClient,
+		NewQueryPaginator:        newQueryPaginator,
+		BooksTableName:           os.Getenv("BOOKS_TABLE_NAME"),
+		PutItemAPI:               ddbClient,
+		HistoryTableName:         os.Getenv("HISTORY_TABLE_NAME"),
+		HistoryLookback:          time.Duration(lookbackDays) * 24 * time.Hour,
+		UpdateItemAPI:            ddbClient,
+		RunsTableName:            os.Getenv("RUNS_TABLE_NAME"),
+		RepeatFallback:           os.Getenv("REPEAT_FALLBACK"),
+		DefaultList:              os.Getenv("DEFAULT_LIST_NAME"),
+		Rand:                     r,
+	})
+	if err != nil {
+		log.Fatalln("configuration error: " + err.Error())
+	}
 	lambda.Start(h.EnqueueContacts)
 }
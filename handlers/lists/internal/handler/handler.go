@@ -4,7 +4,9 @@ package handler
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"bookoftheday/httpcache"
 	"bookoftheday/types"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -22,20 +24,75 @@ type DynamoDBNewScanPaginatorAPI func(
 	client dynamodb.ScanAPIClient, params *dynamodb.ScanInput, optFns ...func(*dynamodb.ScanPaginatorOptions),
 ) DynamoDBScanPaginatorAPI
 
+// DynamoDBGetItemAPI provides a unit-testable interface to access the DynamoDB GetItem API.
+type DynamoDBGetItemAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// DynamoDBQueryPaginatorAPI is a convenience wrapper over DynamoDB query operations and is unit-testable.
+type DynamoDBQueryPaginatorAPI interface {
+	HasMorePages() bool
+	NextPage(ctx context.Context, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// DynamoDBNewQueryPaginatorAPI is a type that allows creating instances of DynamoDBQueryPaginatorAPI.
+type DynamoDBNewQueryPaginatorAPI func(
+	client dynamodb.QueryAPIClient, params *dynamodb.QueryInput, optFns ...func(*dynamodb.QueryPaginatorOptions),
+) DynamoDBQueryPaginatorAPI
+
 // Handler provides the state and implementation of the main Lambda function.
 type Handler struct {
-	scanClient       dynamodb.ScanAPIClient
-	newScanPaginator DynamoDBNewScanPaginatorAPI
-	tableName        string
+	scanClient        dynamodb.ScanAPIClient
+	newScanPaginator  DynamoDBNewScanPaginatorAPI
+	tableName         string
+	getItem           DynamoDBGetItemAPI
+	queryClient       dynamodb.QueryAPIClient
+	newQueryPaginator DynamoDBNewQueryPaginatorAPI
+	booksTableName    string
+	now               func() time.Time
+	schedule          httpcache.Schedule
+}
+
+// Config provides configuration options for a Handler.
+type Config struct {
+	ScanAPI          dynamodb.ScanAPIClient
+	NewScanPaginator DynamoDBNewScanPaginatorAPI
+
+	// TableName is the table of Best-Seller lists.
+	TableName string
+
+	GetItemAPI        DynamoDBGetItemAPI
+	QueryAPI          dynamodb.QueryAPIClient
+	NewQueryPaginator DynamoDBNewQueryPaginatorAPI
+
+	// BooksTableName is the table that the recent books of a list are read from.
+	BooksTableName string
+
+	// Now returns the current time, which decides how long responses are cached.
+	// It defaults to time.Now.
+	Now func() time.Time
 }
 
+// listsSchedule is when the lists are refreshed, every Sunday at 11:59PM UTC.
+var listsSchedule = httpcache.Weekly(time.Sunday, 23, 59, 10*time.Minute)
+
 // New creates a new Handler instance.
-func New(sc dynamodb.ScanAPIClient, nsp DynamoDBNewScanPaginatorAPI, tableName string) *Handler {
-	return &Handler{
-		sc,
-		nsp,
-		tableName,
+func New(cfg Config) *Handler {
+	h := &Handler{
+		scanClient:        cfg.ScanAPI,
+		newScanPaginator:  cfg.NewScanPaginator,
+		tableName:         cfg.TableName,
+		getItem:           cfg.GetItemAPI,
+		queryClient:       cfg.QueryAPI,
+		newQueryPaginator: cfg.NewQueryPaginator,
+		booksTableName:    cfg.BooksTableName,
+		now:               cfg.Now,
+		schedule:          listsSchedule,
+	}
+	if h.now == nil {
+		h.now = time.Now
 	}
+	return h
 }
 
 // BestSellerListsResponse contains the response data from calling GetBestSellerLists successfully.
@@ -45,7 +102,7 @@ type BestSellerListsResponse struct {
 	Lists       []types.BestSellerList `json:"lists"`
 }
 
-const Attribution = "Data provided by The New York Times: https://developer.nytimes.com"
+const Attribution = types.Attribution
 
 // GetBestSellerLists returns the complete collection of Best-Seller lists currently stored
 // in the associated table.
@@ -1,12 +1,17 @@
 package handler
 
 import (
+	"bookoftheday/openapi"
 	books "bookoftheday/types"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -106,7 +111,7 @@ func TestHandler(t *testing.T) {
 				pageErrorNum: tc.pageErrorNum,
 			}
 			m := &mockDynamoDBNewScanPaginatorAPIProvider{t, f}
-			h := New(&dummyScanAPIClient{}, m.mockDynamoDBNewScanPaginatorAPI, TableName)
+			h := New(Config{ScanAPI: &dummyScanAPIClient{}, NewScanPaginator: m.mockDynamoDBNewScanPaginatorAPI, TableName: TableName})
 
 			out, err := h.GetBestSellerLists()
 
@@ -144,3 +149,364 @@ func TestHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTP(t *testing.T) {
+	// 2022-06-15 was a Wednesday, and the lists were last refreshed on Sunday the 12th
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	get := func(t *testing.T, headers map[string]string) events.APIGatewayV2HTTPResponse {
+		f := &stubDynamoDBScanPaginatorAPI{np: 1, pages: [][]books.BestSellerList{{{EncodedName: "manga", DisplayName: "Graphic Books and Manga"}}}}
+		m := &mockDynamoDBNewScanPaginatorAPIProvider{t, f}
+		h := New(Config{ScanAPI: &dummyScanAPIClient{}, NewScanPaginator: m.mockDynamoDBNewScanPaginatorAPI, TableName: TableName, Now: func() time.Time { return now }})
+		res, err := h.GetBestSellerListsHTTP(events.APIGatewayV2HTTPRequest{Headers: headers})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		return res
+	}
+
+	res := get(t, nil)
+	if res.StatusCode != 200 {
+		t.Fatalf("unexpected StatusCode value: got %d; expected 200", res.StatusCode)
+	}
+	var body BestSellerListsResponse
+	if err := json.Unmarshal([]byte(res.Body), &body); err != nil || body.Count != 1 || body.Lists[0].EncodedName != "manga" {
+		t.Errorf("wrong body: got %s", res.Body)
+	}
+	etag := res.Headers["etag"]
+	want := map[string]string{
+		"content-type":  "application/json",
+		"etag":          etag,
+		"last-modified": "Sun, 12 Jun 2022 23:59:00 GMT",
+		"cache-control": "public, max-age=389340",
+	}
+	if diff := cmp.Diff(want, res.Headers); etag == "" || diff != "" {
+		t.Errorf("wrong headers (-want +got):\n%s", diff)
+	}
+
+	for _, headers := range []map[string]string{
+		{"if-none-match": etag},
+		{"if-modified-since": "Sun, 12 Jun 2022 23:59:00 GMT"},
+	} {
+		if res := get(t, headers); res.StatusCode != 304 || res.Body != "" {
+			t.Errorf("got StatusCode %d and body %q for %v; expected 304", res.StatusCode, res.Body, headers)
+		}
+	}
+	if res := get(t, map[string]string{"if-modified-since": "Sun, 05 Jun 2022 23:59:00 GMT"}); res.StatusCode != 200 {
+		t.Errorf("unexpected StatusCode value: got %d; expected 200", res.StatusCode)
+	}
+}
+
+func TestHTTPFilters(t *testing.T) {
+	// The lists were last refreshed on Sunday 2022-06-12
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	lists := []books.BestSellerList{
+		{EncodedName: "manga", DisplayName: "Graphic Books and Manga", UpdatePeriod: "MONTHLY", NewestPublishedDate: "2022-06-05"},
+		{EncodedName: "hardcover-fiction", DisplayName: "Hardcover Fiction", UpdatePeriod: "WEEKLY", NewestPublishedDate: "2022-06-19"},
+		{EncodedName: "e-book-fiction", DisplayName: "E-Book Fiction", UpdatePeriod: "WEEKLY", NewestPublishedDate: "2017-01-29"},
+		{EncodedName: "business-books", DisplayName: "business", UpdatePeriod: "MONTHLY", NewestPublishedDate: "2022-04-10"},
+	}
+	get := func(t *testing.T, query map[string]string) (events.APIGatewayV2HTTPResponse, string) {
+		f := &stubDynamoDBScanPaginatorAPI{np: 1, pages: [][]books.BestSellerList{lists}}
+		m := &mockDynamoDBNewScanPaginatorAPIProvider{t, f}
+		h := New(Config{ScanAPI: &dummyScanAPIClient{}, NewScanPaginator: m.mockDynamoDBNewScanPaginatorAPI, TableName: TableName, Now: func() time.Time { return now }})
+		res, err := h.GetBestSellerListsHTTP(events.APIGatewayV2HTTPRequest{QueryStringParameters: query})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		if res.StatusCode != 200 {
+			return res, ""
+		}
+		var body BestSellerListsResponse
+		if err := json.Unmarshal([]byte(res.Body), &body); err != nil {
+			t.Fatalf("invalid body: %v", err)
+		}
+		var names []string
+		for _, l := range body.Lists {
+			names = append(names, l.EncodedName)
+		}
+		if body.Count != len(body.Lists) {
+			t.Errorf("got count %d; expected %d", body.Count, len(body.Lists))
+		}
+		return res, strings.Join(names, ",")
+	}
+
+	testCases := []struct {
+		name  string
+		query map[string]string
+		want  string
+	}{
+		{"no filters", map[string]string{}, "manga,hardcover-fiction,e-book-fiction,business-books"},
+		{"updated", map[string]string{"updated": "weekly"}, "hardcover-fiction,e-book-fiction"},
+		{"active", map[string]string{"active": "true"}, "manga,hardcover-fiction"},
+		{"inactive", map[string]string{"active": "false"}, "e-book-fiction,business-books"},
+		{"active weeks", map[string]string{"active": "true", "active_weeks": "10"}, "manga,hardcover-fiction,business-books"},
+		{"sort asc", map[string]string{"sort": "asc"}, "business-books,e-book-fiction,manga,hardcover-fiction"},
+		{"sort desc with filter", map[string]string{"sort": "desc", "updated": "MONTHLY"}, "manga,business-books"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, got := get(t, tc.query)
+			if res.StatusCode != 200 {
+				t.Fatalf("unexpected StatusCode value: got %d; expected 200", res.StatusCode)
+			}
+			if got != tc.want {
+				t.Errorf("got lists %s; expected %s", got, tc.want)
+			}
+		})
+	}
+
+	errorCases := []struct {
+		name  string
+		query map[string]string
+		field string
+	}{
+		{"invalid updated", map[string]string{"updated": "DAILY"}, "updated"},
+		{"invalid active", map[string]string{"active": "yes"}, "active"},
+		{"invalid active weeks", map[string]string{"active": "true", "active_weeks": "0"}, "active_weeks"},
+		{"active weeks without active", map[string]string{"active_weeks": "4"}, "active_weeks"},
+		{"invalid sort", map[string]string{"sort": "name"}, "sort"},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, _ := get(t, tc.query)
+			if res.StatusCode != 400 {
+				t.Fatalf("unexpected StatusCode value: got %d; expected 400", res.StatusCode)
+			}
+			var body ErrorResponse
+			_ = json.Unmarshal([]byte(res.Body), &body)
+			if len(body.Errors) != 1 || body.Errors[0].Field != tc.field {
+				t.Errorf("got errors %v; expected one for %s", body.Errors, tc.field)
+			}
+			if _, ok := res.Headers["etag"]; ok {
+				t.Error("got etag on error response; expected none")
+			}
+		})
+	}
+}
+
+type stubDynamoDBGetItemAPI struct {
+	items map[string]books.BestSellerList
+	err   error
+}
+
+func (s *stubDynamoDBGetItemAPI) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	var key struct{ EncodedName string }
+	_ = attributevalue.UnmarshalMap(params.Key, &key)
+	l, ok := s.items[key.EncodedName]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	item, _ := attributevalue.MarshalMap(l)
+	return &dynamodb.GetItemOutput{Item: item}, nil
+}
+
+type dummyQueryAPIClient struct{}
+
+func (d *dummyQueryAPIClient) Query(context.Context, *dynamodb.QueryInput, ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return nil, nil
+}
+
+// stubDynamoDBQueryPaginatorAPI returns one page of books, ignoring Limit like DynamoDB
+// can when an item is larger than expected.
+type stubDynamoDBQueryPaginatorAPI struct {
+	books []books.BestSellerBook
+	done  bool
+}
+
+func (s *stubDynamoDBQueryPaginatorAPI) HasMorePages() bool {
+	return !s.done
+}
+
+func (s *stubDynamoDBQueryPaginatorAPI) NextPage(ctx context.Context, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	s.done = true
+	items, _ := attributevalue.MarshalList(s.books)
+	out := &dynamodb.QueryOutput{Count: int32(len(items))}
+	for _, item := range items {
+		out.Items = append(out.Items, item.(*types.AttributeValueMemberM).Value)
+	}
+	return out, nil
+}
+
+func TestGetList(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	manga := books.BestSellerList{EncodedName: "manga", DisplayName: "Graphic Books and Manga", UpdatePeriod: "MONTHLY"}
+	var recent []books.BestSellerBook
+	for _, d := range []string{"2022-06-15", "2022-06-14", "2022-06-13", "2022-06-12", "2022-06-11", "2022-06-10", "2022-06-09", "2022-06-08"} {
+		recent = append(recent, books.BestSellerBook{ListEncodedName: "manga", DateSelected: d, Title: "BOOK " + d})
+	}
+
+	get := func(t *testing.T, name string, query map[string]string) (events.APIGatewayV2HTTPResponse, []*dynamodb.QueryInput) {
+		var queries []*dynamodb.QueryInput
+		h := New(Config{
+			TableName:  TableName,
+			GetItemAPI: &stubDynamoDBGetItemAPI{items: map[string]books.BestSellerList{"manga": manga}},
+			QueryAPI:   &dummyQueryAPIClient{},
+			NewQueryPaginator: func(client dynamodb.QueryAPIClient, params *dynamodb.QueryInput, optFns ...func(*dynamodb.QueryPaginatorOptions)) DynamoDBQueryPaginatorAPI {
+				queries = append(queries, params)
+				return &stubDynamoDBQueryPaginatorAPI{books: recent}
+			},
+			BooksTableName: "Books",
+			Now:            func() time.Time { return now },
+		})
+		res, err := h.Route(events.APIGatewayV2HTTPRequest{
+			RouteKey:              "GET /lists/{encodedName}",
+			PathParameters:        map[string]string{"encodedName": name},
+			QueryStringParameters: query,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		return res, queries
+	}
+
+	t.Run("returns list with recent books", func(t *testing.T) {
+		res, queries := get(t, "manga", nil)
+		if res.StatusCode != 200 {
+			t.Fatalf("unexpected StatusCode value: got %d; expected 200", res.StatusCode)
+		}
+		var body BestSellerListResponse
+		if err := json.Unmarshal([]byte(res.Body), &body); err != nil {
+			t.Fatalf("invalid body: %v", err)
+		}
+		want := BestSellerListResponse{Attribution: Attribution, List: manga, Count: 7, Books: recent[:7]}
+		if diff := cmp.Diff(want, body); diff != "" {
+			t.Errorf("wrong body (-want +got):\n%s", diff)
+		}
+		if len(queries) != 1 || *queries[0].TableName != "Books" || *queries[0].ScanIndexForward || *queries[0].Limit != 7 {
+			t.Errorf("wrong query: got %+v", queries)
+		}
+		if res.Headers["cache-control"] != "public, max-age=45000" || res.Headers["etag"] == "" {
+			t.Errorf("wrong caching headers: got %v", res.Headers)
+		}
+	})
+
+	t.Run("limits books", func(t *testing.T) {
+		res, _ := get(t, "manga", map[string]string{"limit": "2"})
+		var body BestSellerListResponse
+		_ = json.Unmarshal([]byte(res.Body), &body)
+		if body.Count != 2 || len(body.Books) != 2 {
+			t.Errorf("got %d books; expected 2", len(body.Books))
+		}
+	})
+
+	errorCases := []struct {
+		name   string
+		list   string
+		query  map[string]string
+		status int
+		field  string
+	}{
+		{"unknown list", "hardcover-fiction", nil, 404, "encodedName"},
+		{"invalid list", "manga!", nil, 400, "encodedName"},
+		{"invalid limit", "manga", map[string]string{"limit": "32"}, 400, "limit"},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, queries := get(t, tc.list, tc.query)
+			if res.StatusCode != tc.status {
+				t.Fatalf("unexpected StatusCode value: got %d; expected %d", res.StatusCode, tc.status)
+			}
+			var body ErrorResponse
+			_ = json.Unmarshal([]byte(res.Body), &body)
+			if len(body.Errors) != 1 || body.Errors[0].Field != tc.field {
+				t.Errorf("got errors %v; expected one for %s", body.Errors, tc.field)
+			}
+			if len(queries) != 0 {
+				t.Errorf("got %d book queries; expected none", len(queries))
+			}
+		})
+	}
+
+	t.Run("returns error from GetItem", func(t *testing.T) {
+		getErr := errors.New("error")
+		h := New(Config{GetItemAPI: &stubDynamoDBGetItemAPI{err: getErr}})
+		res, err := h.GetList(events.APIGatewayV2HTTPRequest{PathParameters: map[string]string{"encodedName": "manga"}})
+		if !errors.Is(err, getErr) || res.StatusCode != 500 {
+			t.Errorf("got StatusCode %d and error %v; expected 500 and %v", res.StatusCode, err, getErr)
+		}
+	})
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatalf("could not load OpenAPI document: %v", err)
+	}
+
+	routes := []string{"GET /lists", "GET /lists/{encodedName}"}
+	if got := doc.Routes("lists"); !cmp.Equal(got, routes) {
+		t.Errorf("wrong routes in document: got %v; expected %v", got, routes)
+	}
+
+	schemas := map[string]interface{}{
+		"BestSellerListsResponse": BestSellerListsResponse{},
+		"BestSellerListResponse":  BestSellerListResponse{},
+		"BestSellerList":          books.BestSellerList{},
+		"BestSellerBook":          books.BestSellerBook{},
+		"ErrorResponse":           ErrorResponse{},
+		"ErrorInfo":               ErrorInfo{},
+	}
+	for name, v := range schemas {
+		if err := doc.CheckType(name, v); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// active_weeks can only be specified with active
+	bases := map[string]openapi.Request{
+		"GET /lists": {Query: map[string]string{"active": "true"}},
+	}
+	list := books.BestSellerList{EncodedName: "hardcover-fiction", DisplayName: "Hardcover Fiction", UpdatePeriod: "WEEKLY"}
+	for _, route := range routes {
+		t.Run(route, func(t *testing.T) {
+			op, ok := doc.Operation(route)
+			if !ok {
+				t.Fatalf("missing operation %s", route)
+			}
+			for _, name := range op.ResponseSchemas() {
+				if _, ok := schemas[name]; !ok {
+					t.Errorf("response schema %s isn't checked against a type", name)
+				}
+			}
+
+			errs := op.CheckParameters(bases[route], func(r openapi.Request) ([]string, error) {
+				m := &mockDynamoDBNewScanPaginatorAPIProvider{t, &stubDynamoDBScanPaginatorAPI{np: 1, pages: [][]books.BestSellerList{{list}}}}
+				h := New(Config{
+					ScanAPI:          &dummyScanAPIClient{},
+					NewScanPaginator: m.mockDynamoDBNewScanPaginatorAPI,
+					TableName:        TableName,
+					GetItemAPI:       &stubDynamoDBGetItemAPI{items: map[string]books.BestSellerList{list.EncodedName: list}},
+					QueryAPI:         &dummyQueryAPIClient{},
+					NewQueryPaginator: func(client dynamodb.QueryAPIClient, params *dynamodb.QueryInput, optFns ...func(*dynamodb.QueryPaginatorOptions)) DynamoDBQueryPaginatorAPI {
+						return &stubDynamoDBQueryPaginatorAPI{}
+					},
+					BooksTableName: "Books",
+				})
+				res, err := h.Route(events.APIGatewayV2HTTPRequest{
+					RouteKey:              route,
+					QueryStringParameters: r.Query,
+					PathParameters:        r.Path,
+					Headers:               r.Headers,
+				})
+				if err != nil || res.StatusCode < 400 {
+					return nil, err
+				}
+				var body ErrorResponse
+				if err := json.Unmarshal([]byte(res.Body), &body); err != nil {
+					return nil, err
+				}
+				var fields []string
+				for _, e := range body.Errors {
+					fields = append(fields, e.Field)
+				}
+				return fields, nil
+			})
+			for _, err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
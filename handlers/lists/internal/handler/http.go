@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"bookoftheday/httpcache"
+	"bookoftheday/types"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ErrorResponse contains the errors in a request that GetBestSellerListsHTTP couldn't serve.
+type ErrorResponse struct {
+	Errors []ErrorInfo `json:"errors"`
+}
+
+// ErrorInfo contains information about errors in a request that resulted in an invalid response.
+type ErrorInfo struct {
+	Field    string `json:"field,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Location string `json:"location"`
+}
+
+// defaultActiveWeeks is how many weeks ago a list must have last been published to
+// be active, when the request doesn't say. It's long enough for monthly lists.
+const defaultActiveWeeks = 6
+
+const dateLayout = "2006-01-02"
+
+type requestParams struct {
+	updated     *string
+	active      *bool
+	activeWeeks int
+	sort        *string
+}
+
+// Route calls the handler method for the route of req.
+func (h *Handler) Route(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	switch req.RouteKey {
+	case "GET /lists":
+		return h.GetBestSellerListsHTTP(req)
+	case "GET /lists/{encodedName}":
+		return h.GetList(req)
+	}
+	return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusNotFound}, nil
+}
+
+// GetBestSellerListsHTTP returns the lists from GetBestSellerLists as an HTTP response,
+// filtered and sorted by the request's query parameters. Responses have caching headers,
+// or are 304 Not Modified if the request's conditions show that the client already has
+// them.
+func (h *Handler) GetBestSellerListsHTTP(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	input, errs := validateReq(req)
+	if len(errs) != 0 {
+		return response(http.StatusBadRequest, ErrorResponse{errs})
+	}
+
+	res, err := h.GetBestSellerLists()
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	// Lists are active relative to when they were refreshed, so that the response
+	// stays the same for as long as it's cached
+	refreshed := h.schedule.LastRun(h.now())
+	lists := []types.BestSellerList{}
+	for _, l := range res.Lists {
+		if input.updated != nil && !strings.EqualFold(l.UpdatePeriod, *input.updated) {
+			continue
+		}
+		if input.active != nil && isActive(l, refreshed, input.activeWeeks) != *input.active {
+			continue
+		}
+		lists = append(lists, l)
+	}
+
+	if input.sort != nil {
+		descending := *input.sort == "desc"
+		sort.SliceStable(lists, func(i, j int) bool {
+			a, b := strings.ToLower(lists[i].DisplayName), strings.ToLower(lists[j].DisplayName)
+			if descending {
+				return a > b
+			}
+			return a < b
+		})
+	}
+
+	res.Count = len(lists)
+	res.Lists = lists
+	httpRes, err := response(http.StatusOK, res)
+	return h.cached(h.schedule, req, httpRes, err)
+}
+
+// isActive reports whether list was last published within weeks before t.
+func isActive(list types.BestSellerList, t time.Time, weeks int) bool {
+	newest, err := time.Parse(dateLayout, list.NewestPublishedDate)
+	if err != nil {
+		return false
+	}
+	return !newest.Before(t.AddDate(0, 0, -7*weeks))
+}
+
+func validateReq(req events.APIGatewayV2HTTPRequest) (requestParams, []ErrorInfo) {
+	reqInput := requestParams{activeWeeks: defaultActiveWeeks}
+	var errors []ErrorInfo
+
+	if qUpdated, ok := req.QueryStringParameters["updated"]; ok {
+		if u := strings.ToUpper(qUpdated); u == "WEEKLY" || u == "MONTHLY" {
+			reqInput.updated = &u
+		} else {
+			errors = append(errors, ErrorInfo{"updated", "updated must be WEEKLY or MONTHLY", "query"})
+		}
+	}
+
+	if qActive, ok := req.QueryStringParameters["active"]; ok {
+		if active, err := strconv.ParseBool(qActive); err == nil {
+			reqInput.active = &active
+		} else {
+			errors = append(errors, ErrorInfo{"active", "active must be true or false", "query"})
+		}
+	}
+
+	if qWeeks, ok := req.QueryStringParameters["active_weeks"]; ok {
+		if weeks, err := strconv.Atoi(qWeeks); err == nil && weeks >= 1 {
+			reqInput.activeWeeks = weeks
+		} else {
+			errors = append(errors, ErrorInfo{"active_weeks", "active_weeks must be an integer >= 1", "query"})
+		}
+		if _, ok := req.QueryStringParameters["active"]; !ok {
+			errors = append(errors, ErrorInfo{"active_weeks", "active_weeks can only be specified with active", "query"})
+		}
+	}
+
+	if qSort, ok := req.QueryStringParameters["sort"]; ok {
+		if qSort == "asc" || qSort == "desc" {
+			reqInput.sort = &qSort
+		} else {
+			errors = append(errors, ErrorInfo{"sort", "sort must be asc or desc", "query"})
+		}
+	}
+
+	return reqInput, errors
+}
+
+func response(status int, body interface{}) (events.APIGatewayV2HTTPResponse, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		err = fmt.Errorf("error marshalling response body: %w", err)
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: status,
+		Headers: map[string]string{
+			"content-type": "application/json",
+		},
+		Body: string(b),
+	}, err
+}
+
+// cached adds caching headers for data that changes on schedule to a successful response,
+// or returns 304 Not Modified instead if the request's conditions show that the client
+// already has it.
+func (h *Handler) cached(schedule httpcache.Schedule, req events.APIGatewayV2HTTPRequest, res events.APIGatewayV2HTTPResponse, err error) (events.APIGatewayV2HTTPResponse, error) {
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+	if schedule.Apply(req.Headers, res.Headers, res.Body, h.now()) {
+		delete(res.Headers, "content-type")
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusNotModified, Headers: res.Headers}, nil
+	}
+	return res, nil
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"bookoftheday/httpcache"
+	"bookoftheday/types"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// BestSellerListResponse contains the response data from calling GetList successfully.
+type BestSellerListResponse struct {
+	Attribution string                 `json:"attribution"`
+	List        types.BestSellerList   `json:"list"`
+	Count       int                    `json:"count"`
+	Books       []types.BestSellerBook `json:"books"`
+}
+
+var listRegexp = regexp.MustCompile(`^[a-zA-Z]+(-[a-zA-Z]+)*$`)
+
+// defaultBooksLimit is the number of recent books in a list response when there is no
+// user-specified limit. maxBooksLimit is the most that can be requested, since books
+// are only kept for a month.
+const (
+	defaultBooksLimit int32 = 7
+	maxBooksLimit     int32 = 31
+)
+
+// listSchedule is when the response of GetList changes. A list gets a new book every
+// day at midnight UTC, which on Mondays is just after the lists are refreshed.
+var listSchedule = httpcache.Daily(0, 0, 30*time.Minute)
+
+// GetList returns the list named by the encodedName path parameter, with its most
+// recent books of the day.
+func (h *Handler) GetList(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	var errs []ErrorInfo
+	name := req.PathParameters["encodedName"]
+	if !listRegexp.MatchString(name) {
+		errs = append(errs, ErrorInfo{"encodedName", fmt.Sprintf("encodedName must be in the format %s", listRegexp.String()), "path"})
+	}
+
+	limit := defaultBooksLimit
+	if qLimit, ok := req.QueryStringParameters["limit"]; ok {
+		if iLimit, err := strconv.ParseInt(qLimit, 10, 32); err == nil && iLimit >= 1 && int32(iLimit) <= maxBooksLimit {
+			limit = int32(iLimit)
+		} else {
+			errs = append(errs, ErrorInfo{"limit", fmt.Sprintf("limit must be an integer from 1 to %d", maxBooksLimit), "query"})
+		}
+	}
+	if len(errs) != 0 {
+		return response(http.StatusBadRequest, ErrorResponse{errs})
+	}
+
+	out, err := h.getItem.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: &h.tableName,
+		Key: map[string]ddbtypes.AttributeValue{
+			"EncodedName": &ddbtypes.AttributeValueMemberS{Value: name},
+		},
+	})
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("could not get list %s: %w", name, err)
+	}
+	if out.Item == nil {
+		return response(http.StatusNotFound, ErrorResponse{[]ErrorInfo{{"encodedName", "list does not exist", "path"}}})
+	}
+
+	var list types.BestSellerList
+	if err := attributevalue.UnmarshalMap(out.Item, &list); err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("could not unmarshal list: %w", err)
+	}
+
+	books, err := h.getRecentBooks(context.TODO(), name, limit)
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	res, err := response(http.StatusOK, BestSellerListResponse{
+		Attribution: Attribution,
+		List:        list,
+		Count:       len(books),
+		Books:       books,
+	})
+	return h.cached(listSchedule, req, res, err)
+}
+
+// getRecentBooks returns up to limit of the most recent books of the day from list.
+func (h *Handler) getRecentBooks(ctx context.Context, list string, limit int32) ([]types.BestSellerBook, error) {
+	p := h.newQueryPaginator(h.queryClient, &dynamodb.QueryInput{
+		TableName:              &h.booksTableName,
+		KeyConditionExpression: aws.String("ListEncodedName = :list"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":list": &ddbtypes.AttributeValueMemberS{Value: list},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            &limit,
+	})
+
+	books := []types.BestSellerBook{}
+	for p.HasMorePages() && len(books) < int(limit) {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not query books of %s: %w", list, err)
+		}
+
+		var data []types.BestSellerBook
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &data); err != nil {
+			return nil, fmt.Errorf("could not unmarshal books: %w", err)
+		}
+		books = append(books, data...)
+	}
+	if len(books) > int(limit) {
+		books = books[:limit]
+	}
+	return books, nil
+}
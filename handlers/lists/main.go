@@ -24,7 +24,24 @@ func main() {
 	p := func(client dynamodb.ScanAPIClient, params *dynamodb.ScanInput, optFns ...func(*dynamodb.ScanPaginatorOptions)) handler.DynamoDBScanPaginatorAPI {
 		return dynamodb.NewScanPaginator(client, params, optFns...)
 	}
-	h := handler.New(ddbClient, p, os.Getenv("LISTS_TABLE_NAME"))
+	qp := func(client dynamodb.QueryAPIClient, params *dynamodb.QueryInput, optFns ...func(*dynamodb.QueryPaginatorOptions)) handler.DynamoDBQueryPaginatorAPI {
+		return dynamodb.NewQueryPaginator(client, params, optFns...)
+	}
+	h := handler.New(handler.Config{
+		ScanAPI:           ddbClient,
+		NewScanPaginator:  p,
+		TableName:         os.Getenv("LISTS_TABLE_NAME"),
+		GetItemAPI:        ddbClient,
+		QueryAPI:          ddbClient,
+		NewQueryPaginator: qp,
+		BooksTableName:    os.Getenv("BOOKS_TABLE_NAME"),
+	})
 
-	lambda.Start(h.GetBestSellerLists)
+	// The same function code serves the state machine and the HTTP API, which
+	// needs HTTP responses to set status codes and caching headers.
+	if os.Getenv("HTTP_API") == "true" {
+		lambda.Start(h.Route)
+	} else {
+		lambda.Start(h.GetBestSellerLists)
+	}
 }
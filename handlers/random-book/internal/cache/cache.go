@@ -0,0 +1,115 @@
+// Package cache provides a read-through cache of NYT Best-Seller list responses.
+//
+// A list request for a date returns the list published on the nearest date on or after
+// it. Lists are stored by their published date, and a cached list answers requests for
+// any date after its previous published date, up to its own published date. Those lists
+// never change once they're published, so they're never evicted.
+package cache
+
+import (
+	"bookoftheday/nyt"
+	"context"
+	"log"
+)
+
+// ListAPI gets a Best-Seller list published on a date from the NYT API.
+type ListAPI interface {
+	List(ctx context.Context, list, date string) (nyt.BestSellerBookList, error)
+}
+
+// OverviewAPI gets the top books of every list published on a date from the NYT API.
+type OverviewAPI interface {
+	Overview(ctx context.Context, publishedDate string) (nyt.Overview, error)
+}
+
+// BooksAPI combines the NYT API calls that Cache makes.
+type BooksAPI interface {
+	ListAPI
+	OverviewAPI
+}
+
+// Store persists list responses.
+type Store interface {
+	// Get returns the stored list that answers a request for list on date, if there is one.
+	Get(ctx context.Context, list, date string) (nyt.BestSellerBookList, bool, error)
+
+	// Put stores bl, a response for list.
+	Put(ctx context.Context, list string, bl nyt.BestSellerBookList) error
+
+	// GetPick returns the date recorded by PutPick for list on day, if there is one.
+	GetPick(ctx context.Context, list, day string) (string, bool, error)
+
+	// PutPick records that list's book was picked from its list on date on day.
+	PutPick(ctx context.Context, list, day, date string) error
+}
+
+// Cache reads lists from a Store before requesting them from the NYT API, and stores the
+// lists it requests. Overviews aren't cached.
+type Cache struct {
+	api   BooksAPI
+	store Store
+}
+
+// Config provides configuration options for a Cache.
+type Config struct {
+	API   BooksAPI
+	Store Store
+}
+
+// New creates a new Cache.
+func New(cfg Config) *Cache {
+	return &Cache{api: cfg.API, store: cfg.Store}
+}
+
+// List returns list on date from the store, or else from the API. Errors reading and
+// writing the store are logged, and don't prevent requesting the list from the API.
+func (c *Cache) List(ctx context.Context, list, date string) (nyt.BestSellerBookList, error) {
+	bl, ok, err := c.store.Get(ctx, list, date)
+	if err != nil {
+		log.Printf("could not read list %s on %s from cache: %v", list, date, err)
+	}
+	if ok {
+		return bl, nil
+	}
+
+	bl, err = c.api.List(ctx, list, date)
+	if err != nil {
+		return bl, err
+	}
+	// An empty list doesn't have the dates the store needs
+	if bl.PublishedDate != "" && len(bl.Books) != 0 {
+		if err := c.store.Put(ctx, list, bl); err != nil {
+			log.Printf("could not write list %s published on %s to cache: %v", list, bl.PublishedDate, err)
+		}
+	}
+	return bl, nil
+}
+
+// PickedDate returns the date recorded by SetPickedDate for list on day (yyyy-MM-dd), if
+// the list requested on that date is cached.
+func (c *Cache) PickedDate(ctx context.Context, list, day string) (string, bool, error) {
+	date, ok, err := c.store.GetPick(ctx, list, day)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	if _, ok, err = c.store.Get(ctx, list, date); err != nil || !ok {
+		return "", false, err
+	}
+	return date, true, nil
+}
+
+// SetPickedDate records that list's book was picked on day (yyyy-MM-dd) from the list
+// requested on date.
+func (c *Cache) SetPickedDate(ctx context.Context, list, day, date string) error {
+	return c.store.PutPick(ctx, list, day, date)
+}
+
+// Overview returns the overview of publishedDate from the API.
+func (c *Cache) Overview(ctx context.Context, publishedDate string) (nyt.Overview, error) {
+	return c.api.Overview(ctx, publishedDate)
+}
+
+// answers reports whether bl is the response to a request for its list on date.
+func answers(bl nyt.BestSellerBookList, date string) bool {
+	return date <= bl.PublishedDate && bl.PreviousPublishedDate < date
+}
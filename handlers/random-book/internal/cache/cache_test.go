@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"bookoftheday/nyt"
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// fakeBooksAPI returns the list published on the first of lists on or after the
+// requested date, like the NYT API.
+type fakeBooksAPI struct {
+	lists []nyt.BestSellerBookList
+	calls []string
+}
+
+func (f *fakeBooksAPI) List(ctx context.Context, list, date string) (nyt.BestSellerBookList, error) {
+	f.calls = append(f.calls, date)
+	for _, bl := range f.lists {
+		if date <= bl.PublishedDate {
+			return bl, nil
+		}
+	}
+	return nyt.BestSellerBookList{}, nyt.ErrNotFound
+}
+
+func (f *fakeBooksAPI) Overview(ctx context.Context, publishedDate string) (nyt.Overview, error) {
+	return nyt.Overview{PublishedDate: publishedDate}, nil
+}
+
+// weeklyLists are three consecutive weeks of a list.
+var weeklyLists = []nyt.BestSellerBookList{
+	{ListNameEncoded: "manga", PublishedDate: "2015-06-07", PreviousPublishedDate: "2015-05-31", Books: []nyt.BestSellerBook{{Title: "ONE"}}},
+	{ListNameEncoded: "manga", PublishedDate: "2015-06-14", PreviousPublishedDate: "2015-06-07", Books: []nyt.BestSellerBook{{Title: "TWO"}}},
+	{ListNameEncoded: "manga", PublishedDate: "2015-06-21", PreviousPublishedDate: "2015-06-14", Books: []nyt.BestSellerBook{{Title: "THREE"}}},
+}
+
+// testCache requests dates from a Cache with store, checking each response and whether
+// it was requested from the API.
+func testCache(t *testing.T, store Store) {
+	api := &fakeBooksAPI{lists: weeklyLists}
+	c := New(Config{API: api, Store: store})
+
+	testCases := []struct {
+		date      string
+		want      string
+		requested bool
+	}{
+		{"2015-06-14", "TWO", true},
+		{"2015-06-08", "TWO", false},
+		{"2015-06-14", "TWO", false},
+		// The cached list on 2015-06-14 doesn't answer dates before the week it covers
+		{"2015-06-03", "ONE", true},
+		{"2015-06-01", "ONE", false},
+		{"2015-06-15", "THREE", true},
+		{"2015-06-20", "THREE", false},
+	}
+	for _, tc := range testCases {
+		calls := len(api.calls)
+		bl, err := c.List(context.Background(), "manga", tc.date)
+		if err != nil {
+			t.Fatalf("%s: got err %v; expected nil", tc.date, err)
+		}
+		if bl.Books[0].Title != tc.want {
+			t.Errorf("%s: got list with %s; expected %s", tc.date, bl.Books[0].Title, tc.want)
+		}
+		if requested := len(api.calls) > calls; requested != tc.requested {
+			t.Errorf("%s: got requested %v; expected %v", tc.date, requested, tc.requested)
+		}
+	}
+
+	// Errors aren't cached
+	if _, err := c.List(context.Background(), "manga", "2015-06-22"); !errors.Is(err, nyt.ErrNotFound) {
+		t.Errorf("got err %v; expected ErrNotFound", err)
+	}
+	if _, ok, _ := store.Get(context.Background(), "manga", "2015-06-22"); ok {
+		t.Error("got a cached list after an error; expected none")
+	}
+
+	// Picked dates are only returned while their list is cached
+	if _, ok, err := c.PickedDate(context.Background(), "manga", "2022-06-15"); ok || err != nil {
+		t.Errorf("got picked date %v, %v before it was set; expected none", ok, err)
+	}
+	for _, date := range []string{"2015-06-08", "2015-06-22"} {
+		if err := c.SetPickedDate(context.Background(), "manga", "2022-06-15", date); err != nil {
+			t.Fatalf("SetPickedDate: got err %v; expected nil", err)
+		}
+		got, ok, err := c.PickedDate(context.Background(), "manga", "2022-06-15")
+		if err != nil {
+			t.Fatalf("PickedDate: got err %v; expected nil", err)
+		}
+		if cached := date == "2015-06-08"; ok != cached || (ok && got != date) {
+			t.Errorf("%s: got picked date %q, %v; expected it only if cached (%v)", date, got, ok, cached)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	testCache(t, NewFileStore(t.TempDir()))
+}
+
+// fakeDynamoDB is a table of list items with the keys ListEncodedName and PublishedDate.
+type fakeDynamoDB struct {
+	t     *testing.T
+	items []map[string]types.AttributeValue
+}
+
+func (f *fakeDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	if *params.KeyConditionExpression != "ListEncodedName = :list AND PublishedDate >= :date" || *params.Limit != 1 {
+		f.t.Errorf("got query %s with limit %d; expected the first list on or after :date", *params.KeyConditionExpression, *params.Limit)
+	}
+	list := params.ExpressionAttributeValues[":list"].(*types.AttributeValueMemberS).Value
+	date := params.ExpressionAttributeValues[":date"].(*types.AttributeValueMemberS).Value
+
+	out := &dynamodb.QueryOutput{}
+	for _, item := range f.items {
+		if item["ListEncodedName"].(*types.AttributeValueMemberS).Value == list &&
+			item["PublishedDate"].(*types.AttributeValueMemberS).Value >= date {
+			out.Items = append(out.Items, item)
+			break
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	list := params.Key["ListEncodedName"].(*types.AttributeValueMemberS).Value
+	date := params.Key["PublishedDate"].(*types.AttributeValueMemberS).Value
+	for _, item := range f.items {
+		if item["ListEncodedName"].(*types.AttributeValueMemberS).Value == list &&
+			item["PublishedDate"].(*types.AttributeValueMemberS).Value == date {
+			return &dynamodb.GetItemOutput{Item: item}, nil
+		}
+	}
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	// Replace the item with the same keys
+	for i, item := range f.items {
+		if cmp.Equal(item["ListEncodedName"], params.Item["ListEncodedName"], cmpopts.IgnoreUnexported(types.AttributeValueMemberS{})) &&
+			cmp.Equal(item["PublishedDate"], params.Item["PublishedDate"], cmpopts.IgnoreUnexported(types.AttributeValueMemberS{})) {
+			f.items = append(f.items[:i], f.items[i+1:]...)
+			break
+		}
+	}
+	f.items = append(f.items, params.Item)
+	sort.Slice(f.items, func(i, j int) bool {
+		return f.items[i]["PublishedDate"].(*types.AttributeValueMemberS).Value < f.items[j]["PublishedDate"].(*types.AttributeValueMemberS).Value
+	})
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestDynamoDBStore(t *testing.T) {
+	ddb := &fakeDynamoDB{t: t}
+	testCache(t, NewDynamoDBStore(ddb, "Table"))
+
+	bl, ok, err := NewDynamoDBStore(ddb, "Table").Get(context.Background(), "manga", "2015-06-10")
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v; expected a cached list", ok, err)
+	}
+	if diff := cmp.Diff(weeklyLists[1], bl); diff != "" {
+		t.Errorf("fields mismatch in cached list (-want +got):\n%s", diff)
+	}
+}
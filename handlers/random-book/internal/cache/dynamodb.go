@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"bookoftheday/nyt"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBAPI provides a unit-testable interface to the DynamoDB Query, GetItem and
+// PutItem APIs.
+type DynamoDBAPI interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// listItem is a list stored in DynamoDB. The list is stored as JSON, because only the
+// keys and previous published date are read by queries.
+type listItem struct {
+	ListEncodedName       string
+	PublishedDate         string
+	PreviousPublishedDate string
+	ListJSON              string
+}
+
+// pickItem is a picked date stored in DynamoDB. It's keyed by the list name with
+// pickPrefix and the day, so that it's never returned by a query for the list.
+type pickItem struct {
+	ListEncodedName string
+	PublishedDate   string
+	PickedDate      string
+}
+
+const pickPrefix = "picks/"
+
+// DynamoDBStore stores lists in a DynamoDB table, with the hash key ListEncodedName and
+// the range key PublishedDate.
+type DynamoDBStore struct {
+	ddb       DynamoDBAPI
+	tableName string
+}
+
+// NewDynamoDBStore creates a new DynamoDBStore.
+func NewDynamoDBStore(ddb DynamoDBAPI, tableName string) *DynamoDBStore {
+	return &DynamoDBStore{ddb, tableName}
+}
+
+// Get returns the first list published on or after date, if it was the next list
+// published after date.
+func (s *DynamoDBStore) Get(ctx context.Context, list, date string) (nyt.BestSellerBookList, bool, error) {
+	out, err := s.ddb.Query(ctx, &dynamodb.QueryInput{
+		TableName:              &s.tableName,
+		KeyConditionExpression: aws.String("ListEncodedName = :list AND PublishedDate >= :date"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":list": &types.AttributeValueMemberS{Value: list},
+			":date": &types.AttributeValueMemberS{Value: date},
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		return nyt.BestSellerBookList{}, false, fmt.Errorf("could not query lists: %w", err)
+	}
+	if len(out.Items) == 0 {
+		return nyt.BestSellerBookList{}, false, nil
+	}
+
+	var item listItem
+	if err := attributevalue.UnmarshalMap(out.Items[0], &item); err != nil {
+		return nyt.BestSellerBookList{}, false, fmt.Errorf("could not unmarshal list item: %w", err)
+	}
+	var bl nyt.BestSellerBookList
+	if err := json.Unmarshal([]byte(item.ListJSON), &bl); err != nil {
+		return nyt.BestSellerBookList{}, false, fmt.Errorf("could not unmarshal list: %w", err)
+	}
+	return bl, answers(bl, date), nil
+}
+
+// Put stores bl.
+func (s *DynamoDBStore) Put(ctx context.Context, list string, bl nyt.BestSellerBookList) error {
+	data, err := json.Marshal(bl)
+	if err != nil {
+		return fmt.Errorf("could not marshal list: %w", err)
+	}
+	item, err := attributevalue.MarshalMap(listItem{
+		ListEncodedName:       list,
+		PublishedDate:         bl.PublishedDate,
+		PreviousPublishedDate: bl.PreviousPublishedDate,
+		ListJSON:              string(data),
+	})
+	if err != nil {
+		return fmt.Errorf("could not marshal list item: %w", err)
+	}
+
+	_, err = s.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &s.tableName,
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("could not put list item: %w", err)
+	}
+	return nil
+}
+
+// GetPick returns the date recorded by PutPick for list on day, if there is one.
+func (s *DynamoDBStore) GetPick(ctx context.Context, list, day string) (string, bool, error) {
+	out, err := s.ddb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &s.tableName,
+		Key: map[string]types.AttributeValue{
+			"ListEncodedName": &types.AttributeValueMemberS{Value: pickPrefix + list},
+			"PublishedDate":   &types.AttributeValueMemberS{Value: day},
+		},
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("could not get picked date: %w", err)
+	}
+	if out.Item == nil {
+		return "", false, nil
+	}
+
+	var item pickItem
+	if err := attributevalue.UnmarshalMap(out.Item, &item); err != nil {
+		return "", false, fmt.Errorf("could not unmarshal picked date: %w", err)
+	}
+	return item.PickedDate, true, nil
+}
+
+// PutPick records that list's book was picked from its list on date on day.
+func (s *DynamoDBStore) PutPick(ctx context.Context, list, day, date string) error {
+	item, err := attributevalue.MarshalMap(pickItem{
+		ListEncodedName: pickPrefix + list,
+		PublishedDate:   day,
+		PickedDate:      date,
+	})
+	if err != nil {
+		return fmt.Errorf("could not marshal picked date: %w", err)
+	}
+
+	_, err = s.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &s.tableName,
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("could not put picked date: %w", err)
+	}
+	return nil
+}
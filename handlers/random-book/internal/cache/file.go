@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"bookoftheday/nyt"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileStore stores lists as JSON files in a local directory, at <list>/<published date>.json,
+// for running the function locally and in tests. Picked dates are stored in
+// <list>/picks/<day>.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a new FileStore that stores lists in dir.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir}
+}
+
+// Get returns the first list published on or after date, if it was the next list
+// published after date.
+func (s *FileStore) Get(ctx context.Context, list, date string) (nyt.BestSellerBookList, bool, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, list))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nyt.BestSellerBookList{}, false, nil
+	}
+	if err != nil {
+		return nyt.BestSellerBookList{}, false, fmt.Errorf("could not read lists: %w", err)
+	}
+
+	var dates []string
+	for _, e := range entries {
+		if published := strings.TrimSuffix(e.Name(), ".json"); published != e.Name() {
+			dates = append(dates, published)
+		}
+	}
+	sort.Strings(dates)
+	i := sort.SearchStrings(dates, date)
+	if i == len(dates) {
+		return nyt.BestSellerBookList{}, false, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, list, dates[i]+".json"))
+	if err != nil {
+		return nyt.BestSellerBookList{}, false, fmt.Errorf("could not read list: %w", err)
+	}
+	var bl nyt.BestSellerBookList
+	if err := json.Unmarshal(data, &bl); err != nil {
+		return nyt.BestSellerBookList{}, false, fmt.Errorf("could not unmarshal list: %w", err)
+	}
+	return bl, answers(bl, date), nil
+}
+
+// Put stores bl.
+func (s *FileStore) Put(ctx context.Context, list string, bl nyt.BestSellerBookList) error {
+	data, err := json.Marshal(bl)
+	if err != nil {
+		return fmt.Errorf("could not marshal list: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, list), 0o755); err != nil {
+		return fmt.Errorf("could not create list directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, list, bl.PublishedDate+".json"), data, 0o644); err != nil {
+		return fmt.Errorf("could not write list: %w", err)
+	}
+	return nil
+}
+
+// GetPick returns the date recorded by PutPick for list on day, if there is one.
+func (s *FileStore) GetPick(ctx context.Context, list, day string) (string, bool, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, list, "picks", day))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("could not read picked date: %w", err)
+	}
+	return string(data), true, nil
+}
+
+// PutPick records that list's book was picked from its list on date on day.
+func (s *FileStore) PutPick(ctx context.Context, list, day, date string) error {
+	if err := os.MkdirAll(filepath.Join(s.dir, list, "picks"), 0o755); err != nil {
+		return fmt.Errorf("could not create picks directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, list, "picks", day), []byte(date), 0o644); err != nil {
+		return fmt.Errorf("could not write picked date: %w", err)
+	}
+	return nil
+}
@@ -2,13 +2,14 @@
 package handler
 
 import (
+	"bookoftheday/nyt"
 	books "bookoftheday/types"
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"math"
 	"math/rand"
-	"random-book/internal/api"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
@@ -19,7 +20,19 @@ import (
 // GetBooksInBestSellerListAPI allows querying the NYT API to get a list of best-selling books from
 // a Best-Seller List published on a certain date.
 type GetBooksInBestSellerListAPI interface {
-	GetBooksInListOnDate(list string, date string) (api.BestSellerBookList, error)
+	List(ctx context.Context, list, date string) (nyt.BestSellerBookList, error)
+}
+
+// GetOverviewAPI allows querying the NYT API to get the top books of every Best-Seller list
+// published on a certain date.
+type GetOverviewAPI interface {
+	Overview(ctx context.Context, publishedDate string) (nyt.Overview, error)
+}
+
+// BooksAPI combines the NYT API calls used by Handler.
+type BooksAPI interface {
+	GetBooksInBestSellerListAPI
+	GetOverviewAPI
 }
 
 // DynamoDBPutItemAPI provides a unit-testable interface to access the DynamoDB PutItem API.
@@ -27,78 +40,145 @@ type DynamoDBPutItemAPI interface {
 	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
 }
 
+// DynamoDBBatchWriteItemAPI provides a unit-testable interface to access the DynamoDB
+// BatchWriteItem API.
+type DynamoDBBatchWriteItemAPI interface {
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+}
+
+// DynamoDBAPI combines the DynamoDB APIs used by Handler.
+type DynamoDBAPI interface {
+	DynamoDBPutItemAPI
+	DynamoDBBatchWriteItemAPI
+}
+
 // Handler provides the Lambda implementation to get a random book given a Best-Seller list.
 type Handler struct {
-	api       GetBooksInBestSellerListAPI
-	ddb       DynamoDBPutItemAPI
+	api       BooksAPI
+	ddb       DynamoDBAPI
 	tableName string
 	rng       *rand.Rand
+
+	strategies Strategies
 }
 
 // New creates an instance of Handler.
-func New(api GetBooksInBestSellerListAPI, ddb DynamoDBPutItemAPI, tableName string, rng *rand.Rand) *Handler {
+func New(api BooksAPI, ddb DynamoDBAPI, tableName string, rng *rand.Rand, strategies Strategies) *Handler {
 	return &Handler{
 		api,
 		ddb,
 		tableName,
 		rng,
+		strategies,
 	}
 }
 
+// QuotaExhaustedError is returned when the NYT API key has no requests left today. The
+// Lambda runtime reports its type name as the error name, which the state machine matches
+// to stop picking books for the remaining lists.
+type QuotaExhaustedError struct {
+	Err error
+}
+
+func (e *QuotaExhaustedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *QuotaExhaustedError) Unwrap() error {
+	return e.Err
+}
+
 const ymdLayout = "2006-01-02"
 
-// getRandomDateBetween calculates a random yyyy-MM-dd date string between two dates with the same format.
-func getRandomDateBetween(rng *rand.Rand, oldest, newest string) (string, error) {
+// parseDateRange parses two yyyy-MM-dd dates, returning the oldest and the number of days
+// until the newest.
+func parseDateRange(oldest, newest string) (time.Time, int, error) {
 	o, err := time.Parse(ymdLayout, oldest)
 	if err != nil {
-		return "", fmt.Errorf("error parsing date to yyyy-MM-dd: %s", oldest)
+		return o, 0, fmt.Errorf("error parsing date to yyyy-MM-dd: %s", oldest)
 	}
 	n, err := time.Parse(ymdLayout, newest)
 	if err != nil {
-		return "", fmt.Errorf("error parsing date to yyyy-MM-dd: %s", newest)
+		return o, 0, fmt.Errorf("error parsing date to yyyy-MM-dd: %s", newest)
+	}
+	return o, int(math.Floor(n.Sub(o).Hours() / 24)), nil
+}
+
+// getRandomDateBetween calculates a random yyyy-MM-dd date string between two dates with the same format.
+func getRandomDateBetween(rng *rand.Rand, oldest, newest string) (string, error) {
+	o, days, err := parseDateRange(oldest, newest)
+	if err != nil {
+		return "", err
+	}
+	if days <= 0 {
+		return oldest, nil
 	}
 
-	days := int(math.Floor(n.Sub(o).Hours() / 24))
 	rd := rng.Intn(days)
 	date := o.Add(time.Duration(rd) * time.Hour * 24).Format(ymdLayout)
 	return date, nil
 }
 
-// GetRandomBestSellerBook finds and persists a random book from a given Best-Seller List.
+// PickedDates is implemented by a BooksAPI that caches lists, and remembers the date
+// that each list's book was picked from on a day.
+type PickedDates interface {
+	// PickedDate returns the date set for list on day, if the list on that date is cached.
+	PickedDate(ctx context.Context, list, day string) (string, bool, error)
+
+	// SetPickedDate sets the date that list's book was picked from on day.
+	SetPickedDate(ctx context.Context, list, day, date string) error
+}
+
+// pickDate returns the date of the list to pick a book from. When an earlier attempt
+// today already got its list, a retry reuses that date, so that it's read from the cache
+// instead of using API quota. Otherwise, such as after the request for that date failed,
+// the date is drawn by st from h.rng.
+func (h *Handler) pickDate(ctx context.Context, st Strategy, list books.BestSellerList, day string) (string, error) {
+	if pd, ok := h.api.(PickedDates); ok {
+		date, ok, err := pd.PickedDate(ctx, list.EncodedName, day)
+		if err != nil {
+			log.Printf("could not read picked date of %s: %v", list.EncodedName, err)
+		}
+		if ok {
+			return date, nil
+		}
+	}
+	return st.Date(h.rng, list.OldestPublishedDate, list.NewestPublishedDate)
+}
+
+// GetRandomBestSellerBook finds and persists a random book from a given Best-Seller List,
+// selected by the list's Strategy. Retries on the same day reuse the date of a list that
+// was already requested successfully.
 func (h *Handler) GetRandomBestSellerBook(list books.BestSellerList) (books.BestSellerBook, error) {
-	date, err := getRandomDateBetween(h.rng, list.OldestPublishedDate, list.NewestPublishedDate)
+	ctx := context.TODO()
+	day := time.Now().UTC().Format(ymdLayout)
+	st := h.strategies.For(list.EncodedName)
+	date, err := h.pickDate(ctx, st, list, day)
 	if err != nil {
 		return books.BestSellerBook{}, err
 	}
 
-	bl, err := h.api.GetBooksInListOnDate(list.EncodedName, date)
+	bl, err := h.api.List(ctx, list.EncodedName, date)
+	if errors.Is(err, nyt.ErrQuotaExhausted) {
+		return books.BestSellerBook{}, &QuotaExhaustedError{err}
+	}
 	if err != nil {
 		return books.BestSellerBook{}, err
 	}
 	if len(bl.Books) == 0 {
 		return books.BestSellerBook{}, errors.New("books API returned empty list")
 	}
+	if pd, ok := h.api.(PickedDates); ok {
+		if err := pd.SetPickedDate(ctx, list.EncodedName, day, date); err != nil {
+			log.Printf("could not set picked date of %s: %v", list.EncodedName, err)
+		}
+	}
 
-	b := bl.Books[h.rng.Intn(len(bl.Books))]
-	bsb := books.BestSellerBook{
-		ListEncodedName:   list.EncodedName,
-		DateSelected:      time.Now().Format(ymdLayout),
-		ListPublishedDate: bl.PublishedDate,
-		ListDisplayName:   bl.DisplayName,
-		ListUpdatePeriod:  bl.Updated,
-		PrimaryISBN10:     b.PrimaryISBN10,
-		PrimaryISBN13:     b.PrimaryISBN13,
-		Title:             b.Title,
-		Author:            b.Author,
-		Publisher:         b.Publisher,
-		Description:       b.Description,
-		Rank:              b.Rank,
-		AmazonProductURL:  b.AmazonProductURL,
-		ImageURL:          b.ImageURL,
-		ImageWidth:        b.ImageWidth,
-		ImageHeight:       b.ImageHeight,
-		Expiration:        time.Now().AddDate(0, 1, 0).Unix(),
+	i, err := st.Book(context.TODO(), h.rng, bl.Books)
+	if err != nil {
+		return books.BestSellerBook{}, err
 	}
+	bsb := newBook(list.EncodedName, bl.PublishedDate, bl.DisplayName, bl.Updated, bl.Books[i])
 
 	item, err := attributevalue.MarshalMap(bsb)
 	if err != nil {
@@ -114,3 +194,29 @@ func (h *Handler) GetRandomBestSellerBook(list books.BestSellerList) (books.Best
 
 	return bsb, err
 }
+
+// newBook creates the BestSellerBook that's persisted for b, selected today from a list
+// published on publishedDate.
+func newBook(listEncodedName, publishedDate, displayName, updated string, b nyt.BestSellerBook) books.BestSellerBook {
+	return books.BestSellerBook{
+		ListEncodedName:   listEncodedName,
+		DateSelected:      time.Now().Format(ymdLayout),
+		ListPublishedDate: publishedDate,
+		ListDisplayName:   displayName,
+		ListUpdatePeriod:  updated,
+		PrimaryISBN10:     b.PrimaryISBN10,
+		PrimaryISBN13:     b.PrimaryISBN13,
+		Title:             b.Title,
+		Author:            b.Author,
+		Publisher:         b.Publisher,
+		Description:       b.Description,
+		Rank:              b.Rank,
+		AmazonProductURL:  b.AmazonProductURL,
+		ImageURL:          b.ImageURL,
+		ImageWidth:        b.ImageWidth,
+		ImageHeight:       b.ImageHeight,
+		TitleKey:          books.SearchKey(b.Title),
+		AuthorKey:         books.SearchKey(b.Author),
+		Expiration:        time.Now().AddDate(0, 1, 0).Unix(),
+	}
+}
@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"bookoftheday/nyt"
 	books "bookoftheday/types"
 	"context"
+	"errors"
+	"fmt"
 	"math/rand"
-	"random-book/internal/api"
+	"random-book/internal/cache"
 	"regexp"
 	"testing"
 	"time"
@@ -20,14 +23,14 @@ import (
 
 type mockGetBooksInBestSellerListAPI struct {
 	*testing.T
-	books      api.BestSellerBookList
+	books      nyt.BestSellerBookList
 	list       string
 	oldestDate string
 	newestDate string
 	err        error
 }
 
-func (m *mockGetBooksInBestSellerListAPI) GetBooksInListOnDate(list string, date string) (api.BestSellerBookList, error) {
+func (m *mockGetBooksInBestSellerListAPI) List(ctx context.Context, list, date string) (nyt.BestSellerBookList, error) {
 	if list != m.list {
 		m.Errorf("incorrect list name: got %s; expected %s", list, m.list)
 	}
@@ -42,6 +45,52 @@ func (m *mockGetBooksInBestSellerListAPI) GetBooksInListOnDate(list string, date
 	return m.books, m.err
 }
 
+func (m *mockGetBooksInBestSellerListAPI) Overview(ctx context.Context, publishedDate string) (nyt.Overview, error) {
+	m.Error("unexpected call to Overview")
+	return nyt.Overview{}, nil
+}
+
+// countingListAPI records the dates of list requests, and returns a list published on
+// the requested date, or else the next of errs.
+type countingListAPI struct {
+	dates []string
+	errs  []error
+}
+
+func (c *countingListAPI) List(ctx context.Context, list, date string) (nyt.BestSellerBookList, error) {
+	c.dates = append(c.dates, date)
+	if len(c.errs) != 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return nyt.BestSellerBookList{}, err
+	}
+	return nyt.BestSellerBookList{PublishedDate: date, Books: []nyt.BestSellerBook{{Title: "ONE"}, {Title: "TWO"}}}, nil
+}
+
+func (c *countingListAPI) Overview(ctx context.Context, publishedDate string) (nyt.Overview, error) {
+	return nyt.Overview{}, nil
+}
+
+type mockOverviewAPI struct {
+	*testing.T
+	overview   nyt.Overview
+	oldestDate string
+	newestDate string
+	err        error
+}
+
+func (m *mockOverviewAPI) List(ctx context.Context, list, date string) (nyt.BestSellerBookList, error) {
+	m.Error("unexpected call to List")
+	return nyt.BestSellerBookList{}, nil
+}
+
+func (m *mockOverviewAPI) Overview(ctx context.Context, publishedDate string) (nyt.Overview, error) {
+	if publishedDate < m.oldestDate || publishedDate > m.newestDate {
+		m.Errorf("date argument was not in range: got %s; expected value between %s and %s", publishedDate, m.oldestDate, m.newestDate)
+	}
+	return m.overview, m.err
+}
+
 type mockDynamoDBPutItemAPI struct {
 	*testing.T
 	input *dynamodb.PutItemInput
@@ -65,6 +114,75 @@ func (m *mockDynamoDBPutItemAPI) PutItem(ctx context.Context, params *dynamodb.P
 	return nil, m.err
 }
 
+func (m *mockDynamoDBPutItemAPI) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	m.Error("unexpected call to BatchWriteItem")
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+type mockDynamoDBAnyPutItemAPI struct{}
+
+func (m *mockDynamoDBAnyPutItemAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockDynamoDBAnyPutItemAPI) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+// mockDynamoDBQueryAPI counts the books with seen ISBNs as selected since 30 days ago.
+type mockDynamoDBQueryAPI struct {
+	*testing.T
+	seen map[string]bool
+}
+
+func (m *mockDynamoDBQueryAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	if *params.IndexName != "ISBNIndex" || params.Select != types.SelectCount {
+		m.Errorf("got query of index %s selecting %s; expected a count of ISBNIndex", *params.IndexName, params.Select)
+	}
+	if since := params.ExpressionAttributeValues[":since"].(*types.AttributeValueMemberS).Value; since != "2022-05-31" {
+		m.Errorf("got :since %s; expected 2022-05-31", since)
+	}
+	out := &dynamodb.QueryOutput{}
+	if m.seen[params.ExpressionAttributeValues[":isbn"].(*types.AttributeValueMemberS).Value] {
+		out.Count = 1
+	}
+	return out, nil
+}
+
+type mockDynamoDBBatchWriteItemAPI struct {
+	*testing.T
+	written []books.BestSellerBook
+
+	// unprocessed is the number of items of the first batch to leave unprocessed
+	unprocessed int
+}
+
+func (m *mockDynamoDBBatchWriteItemAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.Error("unexpected call to PutItem")
+	return nil, nil
+}
+
+func (m *mockDynamoDBBatchWriteItemAPI) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	reqs := params.RequestItems[TableName]
+	if len(reqs) == 0 || len(reqs) > 25 {
+		m.Fatalf("got %d items for table %s; expected between 1 and 25", len(reqs), TableName)
+	}
+	out := &dynamodb.BatchWriteItemOutput{}
+	if m.unprocessed != 0 {
+		out.UnprocessedItems = map[string][]types.WriteRequest{TableName: reqs[:m.unprocessed]}
+		reqs = reqs[m.unprocessed:]
+		m.unprocessed = 0
+	}
+	for _, req := range reqs {
+		var b books.BestSellerBook
+		if err := attributevalue.UnmarshalMap(req.PutRequest.Item, &b); err != nil {
+			m.Fatal(err)
+		}
+		m.written = append(m.written, b)
+	}
+	return out, nil
+}
+
 const TableName = "Table"
 
 func TestHandler(t *testing.T) {
@@ -73,9 +191,9 @@ func TestHandler(t *testing.T) {
 		newest := "2020-12-31"
 		list := "hardcover-nonfiction"
 		f := fuzz.New()
-		var bl api.BestSellerBookList
+		var bl nyt.BestSellerBookList
 		f.SkipFieldsWithPattern(regexp.MustCompile("Books")).Fuzz(&bl)
-		bl.Books = make([]api.BestSellerBook, 1)
+		bl.Books = make([]nyt.BestSellerBook, 1)
 		f.Fuzz(&bl.Books[0])
 
 		mAPI := &mockGetBooksInBestSellerListAPI{t, bl, list, oldest, newest, nil}
@@ -98,6 +216,8 @@ func TestHandler(t *testing.T) {
 			ImageURL:          b.ImageURL,
 			ImageWidth:        b.ImageWidth,
 			ImageHeight:       b.ImageHeight,
+			TitleKey:          books.SearchKey(b.Title),
+			AuthorKey:         books.SearchKey(b.Author),
 		}
 		item, _ := attributevalue.MarshalMap(book)
 		input := &dynamodb.PutItemInput{
@@ -106,7 +226,7 @@ func TestHandler(t *testing.T) {
 		}
 		mDDB := &mockDynamoDBPutItemAPI{t, input, nil}
 
-		h := New(mAPI, mDDB, TableName, rand.New(rand.NewSource(1)))
+		h := New(mAPI, mDDB, TableName, rand.New(rand.NewSource(1)), Strategies{})
 		now := time.Now().Unix()
 		got, err := h.GetRandomBestSellerBook(books.BestSellerList{
 			EncodedName:         list,
@@ -126,4 +246,302 @@ func TestHandler(t *testing.T) {
 			t.Errorf("invalid expiration: got %d; expected value greater than %d", got.Expiration, now)
 		}
 	})
+
+	t.Run("retries read the same date's list from the cache", func(t *testing.T) {
+		api := &countingListAPI{}
+		c := cache.New(cache.Config{API: api, Store: cache.NewFileStore(t.TempDir())})
+		list := books.BestSellerList{EncodedName: "manga", OldestPublishedDate: "2010-01-01", NewestPublishedDate: "2020-12-31"}
+
+		// Each attempt can run in a new Lambda instance with its own random source
+		for seed := int64(1); seed <= 3; seed++ {
+			h := New(c, &mockDynamoDBAnyPutItemAPI{}, TableName, rand.New(rand.NewSource(seed)), Strategies{})
+			if _, err := h.GetRandomBestSellerBook(list); err != nil {
+				t.Fatalf("attempt %d: got err %v; expected nil", seed, err)
+			}
+		}
+		if len(api.dates) != 1 {
+			t.Errorf("got API requests for %v; expected one request", api.dates)
+		}
+	})
+
+	t.Run("retries after a failed request draw a new date", func(t *testing.T) {
+		api := &countingListAPI{errs: []error{nyt.ErrNotFound}}
+		c := cache.New(cache.Config{API: api, Store: cache.NewFileStore(t.TempDir())})
+		list := books.BestSellerList{EncodedName: "manga", OldestPublishedDate: "2010-01-01", NewestPublishedDate: "2020-12-31"}
+		h := New(c, &mockDynamoDBAnyPutItemAPI{}, TableName, rand.New(rand.NewSource(1)), Strategies{})
+
+		if _, err := h.GetRandomBestSellerBook(list); !errors.Is(err, nyt.ErrNotFound) {
+			t.Fatalf("first attempt: got err %v; expected ErrNotFound", err)
+		}
+		if _, err := h.GetRandomBestSellerBook(list); err != nil {
+			t.Fatalf("retry: got err %v; expected nil", err)
+		}
+		if len(api.dates) != 2 || api.dates[0] == api.dates[1] {
+			t.Errorf("got API requests for %v; expected two different dates", api.dates)
+		}
+	})
+
+	t.Run("returns QuotaExhaustedError when the API quota is used up", func(t *testing.T) {
+		apiErr := fmt.Errorf("could not GET NYT Books API: %w", nyt.ErrQuotaExhausted)
+		mAPI := &mockGetBooksInBestSellerListAPI{t, nyt.BestSellerBookList{}, "manga", "2010-01-01", "2020-12-31", apiErr}
+		h := New(mAPI, &mockDynamoDBPutItemAPI{T: t}, TableName, rand.New(rand.NewSource(1)), Strategies{})
+
+		_, err := h.GetRandomBestSellerBook(books.BestSellerList{
+			EncodedName:         "manga",
+			OldestPublishedDate: "2010-01-01",
+			NewestPublishedDate: "2020-12-31",
+		})
+
+		var quotaErr *QuotaExhaustedError
+		if !errors.As(err, &quotaErr) || !errors.Is(err, nyt.ErrQuotaExhausted) {
+			t.Errorf("got error %v; expected a *QuotaExhaustedError", err)
+		}
+	})
+}
+
+func TestOverview(t *testing.T) {
+	lists := []books.BestSellerList{
+		{EncodedName: "hardcover-fiction", OldestPublishedDate: "2008-06-08", NewestPublishedDate: "2022-06-26", UpdatePeriod: "WEEKLY"},
+		{EncodedName: "manga", OldestPublishedDate: "2009-03-15", NewestPublishedDate: "2017-01-29", UpdatePeriod: "WEEKLY"},
+		{EncodedName: "business-books", OldestPublishedDate: "2013-11-03", NewestPublishedDate: "2022-06-12", UpdatePeriod: "MONTHLY"},
+		{EncodedName: "paperback-nonfiction", OldestPublishedDate: "2008-06-08", NewestPublishedDate: "2022-06-26", UpdatePeriod: "WEEKLY"},
+	}
+	overview := nyt.Overview{
+		PublishedDate: "2015-06-14",
+		Lists: []nyt.OverviewList{
+			{ListNameEncoded: "hardcover-fiction", DisplayName: "Hardcover Fiction", Updated: "WEEKLY", Books: []nyt.BestSellerBook{
+				{Title: "THE GIRL ON THE TRAIN", Author: "Paula Hawkins", Rank: 1},
+			}},
+			{ListNameEncoded: "paperback-nonfiction", DisplayName: "Paperback Nonfiction", Updated: "WEEKLY"},
+			{ListNameEncoded: "manga", DisplayName: "Manga", Updated: "WEEKLY", Books: []nyt.BestSellerBook{
+				{Title: "ATTACK ON TITAN, VOL. 15", Author: "Hajime Isayama", Rank: 1},
+				{Title: "NARUTO, VOL. 70", Author: "Masashi Kishimoto", Rank: 2},
+			}},
+			{ListNameEncoded: "young-adult", DisplayName: "Young Adult", Updated: "WEEKLY", Books: []nyt.BestSellerBook{
+				{Title: "PAPER TOWNS", Author: "John Green", Rank: 1},
+			}},
+		},
+	}
+
+	t.Run("picks a book from each input list in the overview", func(t *testing.T) {
+		mAPI := &mockOverviewAPI{t, overview, "2008-06-08", "2022-06-26", nil}
+		mDDB := &mockDynamoDBBatchWriteItemAPI{T: t}
+		h := New(mAPI, mDDB, TableName, rand.New(rand.NewSource(1)), Strategies{})
+
+		got, err := h.GetRandomOverviewBooks(OverviewInput{lists})
+		if err != nil {
+			t.Fatalf("handler returned unexpected error: got %v; expected %v", err, nil)
+		}
+
+		if len(got) != len(lists) {
+			t.Fatalf("got %d lists; expected %d", len(got), len(lists))
+		}
+		for i, lb := range got {
+			if lb.BestSellerList != lists[i] {
+				t.Errorf("got list %+v; expected %+v", lb.BestSellerList, lists[i])
+			}
+			if hasBook := lb.Book != nil; hasBook != (i < 2) {
+				t.Errorf("list %s: got book %+v; expected one %v", lists[i].EncodedName, lb.Book, i < 2)
+			}
+		}
+
+		b := got[0].Book
+		want := books.BestSellerBook{
+			ListEncodedName:   "hardcover-fiction",
+			DateSelected:      time.Now().Format(ymdLayout),
+			ListPublishedDate: "2015-06-14",
+			ListDisplayName:   "Hardcover Fiction",
+			ListUpdatePeriod:  "WEEKLY",
+			Title:             "THE GIRL ON THE TRAIN",
+			Author:            "Paula Hawkins",
+			Rank:              1,
+			TitleKey:          books.SearchKey("THE GIRL ON THE TRAIN"),
+			AuthorKey:         books.SearchKey("Paula Hawkins"),
+		}
+		if diff := cmp.Diff(want, *b, cmpopts.IgnoreFields(books.BestSellerBook{}, "Expiration")); diff != "" {
+			t.Errorf("fields mismatch in returned book (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]books.BestSellerBook{*got[0].Book, *got[1].Book}, mDDB.written); diff != "" {
+			t.Errorf("fields mismatch in written books (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("leaves lists with their own strategy for GetRandomBestSellerBook", func(t *testing.T) {
+		mAPI := &mockOverviewAPI{t, overview, "2008-06-08", "2022-06-26", nil}
+		mDDB := &mockDynamoDBBatchWriteItemAPI{T: t}
+		strategies := Strategies{Lists: map[string]Strategy{"manga": RecentStrategy{}}}
+		h := New(mAPI, mDDB, TableName, rand.New(rand.NewSource(1)), strategies)
+
+		got, err := h.GetRandomOverviewBooks(OverviewInput{lists})
+		if err != nil {
+			t.Fatalf("handler returned unexpected error: got %v; expected %v", err, nil)
+		}
+		if got[0].Book == nil || got[1].Book != nil {
+			t.Errorf("got books %+v and %+v; expected only a hardcover-fiction book", got[0].Book, got[1].Book)
+		}
+		if len(mDDB.written) != 1 {
+			t.Errorf("got %d written books; expected 1", len(mDDB.written))
+		}
+	})
+
+	t.Run("leaves unprocessed books for GetRandomBestSellerBook", func(t *testing.T) {
+		mAPI := &mockOverviewAPI{t, overview, "2008-06-08", "2022-06-26", nil}
+		mDDB := &mockDynamoDBBatchWriteItemAPI{T: t, unprocessed: 1}
+		h := New(mAPI, mDDB, TableName, rand.New(rand.NewSource(1)), Strategies{})
+
+		got, err := h.GetRandomOverviewBooks(OverviewInput{lists})
+		if err != nil {
+			t.Fatalf("handler returned unexpected error: got %v; expected %v", err, nil)
+		}
+		// The first batch's unprocessed item is written on the retry
+		if got[0].Book == nil || got[1].Book == nil || len(mDDB.written) != 2 {
+			t.Errorf("got %+v and written %+v; expected both books", got, mDDB.written)
+		}
+	})
+
+	t.Run("returns every list without books when none are weekly", func(t *testing.T) {
+		h := New(&mockOverviewAPI{T: t}, &mockDynamoDBBatchWriteItemAPI{T: t}, TableName, rand.New(rand.NewSource(1)), Strategies{})
+
+		got, err := h.GetRandomOverviewBooks(OverviewInput{lists[2:3]})
+		if err != nil || len(got) != 1 || got[0].Book != nil {
+			t.Errorf("got %+v, %v; expected the list without a book", got, err)
+		}
+	})
+
+	t.Run("returns QuotaExhaustedError when the API quota is used up", func(t *testing.T) {
+		apiErr := fmt.Errorf("could not GET NYT Books API: %w", nyt.ErrQuotaExhausted)
+		mAPI := &mockOverviewAPI{t, nyt.Overview{}, "2008-06-08", "2022-06-26", apiErr}
+		h := New(mAPI, &mockDynamoDBBatchWriteItemAPI{T: t}, TableName, rand.New(rand.NewSource(1)), Strategies{})
+
+		_, err := h.GetRandomOverviewBooks(OverviewInput{lists})
+		var quotaErr *QuotaExhaustedError
+		if !errors.As(err, &quotaErr) {
+			t.Errorf("got error %v; expected a *QuotaExhaustedError", err)
+		}
+	})
+}
+
+// countPicks returns how many times strategy picks each book from bs in n draws.
+func countPicks(t *testing.T, st Strategy, bs []nyt.BestSellerBook, n int) []int {
+	rng := rand.New(rand.NewSource(1))
+	counts := make([]int, len(bs))
+	for i := 0; i < n; i++ {
+		j, err := st.Book(context.Background(), rng, bs)
+		if err != nil {
+			t.Fatalf("got err %v; expected nil", err)
+		}
+		counts[j]++
+	}
+	return counts
+}
+
+func TestStrategies(t *testing.T) {
+	t.Run("rank favors higher ranks", func(t *testing.T) {
+		bs := make([]nyt.BestSellerBook, 10)
+		for i := range bs {
+			bs[i].Rank = i + 1
+		}
+		counts := countPicks(t, RankStrategy{}, bs, 10000)
+		// Rank 1 has weight 10 and rank 10 has weight 1
+		if counts[0] < 5*counts[9] || counts[0] < counts[4] || counts[4] < counts[9] {
+			t.Errorf("got counts %v; expected them to decrease with rank", counts)
+		}
+	})
+
+	t.Run("weeks-on-list favors books on the list longer", func(t *testing.T) {
+		bs := []nyt.BestSellerBook{{WeeksOnList: 0}, {WeeksOnList: 9}, {WeeksOnList: 4}}
+		counts := countPicks(t, WeeksOnListStrategy{}, bs, 10000)
+		// The weights are 1, 10 and 5
+		if counts[0] == 0 || counts[1] < 5*counts[0] || counts[2] < 3*counts[0] || counts[1] < counts[2] {
+			t.Errorf("got counts %v; expected them to increase with weeks on list", counts)
+		}
+	})
+
+	t.Run("recent favors newer dates", func(t *testing.T) {
+		rng := rand.New(rand.NewSource(1))
+		decades := make([]int, 3)
+		for i := 0; i < 10000; i++ {
+			date, err := RecentStrategy{}.Date(rng, "1990-01-01", "2020-01-01")
+			if err != nil {
+				t.Fatalf("got err %v; expected nil", err)
+			}
+			d, _ := time.Parse(ymdLayout, date)
+			if d.Year() < 1990 || d.Year() >= 2020 {
+				t.Fatalf("got date %s; expected it between 1990-01-01 and 2020-01-01", date)
+			}
+			decades[(d.Year()-1990)/10]++
+		}
+		// The decades are 1, 3 and 5 ninths of the dates
+		if decades[1] < 2*decades[0] || decades[2] < 4*decades[0] {
+			t.Errorf("got dates per decade %v; expected about 1:3:5", decades)
+		}
+	})
+
+	t.Run("unseen skips recently selected books", func(t *testing.T) {
+		seen := map[string]bool{"9780000000001": true, "9780000000003": true}
+		mDDB := &mockDynamoDBQueryAPI{t, seen}
+		st := NewUnseenStrategy(mDDB, TableName, 30)
+		st.now = func() time.Time { return time.Date(2022, 6, 30, 0, 0, 0, 0, time.UTC) }
+
+		bs := []nyt.BestSellerBook{{PrimaryISBN13: "9780000000001"}, {PrimaryISBN13: "9780000000002"}, {PrimaryISBN13: "9780000000003"}, {}}
+		counts := countPicks(t, st, bs, 100)
+		if counts[0] != 0 || counts[2] != 0 || counts[1] == 0 || counts[3] == 0 {
+			t.Errorf("got counts %v; expected only the unseen books", counts)
+		}
+
+		// When every book was seen, any can be picked
+		counts = countPicks(t, st, bs[:1], 10)
+		if counts[0] != 10 {
+			t.Errorf("got counts %v; expected the only book", counts)
+		}
+	})
+
+	t.Run("GetRandomBestSellerBook uses the list's strategy", func(t *testing.T) {
+		bl := nyt.BestSellerBookList{Books: []nyt.BestSellerBook{
+			{Title: "FIRST", Rank: 1, WeeksOnList: 0},
+			{Title: "SECOND", Rank: 2, WeeksOnList: 1000},
+		}}
+		mAPI := &mockGetBooksInBestSellerListAPI{t, bl, "manga", "2010-01-01", "2020-12-31", nil}
+		strategies := Strategies{Default: RankStrategy{}, Lists: map[string]Strategy{"manga": WeeksOnListStrategy{}}}
+		h := New(mAPI, &mockDynamoDBAnyPutItemAPI{}, TableName, rand.New(rand.NewSource(1)), strategies)
+
+		got, err := h.GetRandomBestSellerBook(books.BestSellerList{
+			EncodedName:         "manga",
+			OldestPublishedDate: "2010-01-01",
+			NewestPublishedDate: "2020-12-31",
+		})
+		if err != nil || got.Title != "SECOND" {
+			t.Errorf("got %s, %v; expected the book with the most weeks on the list", got.Title, err)
+		}
+	})
+}
+
+func TestParseStrategies(t *testing.T) {
+	byName := StrategiesByName(nil, TableName)
+
+	s, err := ParseStrategies("manga=rank, *=recent,hardcover-fiction=weeks-on-list", byName)
+	if err != nil {
+		t.Fatalf("got err %v; expected nil", err)
+	}
+	if _, ok := s.For("manga").(RankStrategy); !ok {
+		t.Errorf("got %T for manga; expected RankStrategy", s.For("manga"))
+	}
+	if _, ok := s.For("hardcover-fiction").(WeeksOnListStrategy); !ok {
+		t.Errorf("got %T for hardcover-fiction; expected WeeksOnListStrategy", s.For("hardcover-fiction"))
+	}
+	if _, ok := s.For("young-adult").(RecentStrategy); !ok {
+		t.Errorf("got %T for young-adult; expected RecentStrategy", s.For("young-adult"))
+	}
+
+	if s, err := ParseStrategies("", byName); err != nil {
+		t.Errorf("got err %v; expected nil", err)
+	} else if _, ok := s.For("manga").(UniformStrategy); !ok {
+		t.Errorf("got %T for an empty spec; expected UniformStrategy", s.For("manga"))
+	}
+
+	for _, spec := range []string{"manga", "manga=popular", "=rank"} {
+		if _, err := ParseStrategies(spec, byName); err == nil {
+			t.Errorf("%s: got nil err; expected an error", spec)
+		}
+	}
 }
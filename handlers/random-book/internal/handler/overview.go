@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"bookoftheday/nyt"
+	books "bookoftheday/types"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// OverviewInput is the output of the GetLists Lambda.
+type OverviewInput struct {
+	Lists []books.BestSellerList `json:"lists"`
+}
+
+// ListBook is a Best-Seller list from the input of GetRandomOverviewBooks, with the book
+// that was picked from it if the list was in the overview.
+type ListBook struct {
+	books.BestSellerList
+	Book *books.BestSellerBook `json:"book,omitempty"`
+}
+
+// Number of attempts to write the books that a BatchWriteItem leaves unprocessed.
+const batchWriteAttempts = 4
+
+// GetRandomOverviewBooks gets the top books of every weekly list published on a random
+// date with a single NYT API call, and persists a random book for each of the input
+// lists that was published on that date. It returns every input list, with the book
+// picked from it if there is one, so GetRandomBestSellerBook only has to be called for
+// the rest. The date and books are selected by the default Strategy, between the oldest
+// and newest published dates of the weekly input lists. Lists with a Strategy other than
+// the default are left for GetRandomBestSellerBook, so that their own Strategy picks
+// their date.
+func (h *Handler) GetRandomOverviewBooks(input OverviewInput) ([]ListBook, error) {
+	def := h.strategies.defaultStrategy()
+	out := make([]ListBook, len(input.Lists))
+	inOverview := map[string]bool{}
+	var oldest, newest string
+	for i, list := range input.Lists {
+		out[i] = ListBook{BestSellerList: list}
+		if list.UpdatePeriod != "WEEKLY" || h.strategies.For(list.EncodedName) != def {
+			continue
+		}
+		inOverview[list.EncodedName] = true
+		if oldest == "" || list.OldestPublishedDate < oldest {
+			oldest = list.OldestPublishedDate
+		}
+		if list.NewestPublishedDate > newest {
+			newest = list.NewestPublishedDate
+		}
+	}
+	if oldest == "" {
+		return out, nil
+	}
+
+	date, err := def.Date(h.rng, oldest, newest)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := h.api.Overview(context.TODO(), date)
+	if errors.Is(err, nyt.ErrQuotaExhausted) {
+		return nil, &QuotaExhaustedError{err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	overviewLists := make(map[string]nyt.OverviewList, len(o.Lists))
+	for _, ol := range o.Lists {
+		overviewLists[ol.ListNameEncoded] = ol
+	}
+	var picked []books.BestSellerBook
+	for i, list := range input.Lists {
+		ol, ok := overviewLists[list.EncodedName]
+		if !ok || len(ol.Books) == 0 || !inOverview[list.EncodedName] {
+			continue
+		}
+		j, err := def.Book(context.TODO(), h.rng, ol.Books)
+		if err != nil {
+			return nil, err
+		}
+		b := newBook(list.EncodedName, o.PublishedDate, ol.DisplayName, ol.Updated, ol.Books[j])
+		out[i].Book = &b
+		picked = append(picked, b)
+	}
+
+	unprocessed, err := h.batchWriteBooks(picked)
+	if err != nil {
+		return nil, err
+	}
+	// Books that couldn't be written are left for GetRandomBestSellerBook
+	for i := range out {
+		if out[i].Book != nil && unprocessed[out[i].Book.ListEncodedName] {
+			out[i].Book = nil
+		}
+	}
+	return out, nil
+}
+
+// batchWriteBooks persists bs, retrying the unprocessed items with backoff. It returns
+// the encoded list names of the books that still weren't written.
+func (h *Handler) batchWriteBooks(bs []books.BestSellerBook) (map[string]bool, error) {
+	var reqs []types.WriteRequest
+	for _, b := range bs {
+		item, err := attributevalue.MarshalMap(b)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal book value: %w", err)
+		}
+		reqs = append(reqs, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+
+	const itemsPerBatch = 25
+	backoff := time.Second
+	for attempt := 0; attempt < batchWriteAttempts && len(reqs) != 0; attempt++ {
+		if attempt != 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var unprocessed []types.WriteRequest
+		for start := 0; start < len(reqs); start += itemsPerBatch {
+			stop := start + itemsPerBatch
+			if stop > len(reqs) {
+				stop = len(reqs)
+			}
+			bwOutput, err := h.ddb.BatchWriteItem(context.TODO(), &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]types.WriteRequest{h.tableName: reqs[start:stop]},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not batch write books: %w", err)
+			}
+			unprocessed = append(unprocessed, bwOutput.UnprocessedItems[h.tableName]...)
+		}
+		reqs = unprocessed
+	}
+
+	lists := map[string]bool{}
+	for _, req := range reqs {
+		if name, ok := req.PutRequest.Item["ListEncodedName"].(*types.AttributeValueMemberS); ok {
+			lists[name.Value] = true
+		}
+	}
+	return lists, nil
+}
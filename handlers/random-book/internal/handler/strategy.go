@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"bookoftheday/nyt"
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Strategy selects the publication date of the list that a book is picked from, and
+// the book picked from the list published on that date.
+type Strategy interface {
+	// Date returns a yyyy-MM-dd date between oldest and newest.
+	Date(rng *rand.Rand, oldest, newest string) (string, error)
+
+	// Book returns the index of the book to pick from bs, which isn't empty.
+	Book(ctx context.Context, rng *rand.Rand, bs []nyt.BestSellerBook) (int, error)
+}
+
+// Strategies are the Strategy of each list, by encoded name, and the Default of the
+// lists without one. The zero value uses UniformStrategy for every list.
+type Strategies struct {
+	Default Strategy
+	Lists   map[string]Strategy
+}
+
+// For returns the Strategy of list.
+func (s Strategies) For(list string) Strategy {
+	if st, ok := s.Lists[list]; ok {
+		return st
+	}
+	return s.defaultStrategy()
+}
+
+// defaultStrategy returns the Strategy of lists without their own.
+func (s Strategies) defaultStrategy() Strategy {
+	if s.Default != nil {
+		return s.Default
+	}
+	return UniformStrategy{}
+}
+
+// ParseStrategies parses a comma-separated list of list=strategy pairs, such as
+// "manga=rank,*=recent", where the list * sets the Default. Strategies are looked up by
+// name in byName.
+func ParseStrategies(spec string, byName map[string]Strategy) (Strategies, error) {
+	s := Strategies{Lists: map[string]Strategy{}}
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return Strategies{}, fmt.Errorf("invalid selection strategy %q: expected list=strategy", pair)
+		}
+		list, name := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if list == "" {
+			return Strategies{}, fmt.Errorf("invalid selection strategy %q: missing list", pair)
+		}
+		st, ok := byName[name]
+		if !ok {
+			return Strategies{}, fmt.Errorf("unknown selection strategy %q for list %s", name, list)
+		}
+		if list == "*" {
+			s.Default = st
+		} else {
+			s.Lists[list] = st
+		}
+	}
+	return s, nil
+}
+
+// StrategiesByName returns every Strategy by the name used in ParseStrategies. The
+// unseen strategy reads the books selected in the past month from tableName.
+func StrategiesByName(ddb DynamoDBQueryAPI, tableName string) map[string]Strategy {
+	return map[string]Strategy{
+		"uniform":       UniformStrategy{},
+		"rank":          RankStrategy{},
+		"weeks-on-list": WeeksOnListStrategy{},
+		"recent":        RecentStrategy{},
+		"unseen":        NewUnseenStrategy(ddb, tableName, 30),
+	}
+}
+
+// UniformStrategy picks every date and every book with the same probability.
+type UniformStrategy struct{}
+
+func (UniformStrategy) Date(rng *rand.Rand, oldest, newest string) (string, error) {
+	return getRandomDateBetween(rng, oldest, newest)
+}
+
+func (UniformStrategy) Book(ctx context.Context, rng *rand.Rand, bs []nyt.BestSellerBook) (int, error) {
+	return rng.Intn(len(bs)), nil
+}
+
+// RankStrategy picks dates uniformly, and weights books toward higher ranks: on a list
+// of n books, rank 1 is n times as likely as rank n.
+type RankStrategy struct {
+	UniformStrategy
+}
+
+func (RankStrategy) Book(ctx context.Context, rng *rand.Rand, bs []nyt.BestSellerBook) (int, error) {
+	weights := make([]int, len(bs))
+	for i, b := range bs {
+		weights[i] = len(bs) + 1 - b.Rank
+		if weights[i] < 1 {
+			weights[i] = 1
+		}
+	}
+	return pickWeighted(rng, weights), nil
+}
+
+// WeeksOnListStrategy picks dates uniformly, and weights books by how many weeks they've
+// been on the list, plus one so that new books can be picked.
+type WeeksOnListStrategy struct {
+	UniformStrategy
+}
+
+func (WeeksOnListStrategy) Book(ctx context.Context, rng *rand.Rand, bs []nyt.BestSellerBook) (int, error) {
+	weights := make([]int, len(bs))
+	for i, b := range bs {
+		weights[i] = b.WeeksOnList + 1
+	}
+	return pickWeighted(rng, weights), nil
+}
+
+// RecentStrategy weights dates toward the newest, with a probability that grows linearly
+// from the oldest date. For a list published for 30 years, a date in the last decade is
+// 5 times as likely as one in the first. Books are picked uniformly.
+type RecentStrategy struct {
+	UniformStrategy
+}
+
+func (RecentStrategy) Date(rng *rand.Rand, oldest, newest string) (string, error) {
+	o, days, err := parseDateRange(oldest, newest)
+	if err != nil {
+		return "", err
+	}
+	// The square root of a uniform value has a linearly increasing density
+	rd := int(float64(days) * math.Sqrt(rng.Float64()))
+	return o.AddDate(0, 0, rd).Format(ymdLayout), nil
+}
+
+// DynamoDBQueryAPI provides a unit-testable interface to access the DynamoDB Query API.
+type DynamoDBQueryAPI interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// UnseenStrategy picks dates uniformly, and picks books that weren't selected for any
+// list in the past days, using the ISBNIndex of the books table. If every book was
+// selected, it picks any of them.
+type UnseenStrategy struct {
+	UniformStrategy
+	ddb       DynamoDBQueryAPI
+	tableName string
+	days      int
+	now       func() time.Time
+}
+
+// NewUnseenStrategy creates an UnseenStrategy for the books table tableName.
+func NewUnseenStrategy(ddb DynamoDBQueryAPI, tableName string, days int) *UnseenStrategy {
+	return &UnseenStrategy{ddb: ddb, tableName: tableName, days: days, now: time.Now}
+}
+
+func (s *UnseenStrategy) Book(ctx context.Context, rng *rand.Rand, bs []nyt.BestSellerBook) (int, error) {
+	since := s.now().AddDate(0, 0, -s.days).Format(ymdLayout)
+	weights := make([]int, len(bs))
+	unseen := 0
+	for i, b := range bs {
+		// Books without an ISBN-13 aren't in the index
+		if b.PrimaryISBN13 == "" {
+			weights[i] = 1
+			unseen++
+			continue
+		}
+		out, err := s.ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:              &s.tableName,
+			IndexName:              aws.String("ISBNIndex"),
+			KeyConditionExpression: aws.String("PrimaryISBN13 = :isbn AND DateSelected >= :since"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":isbn":  &types.AttributeValueMemberS{Value: b.PrimaryISBN13},
+				":since": &types.AttributeValueMemberS{Value: since},
+			},
+			Select: types.SelectCount,
+			Limit:  aws.Int32(1),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("could not query recently selected books: %w", err)
+		}
+		if out.Count == 0 {
+			weights[i] = 1
+			unseen++
+		}
+	}
+	if unseen == 0 {
+		return rng.Intn(len(bs)), nil
+	}
+	return pickWeighted(rng, weights), nil
+}
+
+// pickWeighted returns a random index of weights, with a probability proportional to
+// its weight. The weights can't all be 0.
+func pickWeighted(rng *rand.Rand, weights []int) int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	r := rng.Intn(total)
+	for i, w := range weights {
+		if r < w {
+			return i
+		}
+		r -= w
+	}
+	return len(weights) - 1
+}
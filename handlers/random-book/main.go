@@ -1,11 +1,12 @@
 package main
 
 import (
+	"bookoftheday/nyt"
 	"context"
 	"log"
 	"math/rand"
 	"os"
-	"random-book/internal/api"
+	"random-book/internal/cache"
 	"random-book/internal/handler"
 	"time"
 
@@ -33,12 +34,33 @@ func main() {
 		log.Fatalln("could not get SSM parameter: " + err.Error())
 	}
 
-	api := api.NewNYTBooksAPI(*gpOutput.Parameter.Value, "https://api.nytimes.com/svc/books/v3/lists/%s/%s.json")
-
 	ddbClient := dynamodb.NewFromConfig(cfg)
 
+	// Lists published on past dates never change, so they're read from a cache before
+	// the NYT API when one is configured.
+	var api handler.BooksAPI = nyt.New(nyt.Config{APIKey: *gpOutput.Parameter.Value})
+	if table := os.Getenv("LIST_CACHE_TABLE_NAME"); table != "" {
+		api = cache.New(cache.Config{API: api, Store: cache.NewDynamoDBStore(ddbClient, table)})
+	} else if dir := os.Getenv("LIST_CACHE_DIR"); dir != "" {
+		api = cache.New(cache.Config{API: api, Store: cache.NewFileStore(dir)})
+	}
+
 	s := rand.NewSource(time.Now().UnixNano())
 	r := rand.New(s)
-	h := handler.New(api, ddbClient, os.Getenv("BOOKS_TABLE_NAME"), r)
-	lambda.Start(h.GetRandomBestSellerBook)
+	strategies, err := handler.ParseStrategies(
+		os.Getenv("SELECTION_STRATEGIES"),
+		handler.StrategiesByName(ddbClient, os.Getenv("BOOKS_TABLE_NAME")),
+	)
+	if err != nil {
+		log.Fatalln("configuration error: " + err.Error())
+	}
+	h := handler.New(api, ddbClient, os.Getenv("BOOKS_TABLE_NAME"), r, strategies)
+
+	// The same function code gets books for every list from one overview, and for a
+	// single list when the list wasn't in the overview.
+	if os.Getenv("OVERVIEW_MODE") == "true" {
+		lambda.Start(h.GetRandomOverviewBooks)
+	} else {
+		lambda.Start(h.GetRandomBestSellerBook)
+	}
 }
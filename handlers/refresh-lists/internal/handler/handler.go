@@ -17,7 +17,7 @@ import (
 
 // BooksAPI enables requests to the NYT Books API.
 type BooksAPI interface {
-	GetBestSellerListNames() ([]books.BestSellerList, error)
+	ListNames(ctx context.Context) ([]books.BestSellerList, error)
 }
 
 // DynamoDBBatchWriteItemAPI provides a testable interface for using the
@@ -92,7 +92,7 @@ func (h *Handler) batchWriteList(list []books.BestSellerList) ([]types.WriteRequ
 // RefreshBestSellerLists fetches the latest Best Seller list names
 // from the Books API and stores them in a DynamoDB table.
 func (h *Handler) RefreshBestSellerLists() error {
-	list, err := h.api.GetBestSellerListNames()
+	list, err := h.api.ListNames(context.TODO())
 	if err != nil {
 		return err
 	}
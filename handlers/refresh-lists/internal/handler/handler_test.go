@@ -19,7 +19,7 @@ type fakeBooksAPI struct {
 	data []books.BestSellerList
 }
 
-func (f fakeBooksAPI) GetBestSellerListNames() ([]books.BestSellerList, error) {
+func (f fakeBooksAPI) ListNames(ctx context.Context) ([]books.BestSellerList, error) {
 	return f.data, nil
 }
 
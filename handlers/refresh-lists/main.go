@@ -1,10 +1,10 @@
 package main
 
 import (
+	"bookoftheday/nyt"
 	"context"
 	"log"
 	"os"
-	"refresh-lists/internal/api"
 	"refresh-lists/internal/handler"
 
 	"github.com/aws/aws-lambda-go/lambda"
@@ -33,7 +33,7 @@ func main() {
 		log.Fatalln("could not get SSM parameter: " + err.Error())
 	}
 
-	api := api.NewNYTBooksAPI(*gpOutput.Parameter.Value, "https://api.nytimes.com/svc/books/v3/lists/names.json")
+	api := nyt.New(nyt.Config{APIKey: *gpOutput.Parameter.Value})
 
 	ddbClient := dynamodb.NewFromConfig(cfg)
 
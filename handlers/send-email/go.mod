@@ -7,6 +7,7 @@ require (
 	github.com/aws/aws-sdk-go-v2 v1.16.5
 	github.com/aws/aws-sdk-go-v2/config v1.15.11
 	github.com/aws/aws-sdk-go-v2/service/sesv2 v1.13.7
+	github.com/aws/aws-sdk-go-v2/service/ssm v1.27.2
 )
 
 require (
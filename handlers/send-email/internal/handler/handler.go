@@ -2,12 +2,16 @@
 package handler
 
 import (
+	"bookoftheday/token"
 	books "bookoftheday/types"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -27,6 +31,9 @@ type Handler struct {
 	configurationSet string
 	topicName        string
 	fromEmailAddr    string
+	signer           *token.Signer
+	manageURL        string
+	manageTokenTTL   time.Duration
 }
 
 // Config provides configuration options for a Handler.
@@ -36,6 +43,16 @@ type Config struct {
 	ConfigurationSet string
 	TopicName        string
 	FromEmailAddress string
+
+	// Signer signs the token in each email that lets the contact manage their subscription.
+	Signer *token.Signer
+
+	// ManageURL is the page that contacts are linked to for managing their subscription.
+	// The token is passed to it in the token query parameter.
+	ManageURL string
+
+	// ManageTokenTTL is how long the link to manage a subscription is valid for.
+	ManageTokenTTL time.Duration
 }
 
 // New creates a new Handler instance.
@@ -46,6 +63,9 @@ func New(cfg Config) *Handler {
 		configurationSet: cfg.ConfigurationSet,
 		topicName:        cfg.TopicName,
 		fromEmailAddr:    cfg.FromEmailAddress,
+		signer:           cfg.Signer,
+		manageURL:        cfg.ManageURL,
+		manageTokenTTL:   cfg.ManageTokenTTL,
 	}
 }
 
@@ -61,7 +81,19 @@ func (h *Handler) sendEmail(ctx context.Context, msg events.SQSMessage) error {
 		return fmt.Errorf("could not unmarshal body: %w", err)
 	}
 
-	txt, html := formatBodyContent(body.Book)
+	link, err := h.manageLink(body.ContactEmail)
+	if err != nil {
+		return err
+	}
+
+	subj := subject
+	var txt, html string
+	if len(body.Digest) != 0 {
+		subj = digestSubject
+		txt, html = formatDigestContent(body.Digest, link)
+	} else {
+		txt, html = formatBodyContent(body.Book, link)
+	}
 	_, err = h.seAPI.SendEmail(ctx, &sesv2.SendEmailInput{
 		Destination: &sestypes.Destination{
 			ToAddresses: []string{body.ContactEmail},
@@ -74,7 +106,7 @@ func (h *Handler) sendEmail(ctx context.Context, msg events.SQSMessage) error {
 		},
 		Content: &sestypes.EmailContent{
 			Simple: &sestypes.Message{
-				Subject: &sestypes.Content{Charset: aws.String(charset), Data: aws.String(subject)},
+				Subject: &sestypes.Content{Charset: aws.String(charset), Data: aws.String(subj)},
 				Body: &sestypes.Body{
 					Text: &sestypes.Content{Charset: aws.String(charset), Data: aws.String(txt)},
 					Html: &sestypes.Content{Charset: aws.String(charset), Data: aws.String(html)},
@@ -85,6 +117,19 @@ func (h *Handler) sendEmail(ctx context.Context, msg events.SQSMessage) error {
 	return err
 }
 
+// manageLink returns the link that lets the contact unsubscribe or change their preferences.
+func (h *Handler) manageLink(email string) (string, error) {
+	tok, err := h.signer.Sign(token.Claims{
+		Subject:   email,
+		Purpose:   token.PurposeManage,
+		ExpiresAt: time.Now().Add(h.manageTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not sign manage token: %w", err)
+	}
+	return h.manageURL + "?token=" + url.QueryEscape(tok), nil
+}
+
 // SendEmailWithBook gets a random book and emails it to the contact.
 func (h *Handler) SendEmailWithBook(event events.SQSEvent) (events.SQSEventResponse, error) {
 	failures := make(chan failure)
@@ -123,6 +168,7 @@ func (h *Handler) SendEmailWithBook(event events.SQSEvent) (events.SQSEventRespo
 }
 
 const subject = "Book of the Day"
+const digestSubject = "Book of the Day Weekly Digest"
 const charset = "UTF-8"
 
 //	Parameters in order:
@@ -136,6 +182,7 @@ const charset = "UTF-8"
 // 		- PrimaryISBN10
 // 		- PrimaryISBN13
 //		- AmazonProductURL
+//		- Manage subscription link
 const bodyText = `Book of the Day
 Your Book of the Day is "%s" by "%s". It was rank %d for the list "%s" published %s.
 Description: %s
@@ -143,6 +190,7 @@ Publisher: %s
 ISBN10: %s
 ISBN13: %s
 Amazon: %s
+Manage your subscription: %s
 Unsubscribe: {{amazonSESUnsubscribeUrl}}
 `
 
@@ -162,6 +210,7 @@ Unsubscribe: {{amazonSESUnsubscribeUrl}}
 // 		- PrimaryISBN10
 // 		- PrimaryISBN13
 //		- AmazonProductURL
+//		- Manage subscription link
 const bodyHTML = `<html>
 <head>
 <style>
@@ -180,13 +229,14 @@ const bodyHTML = `<html>
 	<p>Publisher: %s</p>
 	<p><span>ISBN10: %s</span><br><span>ISBN13: %s</span></p>
 	<p>Get it on Amazon: <a href="%s" target="_blank">here</a></p>
+	<a href="%s" target="_blank">Manage your subscription</a>
 	<a href="{{amazonSESUnsubscribeUrl}}" target="_blank">Unsubscribe</a>
 </body>
 </html>
 `
 
 // formatBodyContent formats and returns the email body text and HTML for a given book.
-func formatBodyContent(book books.BestSellerBook) (string, string) {
+func formatBodyContent(book books.BestSellerBook, manageLink string) (string, string) {
 	txt := fmt.Sprintf(bodyText,
 		book.Title,
 		book.Author,
@@ -198,6 +248,7 @@ func formatBodyContent(book books.BestSellerBook) (string, string) {
 		book.PrimaryISBN10,
 		book.PrimaryISBN13,
 		book.AmazonProductURL,
+		manageLink,
 	)
 	html := fmt.Sprintf(bodyHTML,
 		book.ImageURL,
@@ -214,6 +265,121 @@ func formatBodyContent(book books.BestSellerBook) (string, string) {
 		book.PrimaryISBN10,
 		book.PrimaryISBN13,
 		book.AmazonProductURL,
+		manageLink,
 	)
 	return txt, html
 }
+
+//	Parameters in order:
+//		- Book entries formatted with digestBookText
+//		- Manage subscription link
+const digestText = `Book of the Day Weekly Digest
+Here are your Books of the Day for the week.
+%s
+Manage your subscription: %s
+Unsubscribe: {{amazonSESUnsubscribeUrl}}
+`
+
+//	Parameters in order:
+//		- Title
+// 		- Author
+// 		- Rank
+//		- ListDisplayName
+// 		- ListPublishedDate
+//		- Description
+const digestBookText = `
+"%s" by "%s", rank %d for the list "%s" published %s.
+Description: %s
+`
+
+//	Parameters in order:
+//		- AmazonProductURL
+const digestAmazonText = `Amazon: %s
+`
+
+//	Parameters in order:
+//		- Book entries formatted with digestBookHTML
+//		- Manage subscription link
+const digestHTML = `<html>
+<head>
+<style>
+	img {
+		border: 1px solid black;
+		max-height: 200px;
+		width: auto;
+	}
+</style>
+</head>
+<body>
+	<h1>Book of the Day Weekly Digest</h1>
+	<p>Here are your Books of the Day for the week.</p>
+%s
+	<a href="%s" target="_blank">Manage your subscription</a>
+	<a href="{{amazonSESUnsubscribeUrl}}" target="_blank">Unsubscribe</a>
+</body>
+</html>
+`
+
+//	Parameters in order:
+//		- ImageURL
+//		- Title
+//		- ImageWidth
+//		- ImageHeight
+const digestImageHTML = `		<img src="%s" alt="%s cover image" width="%d" height="%d">
+`
+
+//	Parameters in order:
+//		- Title
+// 		- Author
+// 		- Rank
+//		- ListDisplayName
+// 		- ListPublishedDate
+//		- Description
+const digestBookHTML = `		<p>"%s" by "%s". It was rank %d for the list "%s" published %s.</p>
+		<p>Description: %s</p>
+`
+
+//	Parameters in order:
+//		- AmazonProductURL
+const digestAmazonHTML = `		<p>Get it on Amazon: <a href="%s" target="_blank">here</a></p>
+`
+
+// formatDigestContent formats and returns the email body text and HTML for a week of books.
+// The cover image and Amazon link of a book are left out if it doesn't have them.
+func formatDigestContent(digest []books.BestSellerBook, manageLink string) (string, string) {
+	var txtBooks, htmlBooks strings.Builder
+	for _, book := range digest {
+		fmt.Fprintf(&txtBooks, digestBookText,
+			book.Title,
+			book.Author,
+			book.Rank,
+			book.ListDisplayName,
+			book.ListPublishedDate,
+			book.Description,
+		)
+
+		htmlBooks.WriteString("\t<div>\n")
+		if book.ImageURL != "" {
+			fmt.Fprintf(&htmlBooks, digestImageHTML,
+				book.ImageURL,
+				book.Title,
+				book.ImageWidth,
+				book.ImageHeight,
+			)
+		}
+		fmt.Fprintf(&htmlBooks, digestBookHTML,
+			book.Title,
+			book.Author,
+			book.Rank,
+			book.ListDisplayName,
+			book.ListPublishedDate,
+			book.Description,
+		)
+		if book.AmazonProductURL != "" {
+			fmt.Fprintf(&txtBooks, digestAmazonText, book.AmazonProductURL)
+			fmt.Fprintf(&htmlBooks, digestAmazonHTML, book.AmazonProductURL)
+		}
+		htmlBooks.WriteString("\t</div>\n")
+	}
+	return fmt.Sprintf(digestText, txtBooks.String(), manageLink), fmt.Sprintf(digestHTML, htmlBooks.String(), manageLink)
+}
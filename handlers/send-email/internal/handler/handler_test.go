@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"bookoftheday/token"
+	books "bookoftheday/types"
+	"context"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+)
+
+type fakeSESv2SendEmailAPI struct {
+	mu   sync.Mutex
+	sent map[string]*sesv2.SendEmailInput
+}
+
+func (f *fakeSESv2SendEmailAPI) SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent[params.Destination.ToAddresses[0]] = params
+	return &sesv2.SendEmailOutput{}, nil
+}
+
+const manageURL = "https://example.com/manage"
+
+var signer = token.NewSigner([]byte("secret"))
+
+var fullBook = books.BestSellerBook{
+	ListDisplayName:   "Hardcover Fiction",
+	ListPublishedDate: "2022-06-12",
+	Rank:              1,
+	Title:             "FULL BOOK",
+	Author:            "Full Author",
+	Description:       "A book with every field.",
+	Publisher:         "Publisher",
+	PrimaryISBN10:     "0123456789",
+	PrimaryISBN13:     "0123456789012",
+	ImageURL:          "https://example.com/full.jpg",
+	ImageWidth:        330,
+	ImageHeight:       500,
+	AmazonProductURL:  "https://example.com/amazon/full",
+}
+
+var bareBook = books.BestSellerBook{
+	ListDisplayName:   "Manga",
+	ListPublishedDate: "2022-06-05",
+	Rank:              3,
+	Title:             "BARE BOOK",
+	Author:            "Bare Author",
+}
+
+func TestFormatDigestContent(t *testing.T) {
+	link := manageURL + "?token=tok"
+
+	testCases := []struct {
+		name     string
+		digest   []books.BestSellerBook
+		wantTxt  []string
+		wantHTML []string
+		notTxt   []string
+		notHTML  []string
+	}{
+		{
+			name:   "several books",
+			digest: []books.BestSellerBook{fullBook, bareBook},
+			wantTxt: []string{
+				`"FULL BOOK" by "Full Author", rank 1 for the list "Hardcover Fiction" published 2022-06-12.`,
+				"Description: A book with every field.",
+				"Amazon: https://example.com/amazon/full",
+				`"BARE BOOK" by "Bare Author", rank 3 for the list "Manga" published 2022-06-05.`,
+				"Manage your subscription: " + link,
+			},
+			wantHTML: []string{
+				`<img src="https://example.com/full.jpg" alt="FULL BOOK cover image" width="330" height="500">`,
+				`<a href="https://example.com/amazon/full" target="_blank">here</a>`,
+				`<p>"BARE BOOK" by "Bare Author". It was rank 3 for the list "Manga" published 2022-06-05.</p>`,
+				`<a href="` + link + `" target="_blank">Manage your subscription</a>`,
+			},
+		},
+		{
+			name:     "book without cover or Amazon link",
+			digest:   []books.BestSellerBook{bareBook},
+			wantTxt:  []string{`"BARE BOOK" by "Bare Author"`, "Manage your subscription: " + link},
+			wantHTML: []string{`"BARE BOOK" by "Bare Author"`, `<a href="` + link + `" target="_blank">Manage your subscription</a>`},
+			notTxt:   []string{"Amazon:"},
+			notHTML:  []string{"<img", "Get it on Amazon", `href=""`},
+		},
+		{
+			name:    "empty digest",
+			wantTxt: []string{"Here are your Books of the Day for the week.", "Manage your subscription: " + link},
+			notHTML: []string{"<div>"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			txt, html := formatDigestContent(tc.digest, link)
+			for _, want := range tc.wantTxt {
+				if !strings.Contains(txt, want) {
+					t.Errorf("text doesn't contain %q:\n%s", want, txt)
+				}
+			}
+			for _, want := range tc.wantHTML {
+				if !strings.Contains(html, want) {
+					t.Errorf("HTML doesn't contain %q:\n%s", want, html)
+				}
+			}
+			for _, not := range tc.notTxt {
+				if strings.Contains(txt, not) {
+					t.Errorf("text contains %q:\n%s", not, txt)
+				}
+			}
+			for _, not := range tc.notHTML {
+				if strings.Contains(html, not) {
+					t.Errorf("HTML contains %q:\n%s", not, html)
+				}
+			}
+			if got, want := strings.Count(html, "<div>"), len(tc.digest); got != want {
+				t.Errorf("HTML has %d book entries; expected %d", got, want)
+			}
+		})
+	}
+}
+
+// manageLinkPattern matches the manage link in the text of an email.
+var manageLinkPattern = regexp.MustCompile(`Manage your subscription: (\S+)`)
+
+func TestSendEmailWithBook(t *testing.T) {
+	f := &fakeSESv2SendEmailAPI{sent: map[string]*sesv2.SendEmailInput{}}
+	h := New(Config{
+		SendEmailAPI:     f,
+		ContactListName:  "contacts",
+		ConfigurationSet: "config",
+		TopicName:        "topic",
+		FromEmailAddress: "books@example.com",
+		Signer:           signer,
+		ManageURL:        manageURL,
+		ManageTokenTTL:   time.Hour,
+	})
+
+	body := func(mb books.SQSBookMessageBody) string {
+		b, _ := json.Marshal(mb)
+		return string(b)
+	}
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "daily", Body: body(books.SQSBookMessageBody{ContactEmail: "daily@example.com", Book: fullBook})},
+		{MessageId: "weekly", Body: body(books.SQSBookMessageBody{ContactEmail: "weekly@example.com", Digest: []books.BestSellerBook{fullBook, bareBook}})},
+		{MessageId: "invalid", Body: "{"},
+	}}
+	resp, err := h.SendEmailWithBook(event)
+	if err != nil {
+		t.Fatalf("got error %v; expected nil", err)
+	}
+	if len(resp.BatchItemFailures) != 1 || resp.BatchItemFailures[0].ItemIdentifier != "invalid" {
+		t.Errorf("got batch item failures %v; expected only the invalid message", resp.BatchItemFailures)
+	}
+
+	testCases := []struct {
+		email   string
+		subject string
+		want    []string
+	}{
+		{"daily@example.com", subject, []string{
+			`Your Book of the Day is "FULL BOOK" by "Full Author". It was rank 1 for the list "Hardcover Fiction" published 2022-06-12.`,
+			"ISBN13: 0123456789012",
+			"Amazon: https://example.com/amazon/full",
+		}},
+		{"weekly@example.com", digestSubject, []string{`"FULL BOOK" by "Full Author"`, `"BARE BOOK" by "Bare Author"`}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.email, func(t *testing.T) {
+			in, ok := f.sent[tc.email]
+			if !ok {
+				t.Fatalf("no email sent to %s", tc.email)
+			}
+			msg := in.Content.Simple
+			if *msg.Subject.Data != tc.subject {
+				t.Errorf("got subject %q; expected %q", *msg.Subject.Data, tc.subject)
+			}
+			txt := *msg.Body.Text.Data
+			for _, want := range tc.want {
+				if !strings.Contains(txt, want) {
+					t.Errorf("text doesn't contain %q:\n%s", want, txt)
+				}
+			}
+
+			m := manageLinkPattern.FindStringSubmatch(txt)
+			if m == nil {
+				t.Fatalf("text has no manage link:\n%s", txt)
+			}
+			if !strings.Contains(*msg.Body.Html.Data, `<a href="`+m[1]+`"`) {
+				t.Errorf("HTML doesn't link to %s", m[1])
+			}
+			link, err := url.Parse(m[1])
+			if err != nil {
+				t.Fatalf("could not parse manage link %s: %v", m[1], err)
+			}
+			if got := link.Scheme + "://" + link.Host + link.Path; got != manageURL {
+				t.Errorf("manage link is to %s; expected %s", got, manageURL)
+			}
+			claims, err := signer.Verify(link.Query().Get("token"), token.PurposeManage, time.Now())
+			if err != nil {
+				t.Fatalf("could not verify manage token: %v", err)
+			}
+			if claims.Subject != tc.email {
+				t.Errorf("got token for %s; expected %s", claims.Subject, tc.email)
+			}
+			if exp := time.Unix(claims.ExpiresAt, 0); exp.Before(time.Now().Add(59*time.Minute)) || exp.After(time.Now().Add(time.Hour)) {
+				t.Errorf("manage token expires at %s; expected in an hour", exp)
+			}
+		})
+	}
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"bookoftheday/token"
 	"context"
 	"log"
 	"os"
 	"send-email/internal/handler"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 )
 
 func main() {
@@ -17,6 +22,22 @@ func main() {
 		log.Fatalln("configuration error: " + err.Error())
 	}
 
+	ssmClient := ssm.NewFromConfig(cfg)
+	gpInput := &ssm.GetParameterInput{
+		Name:           aws.String(os.Getenv("SSM_PARAM_NAME")),
+		WithDecryption: true,
+	}
+
+	gpOutput, err := ssmClient.GetParameter(context.TODO(), gpInput)
+	if err != nil {
+		log.Fatalln("could not get SSM parameter: " + err.Error())
+	}
+
+	ttlHours, err := strconv.Atoi(os.Getenv("MANAGE_TOKEN_TTL_HOURS"))
+	if err != nil {
+		log.Fatalln("invalid MANAGE_TOKEN_TTL_HOURS: " + err.Error())
+	}
+
 	sesClient := sesv2.NewFromConfig(cfg)
 
 	h := handler.New(handler.Config{
@@ -25,6 +46,9 @@ func main() {
 		ConfigurationSet: os.Getenv("CONFIGURATION_SET"),
 		TopicName:        os.Getenv("TOPIC_NAME"),
 		FromEmailAddress: os.Getenv("FROM_EMAIL_ADDR"),
+		Signer:           token.NewSigner([]byte(*gpOutput.Parameter.Value)),
+		ManageURL:        os.Getenv("MANAGE_URL"),
+		ManageTokenTTL:   time.Duration(ttlHours) * time.Hour,
 	})
 	lambda.Start(h.SendEmailWithBook)
 }
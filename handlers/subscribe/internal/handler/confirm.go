@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"bookoftheday/token"
+	books "bookoftheday/types"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// pendingSubscription models a subscription that is waiting for its email to be confirmed.
+type pendingSubscription struct {
+	TokenID string
+
+	// Subscriber contains the preferences that are saved once the subscription is confirmed.
+	books.Subscriber
+
+	// Confirmed is set once the confirmation link has been used, so that it can't be used again.
+	Confirmed bool
+
+	// Expiration is the TTL of the item, which is the same as the expiration of its token.
+	Expiration int64
+}
+
+// newTokenID returns a random identifier for a confirmation token.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate token ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// confirmURL returns the link to the confirm endpoint of the API that received req.
+func confirmURL(req events.APIGatewayV2HTTPRequest, tok string) string {
+	return fmt.Sprintf("https://%s/subscribe/confirm?token=%s", req.RequestContext.DomainName, url.QueryEscape(tok))
+}
+
+func (h *Handler) sendConfirmation(ctx context.Context, email string, link string) error {
+	_, err := h.sendEmail.SendEmail(ctx, &sesv2.SendEmailInput{
+		Destination: &types.Destination{
+			ToAddresses: []string{email},
+		},
+		FromEmailAddress: &h.fromEmailAddr,
+		Content: &types.EmailContent{
+			Simple: &types.Message{
+				Subject: &types.Content{Charset: aws.String(charset), Data: aws.String(confirmSubject)},
+				Body: &types.Body{
+					Text: &types.Content{Charset: aws.String(charset), Data: aws.String(fmt.Sprintf(confirmText, link, int(h.confirmationTTL.Hours())))},
+					Html: &types.Content{Charset: aws.String(charset), Data: aws.String(fmt.Sprintf(confirmHTML, link, int(h.confirmationTTL.Hours())))},
+				},
+			},
+		},
+	})
+	return err
+}
+
+// Confirm creates the opted-in contact for the pending subscription identified by
+// the request's token. Invalid tokens, including those issued for another purpose,
+// result in 401 Unauthorized, expired tokens result in 410 Gone and tokens that were
+// already used result in 409 Conflict.
+func (h *Handler) Confirm(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	tok, ok := req.QueryStringParameters["token"]
+	if !ok || len(tok) == 0 {
+		return response(http.StatusBadRequest, SubscribeResponse{Errors: []ErrorInfo{{"token", "token is required", "query"}}})
+	}
+
+	claims, err := h.signer.Verify(tok, token.PurposeConfirm, time.Now())
+	if errors.Is(err, token.ErrExpired) {
+		return response(http.StatusGone, SubscribeResponse{Errors: []ErrorInfo{{"token", "token has expired, subscribe again to get a new link", "query"}}})
+	}
+	if err != nil {
+		return response(http.StatusUnauthorized, SubscribeResponse{Errors: []ErrorInfo{{"token", "token is invalid", "query"}}})
+	}
+
+	ctx := context.TODO()
+
+	key := map[string]ddbtypes.AttributeValue{
+		"TokenID": &ddbtypes.AttributeValueMemberS{Value: claims.ID},
+	}
+	out, err := h.getItem.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      &h.pendingTableName,
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error getting pending subscription: %w", err)
+	}
+	if len(out.Item) == 0 {
+		// The item may have been removed by its TTL slightly before the token expired
+		return response(http.StatusGone, SubscribeResponse{Errors: []ErrorInfo{{"token", "subscription request no longer exists, subscribe again to get a new link", "query"}}})
+	}
+
+	var pending pendingSubscription
+	if err := attributevalue.UnmarshalMap(out.Item, &pending); err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("could not unmarshal pending subscription: %w", err)
+	}
+	if pending.Confirmed {
+		return response(http.StatusConflict, SubscribeResponse{Errors: []ErrorInfo{{"token", "token has already been used", "query"}}})
+	}
+
+	_, err = h.ses.CreateContact(ctx, &sesv2.CreateContactInput{
+		ContactListName: aws.String(h.contactListName),
+		EmailAddress:    aws.String(pending.EmailAddress),
+		TopicPreferences: []types.TopicPreference{
+			{
+				TopicName:          aws.String("Books"),
+				SubscriptionStatus: types.SubscriptionStatusOptIn,
+			},
+		},
+	})
+	// The contact already exists when an earlier attempt failed after creating it, so
+	// the rest of the confirmation continues to save the subscriber's preferences
+	var alreadyExists *types.AlreadyExistsException
+	if err != nil && !errors.As(err, &alreadyExists) {
+		log.Printf("error in CreateContact: %v", err)
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error creating contact: %w", err)
+	}
+
+	item, err := attributevalue.MarshalMap(pending.Subscriber)
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("could not marshal subscriber: %w", err)
+	}
+
+	_, err = h.putItem.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &h.subscribersTableName,
+		Item:      item,
+	})
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error saving subscriber: %w", err)
+	}
+
+	// Mark the token as used last, so that a failure above can be retried with the same link
+	_, err = h.updateItem.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           &h.pendingTableName,
+		Key:                 key,
+		UpdateExpression:    aws.String("SET Confirmed = :true"),
+		ConditionExpression: aws.String("Confirmed = :false"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":true":  &ddbtypes.AttributeValueMemberBOOL{Value: true},
+			":false": &ddbtypes.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+	if err != nil {
+		var conditionFailed *ddbtypes.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return response(http.StatusConflict, SubscribeResponse{Errors: []ErrorInfo{{"token", "token has already been used", "query"}}})
+		}
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error confirming subscription: %w", err)
+	}
+
+	return response(http.StatusOK, subscriberResponse(pending.Subscriber))
+}
+
+const charset = "UTF-8"
+const confirmSubject = "Confirm your Book of the Day subscription"
+
+// Parameters in order:
+//   - Confirmation link
+//   - Hours until the link expires
+const confirmText = `Book of the Day
+Someone, hopefully you, asked to subscribe this address to Book of the Day.
+Confirm your subscription: %s
+The link expires in %d hours. If you didn't subscribe, you can ignore this email.
+`
+
+// Parameters in order:
+//   - Confirmation link
+//   - Hours until the link expires
+const confirmHTML = `<html>
+<body>
+	<h1>Book of the Day</h1>
+	<p>Someone, hopefully you, asked to subscribe this address to Book of the Day.</p>
+	<p><a href="%s" target="_blank">Confirm your subscription</a></p>
+	<p>The link expires in %d hours. If you didn't subscribe, you can ignore this email.</p>
+</body>
+</html>
+`
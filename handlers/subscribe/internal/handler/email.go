@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Blocklist contains the addresses that aren't allowed to subscribe.
+type Blocklist struct {
+	// LocalParts are role names such as "postmaster" that are rejected on any domain.
+	LocalParts []string
+
+	// Domains are rejected along with their subdomains, and are meant for disposable
+	// email providers.
+	Domains []string
+}
+
+// blocks reports whether the normalized address is in the blocklist.
+func (b Blocklist) blocks(localPart, domain string) bool {
+	for _, l := range b.LocalParts {
+		if localPart == l {
+			return true
+		}
+	}
+	for _, d := range b.Domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseBlocklistEntries splits a comma-separated list of blocklist entries and
+// normalizes them the same way email addresses are.
+func ParseBlocklistEntries(s string) []string {
+	var entries []string
+	for _, e := range strings.Split(s, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if a, err := idna.Lookup.ToASCII(e); err == nil {
+			e = a
+		}
+		if len(e) != 0 {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// normalizeEmail validates the syntax of an email address and returns it trimmed,
+// lowercased and with its domain in ASCII form. The error message is meant to be
+// shown to the user.
+func normalizeEmail(email string, blocklist Blocklist) (string, error) {
+	email = strings.TrimSpace(email)
+
+	// ParseAddress also accepts addresses with a display name, such as
+	// "Name <email@example.com>", which can't be used as a contact.
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", fmt.Errorf("email %q is not a valid email address", email)
+	}
+
+	at := strings.LastIndex(addr.Address, "@")
+	localPart := strings.ToLower(addr.Address[:at])
+	domain := strings.ToLower(addr.Address[at+1:])
+
+	for _, r := range localPart {
+		if r > 127 {
+			return "", fmt.Errorf("email %q must only use ASCII characters before the @", email)
+		}
+	}
+
+	// Converting to ASCII also checks that an internationalized domain is valid
+	domain, err = idna.Lookup.ToASCII(domain)
+	if err != nil || !strings.Contains(domain, ".") {
+		return "", fmt.Errorf("email %q does not have a valid domain", email)
+	}
+
+	if blocklist.blocks(localPart, domain) {
+		return "", fmt.Errorf("email %q cannot be subscribed, use a personal address", email)
+	}
+
+	return localPart + "@" + domain, nil
+}
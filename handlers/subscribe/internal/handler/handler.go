@@ -2,16 +2,23 @@
 package handler
 
 import (
+	"bookoftheday/token"
+	books "bookoftheday/types"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2"
-	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
 )
 
 // SESv2CreateContactAPI allows creating a new SES contact.
@@ -19,45 +26,365 @@ type SESv2CreateContactAPI interface {
 	CreateContact(ctx context.Context, params *sesv2.CreateContactInput, optFns ...func(*sesv2.Options)) (*sesv2.CreateContactOutput, error)
 }
 
+// SESv2DeleteContactAPI allows deleting an SES contact.
+type SESv2DeleteContactAPI interface {
+	DeleteContact(ctx context.Context, params *sesv2.DeleteContactInput, optFns ...func(*sesv2.Options)) (*sesv2.DeleteContactOutput, error)
+}
+
+// SESv2SendEmailAPI allows sending emails.
+type SESv2SendEmailAPI interface {
+	SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error)
+}
+
+// DynamoDBBatchGetItemAPI provides a unit-testable interface to access the DynamoDB BatchGetItem API.
+type DynamoDBBatchGetItemAPI interface {
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+}
+
+// DynamoDBPutItemAPI provides a unit-testable interface to access the DynamoDB PutItem API.
+type DynamoDBPutItemAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoDBGetItemAPI provides a unit-testable interface to access the DynamoDB GetItem API.
+type DynamoDBGetItemAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// DynamoDBDeleteItemAPI provides a unit-testable interface to access the DynamoDB DeleteItem API.
+type DynamoDBDeleteItemAPI interface {
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// DynamoDBUpdateItemAPI provides a unit-testable interface to access the DynamoDB UpdateItem API.
+type DynamoDBUpdateItemAPI interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// DynamoDBQueryAPI provides a unit-testable interface to access the DynamoDB Query API.
+type DynamoDBQueryAPI interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// Handler provides the Lambda implementation to subscribe contacts to Best-Seller lists.
 type Handler struct {
-	ses             SESv2CreateContactAPI
-	contactListName string
+	ses                  SESv2CreateContactAPI
+	deleteContact        SESv2DeleteContactAPI
+	sendEmail            SESv2SendEmailAPI
+	batchGetItem         DynamoDBBatchGetItemAPI
+	putItem              DynamoDBPutItemAPI
+	getItem              DynamoDBGetItemAPI
+	updateItem           DynamoDBUpdateItemAPI
+	deleteItem           DynamoDBDeleteItemAPI
+	query                DynamoDBQueryAPI
+	contactListName      string
+	listsTableName       string
+	subscribersTableName string
+	pendingTableName     string
+	historyTableName     string
+	signer               *token.Signer
+	fromEmailAddr        string
+	confirmationTTL      time.Duration
+	blocklist            Blocklist
 }
 
-// New creates an instance of Handler that will subscribe clients to `contactListName` by creating a contact.
-func New(ses SESv2CreateContactAPI, contactListName string) *Handler {
-	return &Handler{ses, contactListName}
+// Config provides configuration options for a Handler.
+type Config struct {
+	CreateContactAPI SESv2CreateContactAPI
+	DeleteContactAPI SESv2DeleteContactAPI
+	SendEmailAPI     SESv2SendEmailAPI
+	BatchGetItemAPI  DynamoDBBatchGetItemAPI
+	PutItemAPI       DynamoDBPutItemAPI
+	GetItemAPI       DynamoDBGetItemAPI
+	UpdateItemAPI    DynamoDBUpdateItemAPI
+	DeleteItemAPI    DynamoDBDeleteItemAPI
+	QueryAPI         DynamoDBQueryAPI
+
+	// ContactListName is the SES contact list that contacts are created in.
+	ContactListName string
+
+	// ListsTableName is the table of Best-Seller lists that requested lists are validated against.
+	ListsTableName string
+
+	// SubscribersTableName is the table that subscriber preferences are stored in.
+	SubscribersTableName string
+
+	// PendingTableName is the table that subscriptions waiting for confirmation are stored in.
+	PendingTableName string
+
+	// HistoryTableName is the table that the books sent to each subscriber are recorded in.
+	HistoryTableName string
+
+	// Signer signs the tokens in confirmation links and verifies the tokens
+	// used to manage a subscription.
+	Signer *token.Signer
+
+	// FromEmailAddress is the sender of confirmation emails.
+	FromEmailAddress string
+
+	// ConfirmationTTL is how long a confirmation link can be used after subscribing.
+	ConfirmationTTL time.Duration
+
+	// Blocklist contains the addresses that are rejected by Subscribe.
+	Blocklist Blocklist
 }
 
-// Subscribe creates a contact for the email associated with the request.
+// New creates an instance of Handler that will subscribe clients to `cfg.ContactListName` by creating a contact.
+func New(cfg Config) *Handler {
+	return &Handler{
+		ses:                  cfg.CreateContactAPI,
+		deleteContact:        cfg.DeleteContactAPI,
+		sendEmail:            cfg.SendEmailAPI,
+		batchGetItem:         cfg.BatchGetItemAPI,
+		putItem:              cfg.PutItemAPI,
+		getItem:              cfg.GetItemAPI,
+		updateItem:           cfg.UpdateItemAPI,
+		deleteItem:           cfg.DeleteItemAPI,
+		query:                cfg.QueryAPI,
+		contactListName:      cfg.ContactListName,
+		listsTableName:       cfg.ListsTableName,
+		subscribersTableName: cfg.SubscribersTableName,
+		pendingTableName:     cfg.PendingTableName,
+		historyTableName:     cfg.HistoryTableName,
+		signer:               cfg.Signer,
+		fromEmailAddr:        cfg.FromEmailAddress,
+		confirmationTTL:      cfg.ConfirmationTTL,
+		blocklist:            cfg.Blocklist,
+	}
+}
+
+// Route calls the method that handles the request's route.
+func (h *Handler) Route(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	switch req.RouteKey {
+	case "PUT /subscribe":
+		return h.Subscribe(req)
+	case "GET /subscribe/confirm":
+		return h.Confirm(req)
+	case "GET /subscribe":
+		return h.GetSubscription(req)
+	case "PATCH /subscribe":
+		return h.UpdateSubscription(req)
+	case "DELETE /subscribe":
+		return h.Unsubscribe(req)
+	case "GET /subscriptions/history":
+		return h.GetHistory(req)
+	}
+	return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusNotFound}, nil
+}
+
+// SubscribeResponse contains the response data from calling Subscribe.
+type SubscribeResponse struct {
+	Email        string      `json:"email,omitempty"`
+	Lists        []string    `json:"lists,omitempty"`
+	Paused       bool        `json:"paused,omitempty"`
+	PausedUntil  string      `json:"paused_until,omitempty"`
+	Frequency    string      `json:"frequency,omitempty"`
+	DeliveryDay  string      `json:"delivery_day,omitempty"`
+	TimeZone     string      `json:"time_zone,omitempty"`
+	DeliveryHour *int        `json:"delivery_hour,omitempty"`
+	Errors       []ErrorInfo `json:"errors,omitempty"`
+}
+
+// ErrorInfo contains information about errors in a request that resulted in an invalid response.
+type ErrorInfo struct {
+	Field    string `json:"field,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Location string `json:"location"`
+}
+
+var listRegexp = regexp.MustCompile(`^[a-zA-Z]+(-[a-zA-Z]+)*$`)
+
+// maxLists is the most lists that can be requested at once, which is
+// also the most keys a single BatchGetItem call can read.
+const maxLists = 100
+
+type requestParams struct {
+	subscriber books.Subscriber
+}
+
+// Subscribe stores a pending subscription for the email associated with the request
+// and sends the email a link to confirm it. The contact isn't created until the
+// link is followed, so that only the owner of an address can subscribe it.
 func (h *Handler) Subscribe(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	email, ok := req.QueryStringParameters["email"]
-	if !ok || len(email) == 0 {
-		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusBadRequest}, nil
-	}
-
-	// TODO
-	// - Keep a table of subscribers separate from SES and generate verification link?
-	// - Allow subscribing based on list names (use AttributesData or DDB)
-	_, err := h.ses.CreateContact(context.TODO(), &sesv2.CreateContactInput{
-		ContactListName: aws.String(h.contactListName),
-		EmailAddress:    aws.String(email),
-		TopicPreferences: []types.TopicPreference{
-			{
-				TopicName:          aws.String("Books"),
-				SubscriptionStatus: types.SubscriptionStatusOptIn,
-			},
-		},
+	input, errs := h.validateReq(req)
+	if len(errs) != 0 {
+		return response(http.StatusBadRequest, SubscribeResponse{Errors: errs})
+	}
+
+	ctx := context.TODO()
+
+	sub := input.subscriber
+
+	unknown, err := h.findUnknownLists(ctx, sub.Lists)
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error validating lists: %w", err)
+	}
+	if len(unknown) != 0 {
+		for _, list := range unknown {
+			errs = append(errs, ErrorInfo{"lists", fmt.Sprintf("unknown list %s", list), "query"})
+		}
+		return response(http.StatusBadRequest, SubscribeResponse{Errors: errs})
+	}
+
+	id, err := newTokenID()
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, err
+	}
+	expiration := time.Now().Add(h.confirmationTTL).Unix()
+
+	item, err := attributevalue.MarshalMap(pendingSubscription{
+		TokenID:    id,
+		Subscriber: sub,
+		Expiration: expiration,
 	})
 	if err != nil {
-		log.Printf("error in CreateContact: %v", err)
-		var alreadyExists *types.AlreadyExistsException
-		if errors.As(err, &alreadyExists) {
-			return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusConflict}, nil
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("could not marshal pending subscription: %w", err)
+	}
+
+	_, err = h.putItem.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &h.pendingTableName,
+		Item:      item,
+	})
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error saving pending subscription: %w", err)
+	}
+
+	tok, err := h.signer.Sign(token.Claims{
+		ID:        id,
+		Subject:   sub.EmailAddress,
+		Purpose:   token.PurposeConfirm,
+		ExpiresAt: expiration,
+	})
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("could not sign token: %w", err)
+	}
+
+	err = h.sendConfirmation(ctx, sub.EmailAddress, confirmURL(req, tok))
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error sending confirmation email: %w", err)
+	}
+
+	return response(http.StatusAccepted, subscriberResponse(sub))
+}
+
+// findUnknownLists returns the names in lists that don't have an item in the lists table.
+func (h *Handler) findUnknownLists(ctx context.Context, lists []string) ([]string, error) {
+	if len(lists) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]map[string]ddbtypes.AttributeValue, len(lists))
+	for i, list := range lists {
+		keys[i] = map[string]ddbtypes.AttributeValue{
+			"EncodedName": &ddbtypes.AttributeValueMemberS{Value: list},
+		}
+	}
+
+	found := map[string]bool{}
+	requestItems := map[string]ddbtypes.KeysAndAttributes{
+		h.listsTableName: {
+			Keys:                 keys,
+			ProjectionExpression: aws.String("EncodedName"),
+		},
+	}
+
+	// Small requests are rarely left unprocessed, so only make a few attempts
+	// before giving up instead of backing off.
+	const maxAttempts = 3
+	for attempt := 0; attempt < maxAttempts && len(requestItems) != 0; attempt++ {
+		out, err := h.batchGetItem.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: requestItems})
+		if err != nil {
+			return nil, fmt.Errorf("error doing BatchGetItem: %w", err)
+		}
+
+		var data []books.BestSellerList
+		if err := attributevalue.UnmarshalListOfMaps(out.Responses[h.listsTableName], &data); err != nil {
+			return nil, fmt.Errorf("could not unmarshal lists: %w", err)
+		}
+		for _, list := range data {
+			found[list.EncodedName] = true
+		}
+
+		requestItems = out.UnprocessedKeys
+	}
+	if len(requestItems) != 0 {
+		return nil, errors.New("lists table did not process every key")
+	}
+
+	var unknown []string
+	for _, list := range lists {
+		if !found[list] {
+			unknown = append(unknown, list)
+		}
+	}
+	return unknown, nil
+}
+
+func (h *Handler) validateReq(req events.APIGatewayV2HTTPRequest) (requestParams, []ErrorInfo) {
+	reqInput := requestParams{}
+	var errors []ErrorInfo
+
+	email, ok := req.QueryStringParameters["email"]
+	if !ok || len(strings.TrimSpace(email)) == 0 {
+		errors = append(errors, ErrorInfo{"email", "email is required", "query"})
+	} else if normalized, err := normalizeEmail(email, h.blocklist); err != nil {
+		errors = append(errors, ErrorInfo{"email", err.Error(), "query"})
+	} else {
+		reqInput.subscriber.EmailAddress = normalized
+	}
+
+	if qLists, ok := req.QueryStringParameters["lists"]; ok {
+		lists, errs := parseLists(qLists)
+		reqInput.subscriber.Lists = lists
+		errors = append(errors, errs...)
+	}
+
+	sched, errs := parseSchedule(req.QueryStringParameters)
+	if len(errs) == 0 {
+		errs = sched.apply(&reqInput.subscriber)
+	}
+	errors = append(errors, errs...)
+
+	return reqInput, errors
+}
+
+// parseLists splits the value of the lists query parameter into unique list names.
+func parseLists(qLists string) ([]string, []ErrorInfo) {
+	var lists []string
+	var errors []ErrorInfo
+
+	// API Gateway joins repeated query parameters with commas, so "lists=a,b"
+	// and "lists=a&lists=b" are equivalent.
+	seen := map[string]bool{}
+	for _, list := range strings.Split(qLists, ",") {
+		list = strings.TrimSpace(list)
+		if seen[list] {
+			continue
+		}
+		seen[list] = true
+		if listRegexp.MatchString(list) {
+			lists = append(lists, list)
 		} else {
-			return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error creating contact: %w", err)
+			errors = append(errors, ErrorInfo{"lists", fmt.Sprintf("list %q must be in the format %s", list, listRegexp.String()), "query"})
 		}
 	}
+	if len(lists) > maxLists {
+		errors = append(errors, ErrorInfo{"lists", fmt.Sprintf("at most %d lists can be specified", maxLists), "query"})
+	}
+
+	return lists, errors
+}
 
-	return events.APIGatewayV2HTTPResponse{StatusCode: 200}, nil
+func response(status int, body interface{}) (events.APIGatewayV2HTTPResponse, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		err = fmt.Errorf("error marshalling response body: %w", err)
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: status,
+		Headers: map[string]string{
+			"content-type": "application/json",
+		},
+		Body: string(b),
+	}, err
 }
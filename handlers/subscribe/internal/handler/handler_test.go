@@ -1,14 +1,26 @@
 package handler
 
 import (
+	"bookoftheday/openapi"
+	"bookoftheday/token"
+	books "bookoftheday/types"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/google/go-cmp/cmp"
 )
 
 type mockSESv2CreateContactAPI struct {
@@ -45,17 +57,282 @@ func (m *mockSESv2CreateContactAPI) CreateContact(
 	return &sesv2.CreateContactOutput{}, m.apiError
 }
 
+type stubDynamoDBBatchGetItemAPI struct {
+	lists []string
+	calls int
+}
+
+func (s *stubDynamoDBBatchGetItemAPI) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	s.calls++
+	known := map[string]bool{}
+	for _, l := range s.lists {
+		known[l] = true
+	}
+
+	responses := map[string][]map[string]ddbtypes.AttributeValue{}
+	for table, ka := range params.RequestItems {
+		for _, key := range ka.Keys {
+			var list books.BestSellerList
+			_ = attributevalue.UnmarshalMap(key, &list)
+			if known[list.EncodedName] {
+				responses[table] = append(responses[table], key)
+			}
+		}
+	}
+	return &dynamodb.BatchGetItemOutput{Responses: responses}, nil
+}
+
+type stubSESv2DeleteContactAPI struct {
+	deleted  []string
+	apiError error
+}
+
+func (s *stubSESv2DeleteContactAPI) DeleteContact(ctx context.Context, params *sesv2.DeleteContactInput, optFns ...func(*sesv2.Options)) (*sesv2.DeleteContactOutput, error) {
+	s.deleted = append(s.deleted, *params.EmailAddress)
+	return &sesv2.DeleteContactOutput{}, s.apiError
+}
+
+type stubSESv2SendEmailAPI struct {
+	sent     []*sesv2.SendEmailInput
+	apiError error
+}
+
+func (s *stubSESv2SendEmailAPI) SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error) {
+	s.sent = append(s.sent, params)
+	return &sesv2.SendEmailOutput{}, s.apiError
+}
+
+// link returns the confirmation link from the last sent email.
+func (s *stubSESv2SendEmailAPI) link(t *testing.T) string {
+	if len(s.sent) == 0 {
+		t.Fatal("no emails were sent")
+	}
+	m := linkRegexp.FindStringSubmatch(*s.sent[len(s.sent)-1].Content.Simple.Body.Text.Data)
+	if m == nil {
+		t.Fatal("sent email did not contain a link")
+	}
+	return m[1]
+}
+
+var linkRegexp = regexp.MustCompile(`(https://\S+)`)
+
+// stubSESv2CreateContactAPI fails to create a contact that already exists.
+type stubSESv2CreateContactAPI struct {
+	created map[string]bool
+}
+
+func (s *stubSESv2CreateContactAPI) CreateContact(ctx context.Context, params *sesv2.CreateContactInput, optFns ...func(*sesv2.Options)) (*sesv2.CreateContactOutput, error) {
+	if s.created[*params.EmailAddress] {
+		return nil, &types.AlreadyExistsException{}
+	}
+	s.created[*params.EmailAddress] = true
+	return &sesv2.CreateContactOutput{}, nil
+}
+
+// fakeDynamoDB stores items in memory, keyed by table and then by the value of the table's hash key.
+type fakeDynamoDB struct {
+	items map[string]map[string]map[string]ddbtypes.AttributeValue
+
+	// putError is returned by the next PutItem of the subscribers table
+	putError error
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{items: map[string]map[string]map[string]ddbtypes.AttributeValue{
+		SubscribersTableName: {},
+		PendingTableName:     {},
+	}}
+}
+
+var hashKeys = map[string]string{
+	SubscribersTableName: "EmailAddress",
+	PendingTableName:     "TokenID",
+}
+
+func (f *fakeDynamoDB) key(table string, item map[string]ddbtypes.AttributeValue) string {
+	return item[hashKeys[table]].(*ddbtypes.AttributeValueMemberS).Value
+}
+
+// PutItem only supports attribute_exists conditions on the hash key.
+func (f *fakeDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if err := f.putError; err != nil && *params.TableName == SubscribersTableName {
+		f.putError = nil
+		return nil, err
+	}
+	key := f.key(*params.TableName, params.Item)
+	if _, ok := f.items[*params.TableName][key]; params.ConditionExpression != nil && !ok {
+		return nil, &ddbtypes.ConditionalCheckFailedException{}
+	}
+	f.items[*params.TableName][key] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	delete(f.items[*params.TableName], f.key(*params.TableName, params.Key))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: f.items[*params.TableName][f.key(*params.TableName, params.Key)]}, nil
+}
+
+// UpdateItem only supports marking a pending subscription as confirmed.
+func (f *fakeDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	item := f.items[*params.TableName][f.key(*params.TableName, params.Key)]
+	if confirmed, ok := item["Confirmed"].(*ddbtypes.AttributeValueMemberBOOL); !ok || confirmed.Value {
+		return nil, &ddbtypes.ConditionalCheckFailedException{}
+	}
+	item["Confirmed"] = &ddbtypes.AttributeValueMemberBOOL{Value: true}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) putSubscriber(t *testing.T, sub books.Subscriber) {
+	item, err := attributevalue.MarshalMap(sub)
+	if err != nil {
+		t.Fatalf("could not marshal subscriber: %v", err)
+	}
+	f.items[SubscribersTableName][sub.EmailAddress] = item
+}
+
+func (f *fakeDynamoDB) subscriber(t *testing.T, email string) (books.Subscriber, bool) {
+	item, ok := f.items[SubscribersTableName][email]
+	var sub books.Subscriber
+	if err := attributevalue.UnmarshalMap(item, &sub); err != nil {
+		t.Fatalf("could not unmarshal subscriber: %v", err)
+	}
+	return sub, ok
+}
+
+func (f *fakeDynamoDB) pending(t *testing.T) []pendingSubscription {
+	var subs []pendingSubscription
+	for _, item := range f.items[PendingTableName] {
+		var sub pendingSubscription
+		if err := attributevalue.UnmarshalMap(item, &sub); err != nil {
+			t.Fatalf("could not unmarshal pending subscription: %v", err)
+		}
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// fakeHistoryQueryAPI queries delivery records in the same order as the history table's
+// ContactDateSentIndex.
+type fakeHistoryQueryAPI struct {
+	records []books.DeliveryRecord
+}
+
+func (f *fakeHistoryQueryAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	email := params.ExpressionAttributeValues[":email"].(*ddbtypes.AttributeValueMemberS).Value
+
+	var matching []books.DeliveryRecord
+	for _, r := range f.records {
+		if r.ContactEmail == email {
+			matching = append(matching, r)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		if matching[i].DateSent != matching[j].DateSent {
+			return matching[i].DateSent > matching[j].DateSent
+		}
+		return matching[i].PrimaryISBN13 > matching[j].PrimaryISBN13
+	})
+
+	if params.ExclusiveStartKey != nil {
+		isbn := params.ExclusiveStartKey["PrimaryISBN13"].(*ddbtypes.AttributeValueMemberS).Value
+		for i, r := range matching {
+			if r.PrimaryISBN13 == isbn {
+				matching = matching[i+1:]
+				break
+			}
+		}
+	}
+
+	out := &dynamodb.QueryOutput{}
+	if int(*params.Limit) < len(matching) {
+		matching = matching[:*params.Limit]
+		last := matching[len(matching)-1]
+		out.LastEvaluatedKey = map[string]ddbtypes.AttributeValue{
+			"ContactEmail":  &ddbtypes.AttributeValueMemberS{Value: last.ContactEmail},
+			"PrimaryISBN13": &ddbtypes.AttributeValueMemberS{Value: last.PrimaryISBN13},
+			"DateSent":      &ddbtypes.AttributeValueMemberS{Value: last.DateSent},
+		}
+	}
+	for _, r := range matching {
+		item, _ := attributevalue.MarshalMap(r)
+		out.Items = append(out.Items, item)
+	}
+	out.Count = int32(len(out.Items))
+	return out, nil
+}
+
+const ListsTableName = "Lists"
+const SubscribersTableName = "Subscribers"
+const PendingTableName = "PendingSubscriptions"
+const HistoryTableName = "DeliveryHistory"
+
+var signer = token.NewSigner([]byte("secret"))
+
+func newHandler(ses SESv2CreateContactAPI, se SESv2SendEmailAPI, bg DynamoDBBatchGetItemAPI, db *fakeDynamoDB) *Handler {
+	return New(Config{
+		CreateContactAPI:     ses,
+		DeleteContactAPI:     &stubSESv2DeleteContactAPI{},
+		SendEmailAPI:         se,
+		BatchGetItemAPI:      bg,
+		PutItemAPI:           db,
+		GetItemAPI:           db,
+		UpdateItemAPI:        db,
+		DeleteItemAPI:        db,
+		ContactListName:      "contacts",
+		ListsTableName:       ListsTableName,
+		SubscribersTableName: SubscribersTableName,
+		PendingTableName:     PendingTableName,
+		HistoryTableName:     HistoryTableName,
+		Signer:               signer,
+		FromEmailAddress:     "from@example.com",
+		ConfirmationTTL:      24 * time.Hour,
+		Blocklist: Blocklist{
+			LocalParts: ParseBlocklistEntries("postmaster, Admin"),
+			Domains:    ParseBlocklistEntries("mailinator.com,wegwerf-bücher.de"),
+		},
+	})
+}
+
+func subscribeRequest(query map[string]string) events.APIGatewayV2HTTPRequest {
+	return events.APIGatewayV2HTTPRequest{
+		RouteKey:              "PUT /subscribe",
+		QueryStringParameters: query,
+		RequestContext:        events.APIGatewayV2HTTPRequestContext{DomainName: "api.example.com"},
+	}
+}
+
+func confirmRequest(tok string) events.APIGatewayV2HTTPRequest {
+	return events.APIGatewayV2HTTPRequest{
+		RouteKey:              "GET /subscribe/confirm",
+		QueryStringParameters: map[string]string{"token": tok},
+	}
+}
+
+// tokenFromLink returns the token query parameter of a confirmation link.
+func tokenFromLink(t *testing.T, link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("could not parse link %s: %v", link, err)
+	}
+	if u.Host != "api.example.com" || u.Path != "/subscribe/confirm" {
+		t.Errorf("unexpected link: got %s; expected it to be for api.example.com/subscribe/confirm", link)
+	}
+	return u.Query().Get("token")
+}
+
 func TestHandler(t *testing.T) {
 	testCases := []struct {
 		email          string
-		clName         string
 		apiError       error
 		expectedStatus int
 	}{
-		{"email1@example.com", "contacts", nil, 200},
-		{"", "contacts", nil, 400},
-		{"email2@example.com", "contacts", errors.New("error invalid"), 500},
-		{"email3@example.com", "contacts", fmt.Errorf("error: %w", &types.AlreadyExistsException{}), 409},
+		{"email1@example.com", nil, 202},
+		{"", nil, 400},
+		{"email2@example.com", errors.New("error invalid"), 500},
 	}
 
 	for _, tc := range testCases {
@@ -66,22 +343,699 @@ func TestHandler(t *testing.T) {
 			expectErrorDesc = ""
 		}
 		t.Run(fmt.Sprintf("error status %d with email %s%s", tc.expectedStatus, tc.email, expectErrorDesc), func(t *testing.T) {
-			m := &mockSESv2CreateContactAPI{t, tc.apiError}
-			h := New(m, tc.clName)
-			out, err := h.Subscribe(events.APIGatewayV2HTTPRequest{
-				QueryStringParameters: map[string]string{
-					"email": tc.email,
-				},
-			})
+			se := &stubSESv2SendEmailAPI{apiError: tc.apiError}
+			db := newFakeDynamoDB()
+			h := newHandler(&mockSESv2CreateContactAPI{t, nil}, se, &stubDynamoDBBatchGetItemAPI{}, db)
+			out, err := h.Route(subscribeRequest(map[string]string{
+				"email": tc.email,
+			}))
+
+			// Should pass through SDK errors
+			if err != nil && !errors.Is(err, tc.apiError) {
+				t.Errorf("unexpected error value: got %v; expected %v", err, tc.apiError)
+			}
+
+			if out.StatusCode != tc.expectedStatus {
+				t.Errorf("unexpected StatusCode value: got %d; expected %d", out.StatusCode, tc.expectedStatus)
+			}
+
+			// Contacts are only created by confirming
+			if _, ok := db.subscriber(t, tc.email); ok {
+				t.Errorf("subscriber %s was saved before confirming", tc.email)
+			}
+		})
+	}
+
+	t.Run("unknown route returns 404", func(t *testing.T) {
+		h := newHandler(&mockSESv2CreateContactAPI{t, nil}, &stubSESv2SendEmailAPI{}, &stubDynamoDBBatchGetItemAPI{}, newFakeDynamoDB())
+		out, err := h.Route(events.APIGatewayV2HTTPRequest{RouteKey: "GET /unknown"})
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		if out.StatusCode != 404 {
+			t.Errorf("unexpected StatusCode value: got %d; expected 404", out.StatusCode)
+		}
+	})
+}
+
+func TestEmail(t *testing.T) {
+	testCases := []struct {
+		email    string
+		expected string
+	}{
+		{"email@example.com", "email@example.com"},
+		{"  Email.Name+Tag@Example.COM ", "email.name+tag@example.com"},
+		{"email@bücher.de", "email@xn--bcher-kva.de"},
+		{"email@XN--BCHER-KVA.de", "email@xn--bcher-kva.de"},
+		{"email", ""},
+		{"email@", ""},
+		{"@example.com", ""},
+		{"email@example", ""},
+		{"email@@example.com", ""},
+		{"email@exa mple.com", ""},
+		{"Name <email@example.com>", ""},
+		{"<email@example.com>", ""},
+		{"émail@example.com", ""},
+		{"email@-example.com", ""},
+		{"postmaster@example.com", ""},
+		{"ADMIN@example.com", ""},
+		{"email@mailinator.com", ""},
+		{"email@sub.mailinator.com", ""},
+		{"email@notmailinator.com", "email@notmailinator.com"},
+		{"email@wegwerf-bücher.de", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("email %q", tc.email), func(t *testing.T) {
+			db := newFakeDynamoDB()
+			h := newHandler(&mockSESv2CreateContactAPI{t, nil}, &stubSESv2SendEmailAPI{}, &stubDynamoDBBatchGetItemAPI{}, db)
+
+			out, err := h.Subscribe(subscribeRequest(map[string]string{"email": tc.email}))
+			if err != nil {
+				t.Fatalf("unexpected error: got %v; expected nil", err)
+			}
+
+			var body SubscribeResponse
+			if err := json.Unmarshal([]byte(out.Body), &body); err != nil {
+				t.Fatalf("could not unmarshal response body: %v", err)
+			}
+
+			if tc.expected == "" {
+				if out.StatusCode != 400 {
+					t.Errorf("unexpected StatusCode value: got %d; expected 400", out.StatusCode)
+				}
+				if len(body.Errors) != 1 || body.Errors[0].Field != "email" || body.Errors[0].Location != "query" {
+					t.Errorf("unexpected errors: got %v; expected one error for email in query", body.Errors)
+				}
+				return
+			}
+
+			if out.StatusCode != 202 {
+				t.Fatalf("unexpected StatusCode value: got %d; expected 202", out.StatusCode)
+			}
+			if body.Email != tc.expected {
+				t.Errorf("unexpected email in response: got %s; expected %s", body.Email, tc.expected)
+			}
+			if pending := db.pending(t); len(pending) != 1 || pending[0].EmailAddress != tc.expected {
+				t.Errorf("unexpected pending subscriptions: got %v; expected one for %s", pending, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	testCases := []struct {
+		query          map[string]string
+		expectedStatus int
+		expected       books.Subscriber
+	}{
+		{map[string]string{}, 202, books.Subscriber{}},
+		{map[string]string{"frequency": "daily"}, 202, books.Subscriber{Frequency: "daily"}},
+		{map[string]string{"frequency": "Weekly", "delivery_day": "Friday"}, 202, books.Subscriber{Frequency: "weekly", DeliveryDay: "friday"}},
+		{map[string]string{"frequency": "weekly"}, 400, books.Subscriber{}},
+		{map[string]string{"frequency": "daily", "delivery_day": "friday"}, 400, books.Subscriber{}},
+		{map[string]string{"delivery_day": "friday"}, 400, books.Subscriber{}},
+		{map[string]string{"frequency": "monthly"}, 400, books.Subscriber{}},
+		{map[string]string{"frequency": "weekly", "delivery_day": "someday"}, 400, books.Subscriber{}},
+		{map[string]string{"time_zone": "America/Los_Angeles", "delivery_hour": "7"}, 202, books.Subscriber{TimeZone: "America/Los_Angeles", DeliveryHour: 7}},
+		{map[string]string{"time_zone": "Asia/Kolkata"}, 202, books.Subscriber{TimeZone: "Asia/Kolkata"}},
+		{map[string]string{"time_zone": "Mars/Olympus_Mons"}, 400, books.Subscriber{}},
+		{map[string]string{"time_zone": "Local"}, 400, books.Subscriber{}},
+		{map[string]string{"delivery_hour": "24"}, 400, books.Subscriber{}},
+		{map[string]string{"delivery_hour": "noon"}, 400, books.Subscriber{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v status %d", tc.query, tc.expectedStatus), func(t *testing.T) {
+			email := "email@example.com"
+			query := map[string]string{"email": email}
+			for k, v := range tc.query {
+				query[k] = v
+			}
+			db := newFakeDynamoDB()
+			h := newHandler(&mockSESv2CreateContactAPI{t, nil}, &stubSESv2SendEmailAPI{}, &stubDynamoDBBatchGetItemAPI{}, db)
+
+			out, err := h.Subscribe(subscribeRequest(query))
+			if err != nil {
+				t.Fatalf("unexpected error: got %v; expected nil", err)
+			}
+			if out.StatusCode != tc.expectedStatus {
+				t.Errorf("unexpected StatusCode value: got %d; expected %d", out.StatusCode, tc.expectedStatus)
+			}
+
+			pending := db.pending(t)
+			if tc.expectedStatus != 202 {
+				if len(pending) != 0 {
+					t.Errorf("saved %d pending subscriptions for invalid request; expected 0", len(pending))
+				}
+				return
+			}
+			tc.expected.EmailAddress = email
+			if len(pending) != 1 {
+				t.Fatalf("saved %d pending subscriptions; expected 1", len(pending))
+			}
+			if diff := cmp.Diff(tc.expected, pending[0].Subscriber); diff != "" {
+				t.Errorf("pending subscription has wrong preferences (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLists(t *testing.T) {
+	known := []string{"hardcover-fiction", "hardcover-nonfiction", "manga"}
+	testCases := []struct {
+		lists          string
+		expectedStatus int
+		expectedLists  []string
+		errorCount     int
+	}{
+		{"hardcover-fiction", 202, []string{"hardcover-fiction"}, 0},
+		{"hardcover-fiction,hardcover-nonfiction", 202, []string{"hardcover-fiction", "hardcover-nonfiction"}, 0},
+		{"hardcover-fiction, hardcover-fiction", 202, []string{"hardcover-fiction"}, 0},
+		{"hardcover-fiction,unknown-list", 400, nil, 1},
+		{"unknown-list,another-list", 400, nil, 2},
+		{"hardco213er&@1no55fiction", 400, nil, 1},
+		{"", 400, nil, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("lists %q status %d", tc.lists, tc.expectedStatus), func(t *testing.T) {
+			email := "email@example.com"
+			bg := &stubDynamoDBBatchGetItemAPI{lists: known}
+			db := newFakeDynamoDB()
+			h := newHandler(&mockSESv2CreateContactAPI{t, nil}, &stubSESv2SendEmailAPI{}, bg, db)
+
+			out, err := h.Subscribe(subscribeRequest(map[string]string{
+				"email": email,
+				"lists": tc.lists,
+			}))
+			if err != nil {
+				t.Fatalf("unexpected error: got %v; expected nil", err)
+			}
+
+			if out.StatusCode != tc.expectedStatus {
+				t.Errorf("unexpected StatusCode value: got %d; expected %d", out.StatusCode, tc.expectedStatus)
+			}
+
+			var body SubscribeResponse
+			if err := json.Unmarshal([]byte(out.Body), &body); err != nil {
+				t.Fatalf("could not unmarshal response body: %v", err)
+			}
+
+			if len(body.Errors) != tc.errorCount {
+				t.Errorf("unexpected number of errors: got %d (%v); expected %d", len(body.Errors), body.Errors, tc.errorCount)
+			}
+			for _, e := range body.Errors {
+				if e.Field != "lists" {
+					t.Errorf("unexpected error field: got %s; expected lists", e.Field)
+				}
+			}
+
+			pending := db.pending(t)
+			if tc.expectedStatus != 202 {
+				if len(pending) != 0 {
+					t.Errorf("saved %d pending subscriptions for invalid request; expected 0", len(pending))
+				}
+				return
+			}
+			if len(pending) != 1 {
+				t.Fatalf("saved %d pending subscriptions; expected 1", len(pending))
+			}
+			if diff := cmp.Diff(tc.expectedLists, pending[0].Lists); diff != "" {
+				t.Errorf("pending subscription has wrong lists (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	email := "email@example.com"
+	lists := []string{"manga"}
+
+	// subscribe returns the token from the confirmation email
+	subscribe := func(t *testing.T, h *Handler, se *stubSESv2SendEmailAPI) string {
+		out, err := h.Route(subscribeRequest(map[string]string{"email": email, "lists": "manga"}))
+		if err != nil || out.StatusCode != 202 {
+			t.Fatalf("Subscribe: got status %d and error %v; expected 202 and nil", out.StatusCode, err)
+		}
+		if got := se.sent[len(se.sent)-1].Destination.ToAddresses; len(got) != 1 || got[0] != email {
+			t.Errorf("confirmation sent to %v; expected %s", got, email)
+		}
+		return tokenFromLink(t, se.link(t))
+	}
+
+	t.Run("creates contact and subscriber once", func(t *testing.T) {
+		se := &stubSESv2SendEmailAPI{}
+		db := newFakeDynamoDB()
+		h := newHandler(&mockSESv2CreateContactAPI{t, nil}, se, &stubDynamoDBBatchGetItemAPI{lists: lists}, db)
+		tok := subscribe(t, h, se)
+
+		out, err := h.Route(confirmRequest(tok))
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		if out.StatusCode != 200 {
+			t.Fatalf("unexpected StatusCode value: got %d; expected 200", out.StatusCode)
+		}
+
+		sub, ok := db.subscriber(t, email)
+		if !ok {
+			t.Fatalf("subscriber %s was not saved", email)
+		}
+		if diff := cmp.Diff(books.Subscriber{EmailAddress: email, Lists: lists}, sub); diff != "" {
+			t.Errorf("fields mismatch in subscriber (-want +got):\n%s", diff)
+		}
+
+		out, err = h.Route(confirmRequest(tok))
+		if err != nil {
+			t.Fatalf("unexpected error reusing token: got %v; expected nil", err)
+		}
+		if out.StatusCode != 409 {
+			t.Errorf("unexpected StatusCode value reusing token: got %d; expected 409", out.StatusCode)
+		}
+	})
+
+	expired, _ := signer.Sign(token.Claims{ID: "id", Subject: email, Purpose: token.PurposeConfirm, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	unknown, _ := signer.Sign(token.Claims{ID: "unknown", Subject: email, Purpose: token.PurposeConfirm, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	wrongPurpose, _ := signer.Sign(token.Claims{ID: "id", Subject: email, Purpose: "other", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	expiredManage, _ := signer.Sign(token.Claims{Subject: email, Purpose: token.PurposeManage, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	forged, _ := token.NewSigner([]byte("forged")).Sign(token.Claims{ID: "id", Subject: email, Purpose: token.PurposeConfirm, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	testCases := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{"missing token", "", 400},
+		{"malformed token", "token", 401},
+		{"forged token", forged, 401},
+		{"token for other purpose", wrongPurpose, 401},
+		{"expired manage token", expiredManage, 401},
+		{"expired token", expired, 410},
+		{"token without pending subscription", unknown, 410},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s returns %d", tc.name, tc.expectedStatus), func(t *testing.T) {
+			db := newFakeDynamoDB()
+			h := newHandler(&mockSESv2CreateContactAPI{t, nil}, &stubSESv2SendEmailAPI{}, &stubDynamoDBBatchGetItemAPI{}, db)
+
+			out, err := h.Route(confirmRequest(tc.token))
+			if err != nil {
+				t.Fatalf("unexpected error: got %v; expected nil", err)
+			}
+			if out.StatusCode != tc.expectedStatus {
+				t.Errorf("unexpected StatusCode value: got %d; expected %d", out.StatusCode, tc.expectedStatus)
+			}
+
+			var body SubscribeResponse
+			if err := json.Unmarshal([]byte(out.Body), &body); err != nil {
+				t.Fatalf("could not unmarshal response body: %v", err)
+			}
+			if len(body.Errors) != 1 || body.Errors[0].Field != "token" {
+				t.Errorf("unexpected errors: got %v; expected one error for token", body.Errors)
+			}
+		})
+	}
+
+	apiErrors := []struct {
+		apiError       error
+		expectedStatus int
+	}{
+		{errors.New("error invalid"), 500},
+		// The contact was created by an earlier attempt
+		{fmt.Errorf("error: %w", &types.AlreadyExistsException{}), 200},
+	}
+	for _, tc := range apiErrors {
+		t.Run(fmt.Sprintf("CreateContact error returns %d", tc.expectedStatus), func(t *testing.T) {
+			se := &stubSESv2SendEmailAPI{}
+			db := newFakeDynamoDB()
+			h := newHandler(&mockSESv2CreateContactAPI{t, tc.apiError}, se, &stubDynamoDBBatchGetItemAPI{lists: lists}, db)
+			tok := subscribe(t, h, se)
+
+			out, err := h.Route(confirmRequest(tok))
 
 			// Should pass through SDK errors
 			if err != nil && !errors.Is(err, tc.apiError) {
 				t.Errorf("unexpected error value: got %v; expected %v", err, tc.apiError)
 			}
+			if out.StatusCode != tc.expectedStatus {
+				t.Errorf("unexpected StatusCode value: got %d; expected %d", out.StatusCode, tc.expectedStatus)
+			}
+			if _, ok := db.subscriber(t, email); ok != (tc.expectedStatus == 200) {
+				t.Errorf("subscriber %s saved: got %v; expected %v", email, ok, tc.expectedStatus == 200)
+			}
+		})
+	}
 
+	t.Run("retrying after saving the subscriber fails confirms the subscription", func(t *testing.T) {
+		se := &stubSESv2SendEmailAPI{}
+		db := newFakeDynamoDB()
+		db.putError = errors.New("error saving")
+		h := newHandler(&stubSESv2CreateContactAPI{created: map[string]bool{}}, se, &stubDynamoDBBatchGetItemAPI{lists: lists}, db)
+		tok := subscribe(t, h, se)
+
+		out, _ := h.Route(confirmRequest(tok))
+		if out.StatusCode != 500 {
+			t.Fatalf("unexpected StatusCode value: got %d; expected 500", out.StatusCode)
+		}
+
+		out, err := h.Route(confirmRequest(tok))
+		if err != nil || out.StatusCode != 200 {
+			t.Fatalf("retry: got status %d and error %v; expected 200 and nil", out.StatusCode, err)
+		}
+		if _, ok := db.subscriber(t, email); !ok {
+			t.Errorf("subscriber %s was not saved by the retry", email)
+		}
+		out, _ = h.Route(confirmRequest(tok))
+		if out.StatusCode != 409 {
+			t.Errorf("unexpected StatusCode value reusing token: got %d; expected 409", out.StatusCode)
+		}
+	})
+}
+
+func manageRequest(routeKey string, query map[string]string) events.APIGatewayV2HTTPRequest {
+	return events.APIGatewayV2HTTPRequest{RouteKey: routeKey, QueryStringParameters: query}
+}
+
+func TestManage(t *testing.T) {
+	email := "email@example.com"
+	known := []string{"hardcover-fiction", "manga"}
+	manageToken, _ := signer.Sign(token.Claims{Subject: email, Purpose: token.PurposeManage, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	expired, _ := signer.Sign(token.Claims{Subject: email, Purpose: token.PurposeManage, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	confirmToken, _ := signer.Sign(token.Claims{ID: "id", Subject: email, Purpose: token.PurposeConfirm, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	expiredConfirm, _ := signer.Sign(token.Claims{ID: "id", Subject: email, Purpose: token.PurposeConfirm, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	pausedEmail := "paused@example.com"
+	pausedToken, _ := signer.Sign(token.Claims{Subject: pausedEmail, Purpose: token.PurposeManage, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	vacationEmail := "vacation@example.com"
+	vacationToken, _ := signer.Sign(token.Claims{Subject: vacationEmail, Purpose: token.PurposeManage, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	weeklyEmail := "weekly@example.com"
+	weeklyToken, _ := signer.Sign(token.Claims{Subject: weeklyEmail, Purpose: token.PurposeManage, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	nextWeek := time.Now().UTC().AddDate(0, 0, 7).Format("2006-01-02")
+	otherToken, _ := signer.Sign(token.Claims{Subject: "other@example.com", Purpose: token.PurposeManage, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	testCases := []struct {
+		name           string
+		routeKey       string
+		query          map[string]string
+		expectedStatus int
+		expected       books.Subscriber
+	}{
+		{"get preferences", "GET /subscribe", map[string]string{"token": manageToken}, 200,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"change lists", "PATCH /subscribe", map[string]string{"token": manageToken, "lists": "hardcover-fiction,manga"}, 200,
+			books.Subscriber{EmailAddress: email, Lists: []string{"hardcover-fiction", "manga"}}},
+		{"clear lists", "PATCH /subscribe", map[string]string{"token": manageToken, "lists": ""}, 200,
+			books.Subscriber{EmailAddress: email}},
+		{"pause", "PATCH /subscribe", map[string]string{"token": manageToken, "paused": "true"}, 200,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}, Paused: true}},
+		{"pause until date", "PATCH /subscribe", map[string]string{"token": manageToken, "paused_until": nextWeek}, 200,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}, PausedUntil: nextWeek}},
+		{"pause paused contact until date", "PATCH /subscribe", map[string]string{"token": pausedToken, "paused_until": nextWeek}, 200,
+			books.Subscriber{EmailAddress: pausedEmail, PausedUntil: nextWeek}},
+		{"pause indefinitely until date", "PATCH /subscribe", map[string]string{"token": manageToken, "paused": "true", "paused_until": nextWeek}, 400,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"resume", "PATCH /subscribe", map[string]string{"token": pausedToken, "paused": "false"}, 200,
+			books.Subscriber{EmailAddress: pausedEmail}},
+		{"resume before date", "PATCH /subscribe", map[string]string{"token": vacationToken, "paused": "false"}, 200,
+			books.Subscriber{EmailAddress: vacationEmail}},
+		{"pause until past date", "PATCH /subscribe", map[string]string{"token": manageToken, "paused_until": "2022-06-01"}, 400,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"pause until invalid date", "PATCH /subscribe", map[string]string{"token": manageToken, "paused_until": "next week"}, 400,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"change to weekly", "PATCH /subscribe", map[string]string{"token": manageToken, "frequency": "weekly", "delivery_day": "monday"}, 200,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}, Frequency: "weekly", DeliveryDay: "monday"}},
+		{"change to weekly without day", "PATCH /subscribe", map[string]string{"token": manageToken, "frequency": "weekly"}, 400,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"change delivery day", "PATCH /subscribe", map[string]string{"token": weeklyToken, "delivery_day": "sunday"}, 200,
+			books.Subscriber{EmailAddress: weeklyEmail, Frequency: "weekly", DeliveryDay: "sunday"}},
+		{"change to daily", "PATCH /subscribe", map[string]string{"token": weeklyToken, "frequency": "daily"}, 200,
+			books.Subscriber{EmailAddress: weeklyEmail, Frequency: "daily"}},
+		{"change delivery time", "PATCH /subscribe", map[string]string{"token": manageToken, "time_zone": "Europe/Paris", "delivery_hour": "18"}, 200,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}, TimeZone: "Europe/Paris", DeliveryHour: 18}},
+		{"invalid delivery hour", "PATCH /subscribe", map[string]string{"token": manageToken, "delivery_hour": "-1"}, 400,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"change nothing", "PATCH /subscribe", map[string]string{"token": manageToken}, 400,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"unknown list", "PATCH /subscribe", map[string]string{"token": manageToken, "lists": "unknown-list"}, 400,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"invalid paused", "PATCH /subscribe", map[string]string{"token": manageToken, "paused": "maybe"}, 400,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"missing token", "PATCH /subscribe", map[string]string{"paused": "true"}, 401,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"confirmation token", "DELETE /subscribe", map[string]string{"token": confirmToken}, 401,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"expired confirmation token", "DELETE /subscribe", map[string]string{"token": expiredConfirm}, 401,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"expired token", "DELETE /subscribe", map[string]string{"token": expired}, 410,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+		{"not subscribed", "PATCH /subscribe", map[string]string{"token": otherToken, "paused": "true"}, 404,
+			books.Subscriber{EmailAddress: email, Lists: []string{"manga"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s returns %d", tc.name, tc.expectedStatus), func(t *testing.T) {
+			db := newFakeDynamoDB()
+			db.putSubscriber(t, books.Subscriber{EmailAddress: email, Lists: []string{"manga"}})
+			db.putSubscriber(t, books.Subscriber{EmailAddress: pausedEmail, Paused: true})
+			db.putSubscriber(t, books.Subscriber{EmailAddress: vacationEmail, PausedUntil: nextWeek})
+			db.putSubscriber(t, books.Subscriber{EmailAddress: weeklyEmail, Frequency: "weekly", DeliveryDay: "friday"})
+			h := newHandler(&mockSESv2CreateContactAPI{t, nil}, &stubSESv2SendEmailAPI{}, &stubDynamoDBBatchGetItemAPI{lists: known}, db)
+
+			out, err := h.Route(manageRequest(tc.routeKey, tc.query))
+			if err != nil {
+				t.Fatalf("unexpected error: got %v; expected nil", err)
+			}
 			if out.StatusCode != tc.expectedStatus {
 				t.Errorf("unexpected StatusCode value: got %d; expected %d", out.StatusCode, tc.expectedStatus)
 			}
+
+			sub, _ := db.subscriber(t, tc.expected.EmailAddress)
+			if diff := cmp.Diff(tc.expected, sub); diff != "" {
+				t.Errorf("fields mismatch in stored subscriber (-want +got):\n%s", diff)
+			}
+
+			var body SubscribeResponse
+			if err := json.Unmarshal([]byte(out.Body), &body); err != nil {
+				t.Fatalf("could not unmarshal response body: %v", err)
+			}
+			if tc.expectedStatus == 200 {
+				if diff := cmp.Diff(subscriberResponse(sub), body); diff != "" {
+					t.Errorf("response does not match stored subscriber (-want +got):\n%s", diff)
+				}
+			} else if len(body.Errors) == 0 {
+				t.Errorf("got no errors in response; expected at least one")
+			}
+		})
+	}
+
+	t.Run("unsubscribe deletes contact and subscriber", func(t *testing.T) {
+		db := newFakeDynamoDB()
+		db.putSubscriber(t, books.Subscriber{EmailAddress: email, Lists: []string{"manga"}})
+		h := newHandler(&mockSESv2CreateContactAPI{t, nil}, &stubSESv2SendEmailAPI{}, &stubDynamoDBBatchGetItemAPI{}, db)
+		dc := &stubSESv2DeleteContactAPI{}
+		h.deleteContact = dc
+
+		out, err := h.Route(manageRequest("DELETE /subscribe", map[string]string{"token": manageToken}))
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		if out.StatusCode != 204 {
+			t.Errorf("unexpected StatusCode value: got %d; expected 204", out.StatusCode)
+		}
+		if diff := cmp.Diff([]string{email}, dc.deleted); diff != "" {
+			t.Errorf("deleted wrong contacts (-want +got):\n%s", diff)
+		}
+		if _, ok := db.subscriber(t, email); ok {
+			t.Errorf("subscriber %s was not deleted", email)
+		}
+	})
+
+	t.Run("unsubscribe without contact returns 404", func(t *testing.T) {
+		h := newHandler(&mockSESv2CreateContactAPI{t, nil}, &stubSESv2SendEmailAPI{}, &stubDynamoDBBatchGetItemAPI{}, newFakeDynamoDB())
+		h.deleteContact = &stubSESv2DeleteContactAPI{apiError: fmt.Errorf("error: %w", &types.NotFoundException{})}
+
+		out, err := h.Route(manageRequest("DELETE /subscribe", map[string]string{"token": manageToken}))
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		if out.StatusCode != 404 {
+			t.Errorf("unexpected StatusCode value: got %d; expected 404", out.StatusCode)
+		}
+	})
+}
+
+func TestHistory(t *testing.T) {
+	email := "email@example.com"
+	manageToken, _ := signer.Sign(token.Claims{Subject: email, Purpose: token.PurposeManage, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	otherToken, _ := signer.Sign(token.Claims{Subject: "other@example.com", Purpose: token.PurposeManage, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	confirmToken, _ := signer.Sign(token.Claims{ID: "id", Subject: email, Purpose: token.PurposeConfirm, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	var records []books.DeliveryRecord
+	var newestFirst []books.BestSellerBook
+	for day := 1; day <= 5; day++ {
+		b := books.BestSellerBook{PrimaryISBN13: fmt.Sprintf("978000000000%d", day), Title: fmt.Sprintf("Day %d", day)}
+		records = append(records, books.DeliveryRecord{ContactEmail: email, DateSent: fmt.Sprintf("2022-06-0%d", day), BestSellerBook: b})
+		newestFirst = append([]books.BestSellerBook{b}, newestFirst...)
+	}
+	records = append(records, books.DeliveryRecord{ContactEmail: "other@example.com", DateSent: "2022-06-03", BestSellerBook: books.BestSellerBook{PrimaryISBN13: "9780000000009"}})
+
+	newHistoryHandler := func() *Handler {
+		h := newHandler(&mockSESv2CreateContactAPI{t, nil}, &stubSESv2SendEmailAPI{}, &stubDynamoDBBatchGetItemAPI{}, newFakeDynamoDB())
+		h.query = &fakeHistoryQueryAPI{records}
+		return h
+	}
+	get := func(t *testing.T, query map[string]string) (int, HistoryResponse) {
+		out, err := newHistoryHandler().Route(manageRequest("GET /subscriptions/history", query))
+		if err != nil {
+			t.Fatalf("unexpected error: got %v; expected nil", err)
+		}
+		var res HistoryResponse
+		if err := json.Unmarshal([]byte(out.Body), &res); err != nil {
+			t.Fatalf("could not unmarshal response body: %v", err)
+		}
+		return out.StatusCode, res
+	}
+
+	t.Run("pages through history", func(t *testing.T) {
+		var got []books.BestSellerBook
+		query := map[string]string{"token": manageToken, "limit": "2"}
+		for pages := 0; ; pages++ {
+			if pages > 3 {
+				t.Fatalf("got more pages than expected")
+			}
+			status, res := get(t, query)
+			if status != 200 {
+				t.Fatalf("unexpected StatusCode value: got %d; expected 200", status)
+			}
+			if res.Attribution != books.Attribution {
+				t.Errorf("got Attribution %q; expected %q", res.Attribution, books.Attribution)
+			}
+			if res.Count == nil || *res.Count != len(res.Books) {
+				t.Errorf("got Count %v; expected %d", res.Count, len(res.Books))
+			}
+			got = append(got, res.Books...)
+			if res.Next == "" {
+				break
+			}
+			query["cursor"] = res.Next
+		}
+		if diff := cmp.Diff(newestFirst, got); diff != "" {
+			t.Errorf("got wrong history (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("rejects cursors from another contact", func(t *testing.T) {
+		_, res := get(t, map[string]string{"token": manageToken, "limit": "1"})
+		status, _ := get(t, map[string]string{"token": otherToken, "cursor": res.Next})
+		if status != 400 {
+			t.Errorf("unexpected StatusCode value: got %d; expected 400", status)
+		}
+	})
+
+	testCases := []struct {
+		name           string
+		query          map[string]string
+		expectedStatus int
+	}{
+		{"default limit", map[string]string{"token": manageToken}, 200},
+		{"limit too large", map[string]string{"token": manageToken, "limit": "1000"}, 400},
+		{"invalid limit", map[string]string{"token": manageToken, "limit": "0"}, 400},
+		{"invalid cursor", map[string]string{"token": manageToken, "cursor": "not-a-cursor"}, 400},
+		{"missing token", map[string]string{}, 401},
+		{"confirmation token", map[string]string{"token": confirmToken}, 401},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, _ := get(t, tc.query)
+			if status != tc.expectedStatus {
+				t.Errorf("unexpected StatusCode value: got %d; expected %d", status, tc.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatalf("could not load OpenAPI document: %v", err)
+	}
+
+	routes := []string{"DELETE /subscribe", "GET /subscribe", "GET /subscribe/confirm", "GET /subscriptions/history", "PATCH /subscribe", "PUT /subscribe"}
+	if got := doc.Routes("subscribe"); !cmp.Equal(got, routes) {
+		t.Errorf("wrong routes in document: got %v; expected %v", got, routes)
+	}
+
+	schemas := map[string]interface{}{
+		"SubscribeResponse": SubscribeResponse{},
+		"HistoryResponse":   HistoryResponse{},
+		"BestSellerBook":    books.BestSellerBook{},
+		"ErrorInfo":         ErrorInfo{},
+	}
+	for name, v := range schemas {
+		if err := doc.CheckType(name, v); err != nil {
+			t.Error(err)
+		}
+	}
+
+	email := "reader@example.com"
+	manageToken, _ := signer.Sign(token.Claims{Subject: email, Purpose: token.PurposeManage, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	// Weekly delivery needs a delivery day, so that either can be tested alone
+	weekly := map[string]string{"frequency": "weekly", "delivery_day": "monday"}
+	withToken := func(query map[string]string) map[string]string {
+		q := map[string]string{"token": manageToken}
+		for k, v := range query {
+			q[k] = v
+		}
+		return q
+	}
+	bases := map[string]openapi.Request{
+		"DELETE /subscribe":          {Query: withToken(nil)},
+		"GET /subscribe":             {Query: withToken(nil)},
+		"GET /subscriptions/history": {Query: withToken(nil)},
+		"PATCH /subscribe":           {Query: withToken(weekly)},
+		"PUT /subscribe":             {Query: map[string]string{"email": email, "frequency": "weekly", "delivery_day": "monday"}},
+	}
+
+	for _, route := range routes {
+		t.Run(route, func(t *testing.T) {
+			op, ok := doc.Operation(route)
+			if !ok {
+				t.Fatalf("missing operation %s", route)
+			}
+			for _, name := range op.ResponseSchemas() {
+				if _, ok := schemas[name]; !ok {
+					t.Errorf("response schema %s isn't checked against a type", name)
+				}
+			}
+
+			errs := op.CheckParameters(bases[route], func(r openapi.Request) ([]string, error) {
+				db := newFakeDynamoDB()
+				db.putSubscriber(t, books.Subscriber{EmailAddress: email})
+				h := newHandler(&mockSESv2CreateContactAPI{t, nil}, &stubSESv2SendEmailAPI{}, &stubDynamoDBBatchGetItemAPI{lists: []string{"hardcover-fiction"}}, db)
+				h.query = &fakeHistoryQueryAPI{}
+				res, err := h.Route(events.APIGatewayV2HTTPRequest{
+					RouteKey:              route,
+					QueryStringParameters: r.Query,
+					PathParameters:        r.Path,
+					Headers:               r.Headers,
+					RequestContext:        events.APIGatewayV2HTTPRequestContext{DomainName: "api.example.com"},
+				})
+				if err != nil || res.StatusCode < 400 {
+					return nil, err
+				}
+				var body SubscribeResponse
+				if err := json.Unmarshal([]byte(res.Body), &body); err != nil {
+					return nil, err
+				}
+				var fields []string
+				for _, e := range body.Errors {
+					fields = append(fields, e.Field)
+				}
+				return fields, nil
+			})
+			for _, err := range errs {
+				t.Error(err)
+			}
 		})
 	}
 }
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	books "bookoftheday/types"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// HistoryResponse contains the response data from calling GetHistory. It has the
+// same shape as the response of the books API.
+type HistoryResponse struct {
+	Count       *int                   `json:"count,omitempty"`
+	Attribution string                 `json:"attribution,omitempty"`
+	Books       []books.BestSellerBook `json:"books,omitempty"`
+
+	// Next is passed in the cursor parameter to get the next page of books. It is
+	// empty on the last page.
+	Next   string      `json:"next,omitempty"`
+	Errors []ErrorInfo `json:"errors,omitempty"`
+}
+
+// defaultHistoryLimit is the number of books in a page of history when there is no
+// user-specified limit. maxHistoryLimit is the most that can be requested.
+const (
+	defaultHistoryLimit int32 = 20
+	maxHistoryLimit     int32 = 100
+)
+
+// historyIndexName is the index of the history table that sorts each contact's
+// deliveries by DateSent.
+const historyIndexName = "ContactDateSentIndex"
+
+// historyCursorKeys are the attributes of a LastEvaluatedKey from historyIndexName.
+var historyCursorKeys = []string{"ContactEmail", "PrimaryISBN13", "DateSent"}
+
+// encodeHistoryCursor returns an opaque cursor for the key that a page of history ended at.
+func encodeHistoryCursor(key map[string]ddbtypes.AttributeValue) (string, error) {
+	var values map[string]string
+	if err := attributevalue.UnmarshalMap(key, &values); err != nil {
+		return "", fmt.Errorf("could not unmarshal last evaluated key: %w", err)
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeHistoryCursor returns the key to continue a page of email's history from.
+// The second return value is false if the cursor is malformed or for another contact.
+func decodeHistoryCursor(cursor, email string) (map[string]ddbtypes.AttributeValue, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, false
+	}
+	var values map[string]string
+	if err := json.Unmarshal(b, &values); err != nil || len(values) != len(historyCursorKeys) {
+		return nil, false
+	}
+
+	key := map[string]ddbtypes.AttributeValue{}
+	for _, k := range historyCursorKeys {
+		v, ok := values[k]
+		if !ok {
+			return nil, false
+		}
+		key[k] = &ddbtypes.AttributeValueMemberS{Value: v}
+	}
+	if values["ContactEmail"] != email {
+		return nil, false
+	}
+	return key, true
+}
+
+// GetHistory returns the books that were sent to the subscriber that the request's
+// token was issued for, most recent first.
+func (h *Handler) GetHistory(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	email, status, errs := h.verifyManageToken(req)
+	if len(errs) != 0 {
+		return response(status, HistoryResponse{Errors: errs})
+	}
+
+	limit := defaultHistoryLimit
+	if qLimit, ok := req.QueryStringParameters["limit"]; ok {
+		if iLimit, err := strconv.ParseInt(qLimit, 10, 32); err == nil && iLimit >= 1 && int32(iLimit) <= maxHistoryLimit {
+			limit = int32(iLimit)
+		} else {
+			errs = append(errs, ErrorInfo{"limit", fmt.Sprintf("limit must be an integer from 1 to %d", maxHistoryLimit), "query"})
+		}
+	}
+
+	var startKey map[string]ddbtypes.AttributeValue
+	if cursor, ok := req.QueryStringParameters["cursor"]; ok {
+		var valid bool
+		if startKey, valid = decodeHistoryCursor(cursor, email); !valid {
+			errs = append(errs, ErrorInfo{"cursor", "cursor is invalid", "query"})
+		}
+	}
+	if len(errs) != 0 {
+		return response(http.StatusBadRequest, HistoryResponse{Errors: errs})
+	}
+
+	out, err := h.query.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              &h.historyTableName,
+		IndexName:              aws.String(historyIndexName),
+		KeyConditionExpression: aws.String("ContactEmail = :email"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":email": &ddbtypes.AttributeValueMemberS{Value: email},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             &limit,
+		ExclusiveStartKey: startKey,
+	})
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("could not query history: %w", err)
+	}
+
+	var records []books.DeliveryRecord
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &records); err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("could not unmarshal history: %w", err)
+	}
+	bookList := make([]books.BestSellerBook, len(records))
+	for i, r := range records {
+		bookList[i] = r.BestSellerBook
+	}
+
+	var next string
+	if len(out.LastEvaluatedKey) != 0 {
+		next, err = encodeHistoryCursor(out.LastEvaluatedKey)
+		if err != nil {
+			return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, err
+		}
+	}
+
+	count := len(bookList)
+	return response(http.StatusOK, HistoryResponse{
+		Count:       &count,
+		Attribution: books.Attribution,
+		Books:       bookList,
+		Next:        next,
+	})
+}
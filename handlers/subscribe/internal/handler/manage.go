@@ -0,0 +1,331 @@
+package handler
+
+import (
+	"bookoftheday/token"
+	books "bookoftheday/types"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// dateLayout is the format of dates in requests and stored items.
+const dateLayout = "2006-01-02"
+
+// verifyManageToken returns the email that the request's manage token was issued for.
+// If the token isn't valid, it returns the status and errors to respond with instead.
+func (h *Handler) verifyManageToken(req events.APIGatewayV2HTTPRequest) (string, int, []ErrorInfo) {
+	tok, ok := req.QueryStringParameters["token"]
+	if !ok || len(tok) == 0 {
+		return "", http.StatusUnauthorized, []ErrorInfo{{"token", "token is required", "query"}}
+	}
+
+	claims, err := h.signer.Verify(tok, token.PurposeManage, time.Now())
+	if errors.Is(err, token.ErrExpired) {
+		return "", http.StatusGone, []ErrorInfo{{"token", "token has expired, use the link from a more recent email", "query"}}
+	}
+	if err != nil {
+		return "", http.StatusUnauthorized, []ErrorInfo{{"token", "token is invalid", "query"}}
+	}
+
+	return claims.Subject, 0, nil
+}
+
+// getSubscriber returns the subscriber for email. The second return value is false
+// if there is no subscriber for email.
+func (h *Handler) getSubscriber(ctx context.Context, email string) (books.Subscriber, bool, error) {
+	out, err := h.getItem.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &h.subscribersTableName,
+		Key: map[string]ddbtypes.AttributeValue{
+			"EmailAddress": &ddbtypes.AttributeValueMemberS{Value: email},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return books.Subscriber{}, false, fmt.Errorf("error getting subscriber: %w", err)
+	}
+	if len(out.Item) == 0 {
+		return books.Subscriber{}, false, nil
+	}
+
+	var sub books.Subscriber
+	if err := attributevalue.UnmarshalMap(out.Item, &sub); err != nil {
+		return books.Subscriber{}, false, fmt.Errorf("could not unmarshal subscriber: %w", err)
+	}
+	return sub, true, nil
+}
+
+// schedule contains the delivery schedule parameters of a request. Nil values weren't
+// in the request.
+type schedule struct {
+	frequency    *string
+	deliveryDay  *string
+	timeZone     *string
+	deliveryHour *int
+}
+
+// isSet reports whether the request had any schedule parameters.
+func (s schedule) isSet() bool {
+	return s.frequency != nil || s.deliveryDay != nil || s.timeZone != nil || s.deliveryHour != nil
+}
+
+var weekdays = map[string]bool{
+	"sunday": true, "monday": true, "tuesday": true, "wednesday": true,
+	"thursday": true, "friday": true, "saturday": true,
+}
+
+// parseSchedule validates the frequency, delivery_day, time_zone and delivery_hour
+// query parameters. An empty time_zone means UTC.
+func parseSchedule(query map[string]string) (schedule, []ErrorInfo) {
+	var s schedule
+	var errs []ErrorInfo
+
+	if f, ok := query["frequency"]; ok {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f != books.FrequencyDaily && f != books.FrequencyWeekly {
+			errs = append(errs, ErrorInfo{"frequency", fmt.Sprintf("frequency must be %s or %s", books.FrequencyDaily, books.FrequencyWeekly), "query"})
+		}
+		s.frequency = &f
+	}
+	if d, ok := query["delivery_day"]; ok {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if !weekdays[d] {
+			errs = append(errs, ErrorInfo{"delivery_day", "delivery_day must be the name of a weekday", "query"})
+		}
+		s.deliveryDay = &d
+	}
+	if tz, ok := query["time_zone"]; ok {
+		tz = strings.TrimSpace(tz)
+		// LoadLocation treats "Local" as the Lambda's own time zone
+		if _, err := time.LoadLocation(tz); err != nil || tz == "Local" {
+			errs = append(errs, ErrorInfo{"time_zone", "time_zone must be an IANA time zone name, such as America/New_York", "query"})
+		}
+		s.timeZone = &tz
+	}
+	if qHour, ok := query["delivery_hour"]; ok {
+		hour, err := strconv.Atoi(strings.TrimSpace(qHour))
+		if err != nil || hour < 0 || hour > 23 {
+			errs = append(errs, ErrorInfo{"delivery_hour", "delivery_hour must be an hour from 0 to 23", "query"})
+		}
+		s.deliveryHour = &hour
+	}
+
+	return s, errs
+}
+
+// apply sets the schedule on sub and checks that the resulting schedule is complete.
+// Changing to daily delivery clears the delivery day.
+func (s schedule) apply(sub *books.Subscriber) []ErrorInfo {
+	if s.frequency != nil {
+		sub.Frequency = *s.frequency
+		if sub.Frequency == books.FrequencyDaily {
+			sub.DeliveryDay = ""
+		}
+	}
+	if s.deliveryDay != nil {
+		sub.DeliveryDay = *s.deliveryDay
+	}
+	if s.timeZone != nil {
+		sub.TimeZone = *s.timeZone
+	}
+	if s.deliveryHour != nil {
+		sub.DeliveryHour = *s.deliveryHour
+	}
+
+	if sub.Frequency == books.FrequencyWeekly && sub.DeliveryDay == "" {
+		return []ErrorInfo{{"delivery_day", "delivery_day is required for weekly frequency", "query"}}
+	}
+	if sub.Frequency != books.FrequencyWeekly && sub.DeliveryDay != "" {
+		return []ErrorInfo{{"delivery_day", "delivery_day can only be set for weekly frequency", "query"}}
+	}
+	return nil
+}
+
+// subscriberResponse returns the response body for a subscriber's preferences.
+func subscriberResponse(sub books.Subscriber) SubscribeResponse {
+	return SubscribeResponse{
+		Email:        sub.EmailAddress,
+		Lists:        sub.Lists,
+		Paused:       sub.Paused,
+		PausedUntil:  sub.PausedUntil,
+		Frequency:    sub.Frequency,
+		DeliveryDay:  sub.DeliveryDay,
+		TimeZone:     sub.TimeZone,
+		DeliveryHour: &sub.DeliveryHour,
+	}
+}
+
+var errNotSubscribed = ErrorInfo{"token", "email is not subscribed", "query"}
+
+// GetSubscription returns the preferences of the subscriber that the request's token was issued for.
+func (h *Handler) GetSubscription(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	email, status, errs := h.verifyManageToken(req)
+	if len(errs) != 0 {
+		return response(status, SubscribeResponse{Errors: errs})
+	}
+
+	sub, ok, err := h.getSubscriber(context.TODO(), email)
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, err
+	}
+	if !ok {
+		return response(http.StatusNotFound, SubscribeResponse{Errors: []ErrorInfo{errNotSubscribed}})
+	}
+
+	return response(http.StatusOK, subscriberResponse(sub))
+}
+
+// UpdateSubscription changes the lists or delivery schedule of the subscriber that the
+// request's token was issued for, or pauses and resumes their delivery. An empty lists parameter means
+// books from any list. Delivery can be paused indefinitely with paused=true, or until
+// a date with paused_until=YYYY-MM-DD. Resuming with paused=false also clears the date.
+func (h *Handler) UpdateSubscription(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	email, status, errs := h.verifyManageToken(req)
+	if len(errs) != 0 {
+		return response(status, SubscribeResponse{Errors: errs})
+	}
+
+	qLists, hasLists := req.QueryStringParameters["lists"]
+	qPaused, hasPaused := req.QueryStringParameters["paused"]
+	qPausedUntil, hasPausedUntil := req.QueryStringParameters["paused_until"]
+	sched, errs := parseSchedule(req.QueryStringParameters)
+	if !hasLists && !hasPaused && !hasPausedUntil && !sched.isSet() {
+		return response(http.StatusBadRequest, SubscribeResponse{Errors: []ErrorInfo{{"", "lists, paused, paused_until, frequency, delivery_day, time_zone or delivery_hour is required", "query"}}})
+	}
+
+	var lists []string
+	if hasLists && len(qLists) != 0 {
+		var listErrs []ErrorInfo
+		lists, listErrs = parseLists(qLists)
+		errs = append(errs, listErrs...)
+	}
+	var paused bool
+	if hasPaused {
+		var err error
+		paused, err = strconv.ParseBool(qPaused)
+		if err != nil {
+			errs = append(errs, ErrorInfo{"paused", "paused must be true or false", "query"})
+		}
+	}
+	if hasPausedUntil && len(qPausedUntil) != 0 {
+		// A contact paused indefinitely would never resume on the date
+		if hasPaused && paused {
+			errs = append(errs, ErrorInfo{"paused_until", "paused_until can't be set when paused is true", "query"})
+		}
+		until, err := time.Parse(dateLayout, qPausedUntil)
+		if err != nil {
+			errs = append(errs, ErrorInfo{"paused_until", "paused_until must be a date in the format YYYY-MM-DD", "query"})
+		} else if !until.After(time.Now().UTC()) {
+			errs = append(errs, ErrorInfo{"paused_until", "paused_until must be in the future", "query"})
+		}
+	}
+	if len(errs) != 0 {
+		return response(http.StatusBadRequest, SubscribeResponse{Errors: errs})
+	}
+
+	ctx := context.TODO()
+
+	unknown, err := h.findUnknownLists(ctx, lists)
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error validating lists: %w", err)
+	}
+	if len(unknown) != 0 {
+		for _, list := range unknown {
+			errs = append(errs, ErrorInfo{"lists", fmt.Sprintf("unknown list %s", list), "query"})
+		}
+		return response(http.StatusBadRequest, SubscribeResponse{Errors: errs})
+	}
+
+	sub, ok, err := h.getSubscriber(ctx, email)
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, err
+	}
+	if !ok {
+		return response(http.StatusNotFound, SubscribeResponse{Errors: []ErrorInfo{errNotSubscribed}})
+	}
+
+	if hasLists {
+		sub.Lists = lists
+	}
+	if hasPaused {
+		sub.Paused = paused
+		if !paused {
+			sub.PausedUntil = ""
+		}
+	}
+	if hasPausedUntil {
+		sub.PausedUntil = qPausedUntil
+		if len(qPausedUntil) != 0 {
+			sub.Paused = false
+		}
+	}
+	if errs := sched.apply(&sub); len(errs) != 0 {
+		return response(http.StatusBadRequest, SubscribeResponse{Errors: errs})
+	}
+
+	item, err := attributevalue.MarshalMap(sub)
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("could not marshal subscriber: %w", err)
+	}
+
+	// Don't recreate the subscriber if they unsubscribed since it was read
+	_, err = h.putItem.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &h.subscribersTableName,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_exists(EmailAddress)"),
+	})
+	if err != nil {
+		var conditionFailed *ddbtypes.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return response(http.StatusNotFound, SubscribeResponse{Errors: []ErrorInfo{errNotSubscribed}})
+		}
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error saving subscriber: %w", err)
+	}
+
+	return response(http.StatusOK, subscriberResponse(sub))
+}
+
+// Unsubscribe deletes the contact and preferences of the subscriber that the request's token was issued for.
+func (h *Handler) Unsubscribe(req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	email, status, errs := h.verifyManageToken(req)
+	if len(errs) != 0 {
+		return response(status, SubscribeResponse{Errors: errs})
+	}
+
+	ctx := context.TODO()
+
+	_, err := h.deleteContact.DeleteContact(ctx, &sesv2.DeleteContactInput{
+		ContactListName: aws.String(h.contactListName),
+		EmailAddress:    aws.String(email),
+	})
+	if err != nil {
+		var notFound *types.NotFoundException
+		if errors.As(err, &notFound) {
+			return response(http.StatusNotFound, SubscribeResponse{Errors: []ErrorInfo{errNotSubscribed}})
+		}
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error deleting contact: %w", err)
+	}
+
+	_, err = h.deleteItem.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: &h.subscribersTableName,
+		Key: map[string]ddbtypes.AttributeValue{
+			"EmailAddress": &ddbtypes.AttributeValueMemberS{Value: email},
+		},
+	})
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusInternalServerError}, fmt.Errorf("error deleting subscriber: %w", err)
+	}
+
+	return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusNoContent}, nil
+}
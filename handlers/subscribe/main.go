@@ -1,14 +1,24 @@
 package main
 
 import (
+	"bookoftheday/token"
 	"context"
 	"log"
 	"os"
+	"strconv"
 	"subscribe/internal/handler"
+	"time"
+
+	// Embed the time zone database so that subscribers' time zones can be loaded
+	// regardless of what the Lambda runtime provides
+	_ "time/tzdata"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 )
 
 func main() {
@@ -17,11 +27,48 @@ func main() {
 		log.Fatalln("configuration error: " + err.Error())
 	}
 
-	client := sesv2.NewFromConfig(cfg)
+	ssmClient := ssm.NewFromConfig(cfg)
+	gpInput := &ssm.GetParameterInput{
+		Name:           aws.String(os.Getenv("SSM_PARAM_NAME")),
+		WithDecryption: true,
+	}
+
+	gpOutput, err := ssmClient.GetParameter(context.TODO(), gpInput)
+	if err != nil {
+		log.Fatalln("could not get SSM parameter: " + err.Error())
+	}
+
+	ttlHours, err := strconv.Atoi(os.Getenv("CONFIRMATION_TTL_HOURS"))
+	if err != nil {
+		log.Fatalln("invalid CONFIRMATION_TTL_HOURS: " + err.Error())
+	}
 
-	clName := os.Getenv("CONTACT_LIST_NAME")
+	sesClient := sesv2.NewFromConfig(cfg)
+	ddbClient := dynamodb.NewFromConfig(cfg)
 
-	h := handler.New(client, clName)
+	h := handler.New(handler.Config{
+		CreateContactAPI:     sesClient,
+		DeleteContactAPI:     sesClient,
+		SendEmailAPI:         sesClient,
+		BatchGetItemAPI:      ddbClient,
+		PutItemAPI:           ddbClient,
+		GetItemAPI:           ddbClient,
+		UpdateItemAPI:        ddbClient,
+		DeleteItemAPI:        ddbClient,
+		QueryAPI:             ddbClient,
+		ContactListName:      os.Getenv("CONTACT_LIST_NAME"),
+		ListsTableName:       os.Getenv("LISTS_TABLE_NAME"),
+		SubscribersTableName: os.Getenv("SUBSCRIBERS_TABLE_NAME"),
+		PendingTableName:     os.Getenv("PENDING_TABLE_NAME"),
+		HistoryTableName:     os.Getenv("HISTORY_TABLE_NAME"),
+		Signer:               token.NewSigner([]byte(*gpOutput.Parameter.Value)),
+		FromEmailAddress:     os.Getenv("FROM_EMAIL_ADDR"),
+		ConfirmationTTL:      time.Duration(ttlHours) * time.Hour,
+		Blocklist: handler.Blocklist{
+			LocalParts: handler.ParseBlocklistEntries(os.Getenv("BLOCKED_LOCAL_PARTS")),
+			Domains:    handler.ParseBlocklistEntries(os.Getenv("BLOCKED_DOMAINS")),
+		},
+	})
 
-	lambda.Start(h.Subscribe)
+	lambda.Start(h.Route)
 }
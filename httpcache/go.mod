@@ -0,0 +1,3 @@
+module bookoftheday/httpcache
+
+go 1.18
@@ -0,0 +1,140 @@
+// Package httpcache provides caching headers and conditional requests for responses whose
+// data only changes when a scheduled job runs.
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Schedule describes when the data behind a response changes: whenever any of its runs
+// happens, allowing each run a margin to finish updating the data.
+type Schedule struct {
+	runs []run
+}
+
+// run is a job that runs every day, or every week on weekday, at hour:minute in loc.
+type run struct {
+	loc          *time.Location
+	weekly       bool
+	weekday      time.Weekday
+	hour, minute int
+	margin       time.Duration
+}
+
+// Daily returns the Schedule of a job that runs every day at hour:minute UTC, and takes up
+// to margin to finish updating the data.
+func Daily(hour, minute int, margin time.Duration) Schedule {
+	return DailyIn(time.UTC, hour, minute, margin)
+}
+
+// DailyIn is like Daily, but for a job that runs at hour:minute in loc.
+func DailyIn(loc *time.Location, hour, minute int, margin time.Duration) Schedule {
+	return Schedule{[]run{{loc: loc, hour: hour, minute: minute, margin: margin}}}
+}
+
+// Weekly returns the Schedule of a job that runs every week on day at hour:minute UTC, and
+// takes up to margin to finish updating the data.
+func Weekly(day time.Weekday, hour, minute int, margin time.Duration) Schedule {
+	return Schedule{[]run{{loc: time.UTC, weekly: true, weekday: day, hour: hour, minute: minute, margin: margin}}}
+}
+
+// And returns a Schedule with the runs of both s and other.
+func (s Schedule) And(other Schedule) Schedule {
+	return Schedule{append(append([]run{}, s.runs...), other.runs...)}
+}
+
+// last returns the start of the most recent run at or before now.
+func (r run) last(now time.Time) time.Time {
+	t := now.In(r.loc)
+	last := time.Date(t.Year(), t.Month(), t.Day(), r.hour, r.minute, 0, 0, r.loc)
+	if r.weekly {
+		last = last.AddDate(0, 0, -((int(t.Weekday()) - int(r.weekday) + 7) % 7))
+	}
+	if last.After(now) {
+		last = r.next(last, -1)
+	}
+	return last
+}
+
+// next returns the start of the run n runs after the one that starts at t.
+func (r run) next(t time.Time, n int) time.Time {
+	t = t.In(r.loc)
+	if r.weekly {
+		n *= 7
+	}
+	return time.Date(t.Year(), t.Month(), t.Day()+n, r.hour, r.minute, 0, 0, r.loc)
+}
+
+// LastRun returns the start of the most recent run at or before now.
+func (s Schedule) LastRun(now time.Time) time.Time {
+	var lastRun time.Time
+	for _, r := range s.runs {
+		if last := r.last(now); last.After(lastRun) {
+			lastRun = last
+		}
+	}
+	return lastRun
+}
+
+// Apply adds caching headers for body to the response headers res, and returns true if
+// the request with headers req can be answered with 304 Not Modified instead.
+//
+// Responses are cached until the next run has had time to finish. While a run is still
+// updating the data, responses are only cached until it's done and have no Last-Modified.
+func (s Schedule) Apply(req, res map[string]string, body string, now time.Time) bool {
+	etag := ETag(body)
+	lastRun := s.LastRun(now)
+	var expires time.Time
+	var updating bool
+	for _, r := range s.runs {
+		last := r.last(now)
+		updated := last.Add(r.margin)
+		if now.Before(updated) {
+			updating = true
+		} else {
+			updated = r.next(last, 1).Add(r.margin)
+		}
+		if expires.IsZero() || updated.Before(expires) {
+			expires = updated
+		}
+	}
+
+	res["etag"] = etag
+	res["cache-control"] = fmt.Sprintf("public, max-age=%d", int64(expires.Sub(now).Seconds()))
+	if !updating {
+		res["last-modified"] = lastRun.UTC().Format(http.TimeFormat)
+	}
+
+	// If-Modified-Since is ignored when the request has If-None-Match
+	if inm, ok := req["if-none-match"]; ok {
+		return matchesETag(inm, etag)
+	}
+	if ims, ok := req["if-modified-since"]; ok && !updating {
+		t, err := http.ParseTime(ims)
+		return err == nil && !t.Before(lastRun)
+	}
+	return false
+}
+
+// ETag returns a strong entity tag for body.
+func ETag(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+}
+
+// matchesETag reports whether an If-None-Match header value matches etag. The comparison
+// is weak, so weak versions of etag also match.
+func matchesETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package httpcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLastRun(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	testCases := []struct {
+		name     string
+		schedule Schedule
+		now      time.Time
+		want     time.Time
+	}{
+		{"daily before run", Daily(0, 0, 0), time.Date(2022, 6, 15, 23, 59, 0, 0, time.UTC), time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC)},
+		{"daily at run", Daily(0, 0, 0), time.Date(2022, 6, 16, 0, 0, 0, 0, time.UTC), time.Date(2022, 6, 16, 0, 0, 0, 0, time.UTC)},
+		{"daily with time", Daily(6, 30, 0), time.Date(2022, 6, 16, 6, 0, 0, 0, time.UTC), time.Date(2022, 6, 15, 6, 30, 0, 0, time.UTC)},
+		// 2022-06-19 was a Sunday
+		{"weekly before run", Weekly(time.Sunday, 23, 59, 0), time.Date(2022, 6, 19, 23, 0, 0, 0, time.UTC), time.Date(2022, 6, 12, 23, 59, 0, 0, time.UTC)},
+		{"weekly after run", Weekly(time.Sunday, 23, 59, 0), time.Date(2022, 6, 22, 0, 0, 0, 0, time.UTC), time.Date(2022, 6, 19, 23, 59, 0, 0, time.UTC)},
+		{"weekly on thursday", Weekly(time.Thursday, 0, 0, 0), time.Date(2022, 6, 22, 0, 0, 0, 0, time.UTC), time.Date(2022, 6, 16, 0, 0, 0, 0, time.UTC)},
+		{"daily in location", DailyIn(tokyo, 0, 0, 0), time.Date(2022, 6, 15, 14, 0, 0, 0, time.UTC), time.Date(2022, 6, 14, 15, 0, 0, 0, time.UTC)},
+		{"later of two runs", Daily(0, 0, 0).And(DailyIn(tokyo, 0, 0, 0)), time.Date(2022, 6, 15, 16, 0, 0, 0, time.UTC), time.Date(2022, 6, 15, 15, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.schedule.LastRun(tc.now); !got.Equal(tc.want) {
+				t.Errorf("got %v; expected %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	s := Daily(0, 0, 30*time.Minute)
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	body := `{"count":0}`
+	etag := ETag(body)
+
+	t.Run("sets caching headers until after the next run", func(t *testing.T) {
+		res := map[string]string{}
+		if s.Apply(map[string]string{}, res, body, now) {
+			t.Error("got not modified for request without conditions")
+		}
+		want := map[string]string{
+			"etag":          etag,
+			"cache-control": "public, max-age=45000",
+			"last-modified": "Wed, 15 Jun 2022 00:00:00 GMT",
+		}
+		for k, v := range want {
+			if res[k] != v {
+				t.Errorf("got %s %q; expected %q", k, res[k], v)
+			}
+		}
+	})
+
+	t.Run("caches briefly while a run is updating", func(t *testing.T) {
+		res := map[string]string{}
+		s.Apply(map[string]string{"if-modified-since": "Wed, 15 Jun 2022 00:00:00 GMT"}, res, body, now.Add(12*time.Hour+10*time.Minute))
+		if res["cache-control"] != "public, max-age=1200" {
+			t.Errorf("got cache-control %q; expected max-age until the run is done", res["cache-control"])
+		}
+		if _, ok := res["last-modified"]; ok {
+			t.Errorf("got last-modified %q while updating; expected none", res["last-modified"])
+		}
+	})
+
+	testCases := []struct {
+		name string
+		req  map[string]string
+		want bool
+	}{
+		{"matching etag", map[string]string{"if-none-match": etag}, true},
+		{"matching etag in list", map[string]string{"if-none-match": `"other", ` + etag}, true},
+		{"weak etag", map[string]string{"if-none-match": "W/" + etag}, true},
+		{"any etag", map[string]string{"if-none-match": "*"}, true},
+		{"other etag", map[string]string{"if-none-match": `"other"`}, false},
+		{"other etag ignores if-modified-since", map[string]string{"if-none-match": `"other"`, "if-modified-since": "Wed, 15 Jun 2022 01:00:00 GMT"}, false},
+		{"not modified since", map[string]string{"if-modified-since": "Wed, 15 Jun 2022 00:00:00 GMT"}, true},
+		{"modified since", map[string]string{"if-modified-since": "Tue, 14 Jun 2022 00:00:00 GMT"}, false},
+		{"invalid if-modified-since", map[string]string{"if-modified-since": "yesterday"}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := s.Apply(tc.req, map[string]string{}, body, now); got != tc.want {
+				t.Errorf("got not modified %v; expected %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestApplyCombined(t *testing.T) {
+	// Books change at midnight UTC, and "today" changes at midnight in Tokyo (15:00 UTC)
+	s := Daily(0, 0, 30*time.Minute).And(DailyIn(time.FixedZone("JST", 9*60*60), 0, 0, 0))
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	res := map[string]string{}
+	if !s.Apply(map[string]string{"if-modified-since": "Wed, 15 Jun 2022 00:00:00 GMT"}, res, "", now) {
+		t.Error("got modified; expected not modified since the UTC run")
+	}
+	if res["cache-control"] != "public, max-age=10800" {
+		t.Errorf("got cache-control %q; expected max-age until midnight in Tokyo", res["cache-control"])
+	}
+
+	res = map[string]string{}
+	if s.Apply(map[string]string{"if-modified-since": "Wed, 15 Jun 2022 00:00:00 GMT"}, res, "", now.Add(4*time.Hour)) {
+		t.Error("got not modified; expected modified since midnight in Tokyo")
+	}
+	if res["last-modified"] != "Wed, 15 Jun 2022 15:00:00 GMT" || res["cache-control"] != "public, max-age=30600" {
+		t.Errorf("wrong headers: got %v", res)
+	}
+}
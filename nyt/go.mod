@@ -0,0 +1,3 @@
+module bookoftheday/nyt
+
+go 1.18
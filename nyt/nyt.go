@@ -0,0 +1,301 @@
+// Package nyt provides a client for the NYT Books API.
+package nyt
+
+import (
+	"bookoftheday/types"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultBaseURL is the base URL of the NYT Books API.
+const DefaultBaseURL = "https://api.nytimes.com/svc/books/v3"
+
+// defaultTimeout is the timeout of the default HTTP client.
+const defaultTimeout = 10 * time.Second
+
+// Errors that an *Error matches with errors.Is, by its status code.
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotFound     = errors.New("not found")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServer       = errors.New("server error")
+
+	// ErrQuotaExhausted is returned when the API key has no requests left today. The
+	// client doesn't retry these, because the quota won't refill for hours.
+	ErrQuotaExhausted = errors.New("daily quota exhausted")
+)
+
+// Error is an error response from the API.
+type Error struct {
+	StatusCode int
+	Path       string
+	Body       string
+
+	// RetryAfter is how long the API asked to wait before retrying, if it did.
+	RetryAfter time.Duration
+
+	// QuotaExhausted is true for a 429 response sent when the daily quota is used up.
+	QuotaExhausted bool
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("error response %d from Books API %s: %s", e.StatusCode, e.Path, e.Body)
+}
+
+// Is reports whether target is the error for e's status code: ErrUnauthorized for 401,
+// ErrNotFound for 404, ErrRateLimited for 429, or ErrServer for 5xx. A 429 sent when the
+// daily quota is used up is also ErrQuotaExhausted.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrQuotaExhausted:
+		return e.QuotaExhausted
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// Client makes requests to the NYT Books API. It waits as needed to stay within the
+// per-minute quota, and retries rate limited and server error responses.
+type Client struct {
+	key        string
+	baseURL    string
+	httpClient *http.Client
+	limiter    *limiter
+	maxRetries int
+	sleep      func(ctx context.Context, d time.Duration) error
+}
+
+// Config provides configuration options for a Client.
+type Config struct {
+	// APIKey is the key of the NYT developer app. The API only accepts it in the query
+	// string, so it's left out of the errors that Client returns.
+	APIKey string
+
+	// BaseURL defaults to DefaultBaseURL.
+	BaseURL string
+
+	// HTTPClient defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+
+	// PerMinute and PerDay are the quotas of the API key, defaulting to the NYT's 5
+	// requests per minute and 500 per day. Requests are spaced out to stay within them,
+	// and the day's quota starts over at the UTC day boundary. The counts are kept in
+	// memory, so they're best-effort, corrected by the quota headers of each response.
+	PerMinute int
+	PerDay    int
+
+	// MaxRetries is how many times a rate limited or server error response is retried,
+	// with jittered exponential backoff or after its Retry-After. It defaults to 3, and
+	// a negative value disables retries.
+	MaxRetries int
+}
+
+// New creates a new Client.
+func New(cfg Config) *Client {
+	c := &Client{
+		key:        cfg.APIKey,
+		baseURL:    cfg.BaseURL,
+		httpClient: cfg.HTTPClient,
+	}
+	if c.baseURL == "" {
+		c.baseURL = DefaultBaseURL
+	}
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+
+	perMinute, perDay := cfg.PerMinute, cfg.PerDay
+	if perMinute <= 0 {
+		perMinute = defaultPerMinute
+	}
+	if perDay <= 0 {
+		perDay = defaultPerDay
+	}
+	c.limiter = newLimiter(perMinute, perDay, time.Now)
+
+	c.maxRetries = cfg.MaxRetries
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	} else if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+	c.sleep = sleep
+	return c
+}
+
+// response is the envelope of every API response.
+type response struct {
+	Status     string          `json:"status"`
+	Copyright  string          `json:"copyright"`
+	NumResults int             `json:"num_results"`
+	Results    json.RawMessage `json:"results"`
+}
+
+// get requests path with query, and unmarshals the results of the response into results.
+// Rate limited and server error responses are retried up to maxRetries times.
+func (c *Client) get(ctx context.Context, path string, query url.Values, results interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("api-key", c.key)
+
+	for attempt := 0; ; attempt++ {
+		wait, err := c.limiter.reserve()
+		if err != nil {
+			return fmt.Errorf("could not GET NYT Books API %s: %w", path, err)
+		}
+		if err := c.sleep(ctx, wait); err != nil {
+			return fmt.Errorf("could not GET NYT Books API %s: %w", path, err)
+		}
+
+		err = c.do(ctx, path, query, results)
+		var apiErr *Error
+		if attempt == c.maxRetries || !errors.As(err, &apiErr) || !apiErr.retryable() {
+			return err
+		}
+
+		wait = apiErr.RetryAfter
+		if wait == 0 {
+			wait = backoff(attempt)
+		}
+		if wait > maxRetryWait {
+			return err
+		}
+		if err := c.sleep(ctx, wait); err != nil {
+			return fmt.Errorf("could not GET NYT Books API %s: %w", path, err)
+		}
+	}
+}
+
+// retryable reports whether the request that caused e could succeed if retried.
+func (e *Error) retryable() bool {
+	return (e.StatusCode == http.StatusTooManyRequests && !e.QuotaExhausted) || e.StatusCode >= 500
+}
+
+// do makes one request to path with query, which has the key.
+func (c *Client) do(ctx context.Context, path string, query url.Values, results interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("could not create request for %s: %w", path, err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// The URL in a *url.Error has the key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("could not GET NYT Books API %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	c.limiter.update(resp.Header)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		apiErr := &Error{StatusCode: resp.StatusCode, Path: path, Body: string(body)}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(secs) * time.Second
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && resp.Header.Get(remainingDayHeader) == "0" {
+			apiErr.QuotaExhausted = true
+		}
+		return apiErr
+	}
+
+	var data response
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return fmt.Errorf("could not unmarshal API response: %w", err)
+	}
+	if err := json.Unmarshal(data.Results, results); err != nil {
+		return fmt.Errorf("could not unmarshal API results: %w", err)
+	}
+	return nil
+}
+
+// ListNames returns every Best-Seller list.
+func (c *Client) ListNames(ctx context.Context) ([]types.BestSellerList, error) {
+	var lists []types.BestSellerList
+	err := c.get(ctx, "/lists/names.json", nil, &lists)
+	return lists, err
+}
+
+// List returns the Best-Seller list published on date (YYYY-MM-DD, or "current"). If
+// the date doesn't exactly match a published date, the nearest in the future is returned.
+func (c *Client) List(ctx context.Context, list, date string) (BestSellerBookList, error) {
+	var bl BestSellerBookList
+	err := c.get(ctx, fmt.Sprintf("/lists/%s/%s.json", url.PathEscape(date), url.PathEscape(list)), nil, &bl)
+	return bl, err
+}
+
+// Overview returns the top books of every list published on publishedDate (YYYY-MM-DD),
+// or the latest lists if it's empty.
+func (c *Client) Overview(ctx context.Context, publishedDate string) (Overview, error) {
+	query := url.Values{}
+	if publishedDate != "" {
+		query.Set("published_date", publishedDate)
+	}
+	var o Overview
+	err := c.get(ctx, "/lists/overview.json", query, &o)
+	return o, err
+}
+
+// HistoryQuery filters the books returned by History. Empty fields are ignored.
+type HistoryQuery struct {
+	Author    string
+	Title     string
+	ISBN      string
+	Publisher string
+
+	// Offset is the index of the first result, and must be a multiple of 20.
+	Offset int
+}
+
+// History returns up to 20 books that have been on any Best-Seller list, with their ranks.
+func (c *Client) History(ctx context.Context, q HistoryQuery) ([]HistoryBook, error) {
+	query := url.Values{}
+	for k, v := range map[string]string{"author": q.Author, "title": q.Title, "isbn": q.ISBN, "publisher": q.Publisher} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	if q.Offset != 0 {
+		query.Set("offset", strconv.Itoa(q.Offset))
+	}
+	var books []HistoryBook
+	err := c.get(ctx, "/lists/best-sellers/history.json", query, &books)
+	return books, err
+}
+
+// ReviewsQuery selects the book returned by Reviews. One of the fields must be set.
+type ReviewsQuery struct {
+	ISBN   string
+	Title  string
+	Author string
+}
+
+// Reviews returns the New York Times reviews of a book.
+func (c *Client) Reviews(ctx context.Context, q ReviewsQuery) ([]Review, error) {
+	query := url.Values{}
+	for k, v := range map[string]string{"isbn": q.ISBN, "title": q.Title, "author": q.Author} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	var reviews []Review
+	err := c.get(ctx, "/reviews.json", query, &reviews)
+	return reviews, err
+}
@@ -0,0 +1,434 @@
+package nyt
+
+import (
+	"bookoftheday/types"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a Client for a server that serves handler, that doesn't wait
+// before requests.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	c, _ := newSleeplessClient(t, handler)
+	return c
+}
+
+// newSleeplessClient is like newTestClient, but also returns the durations the client
+// would have waited.
+func newSleeplessClient(t *testing.T, handler http.HandlerFunc) (*Client, *[]time.Duration) {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	c := New(Config{APIKey: "key", BaseURL: ts.URL, HTTPClient: ts.Client()})
+	var waits []time.Duration
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		if d > 0 {
+			waits = append(waits, d)
+		}
+		return ctx.Err()
+	}
+	return c, &waits
+}
+
+func TestList(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lists/2022-01-23/hardcover-fiction.json" {
+			t.Errorf("request path invalid: got %s; expected %s", r.URL.Path, "/lists/2022-01-23/hardcover-fiction.json")
+		}
+		if r.URL.Query().Get("api-key") != "key" {
+			t.Errorf("got api-key %q; expected key", r.URL.Query().Get("api-key"))
+		}
+		fmt.Fprint(w, listResponse)
+	})
+
+	got, err := c.List(context.Background(), "hardcover-fiction", "2022-01-23")
+	if err != nil {
+		t.Fatalf("got err %v; expected nil", err)
+	}
+	if !reflect.DeepEqual(got, wantList) {
+		t.Errorf("fields mismatch in unmarshalled response: got %+v; expected %+v", got, wantList)
+	}
+}
+
+func TestListNames(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lists/names.json" {
+			t.Errorf("request path invalid: got %s; expected /lists/names.json", r.URL.Path)
+		}
+		fmt.Fprint(w, `{
+"status": "OK",
+"copyright": "Copyright",
+"num_results": 1,
+"results": [
+		{
+			"list_name": "Fiction",
+			"display_name": "Fiction",
+			"list_name_encoded": "fiction",
+			"oldest_published_date": "2022-06-14",
+			"newest_published_date": "2022-06-14",
+			"updated": "WEEKLY"
+		}
+	]
+}
+`)
+	})
+
+	got, err := c.ListNames(context.Background())
+	if err != nil {
+		t.Fatalf("got err %v; expected nil", err)
+	}
+	want := []types.BestSellerList{{
+		Name:                "Fiction",
+		DisplayName:         "Fiction",
+		EncodedName:         "fiction",
+		OldestPublishedDate: "2022-06-14",
+		NewestPublishedDate: "2022-06-14",
+		UpdatePeriod:        "WEEKLY",
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; expected %v", got, want)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	var got []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		q.Del("api-key")
+		got = append(got, r.URL.Path+"?"+q.Encode())
+		fmt.Fprint(w, `{"status": "OK", "num_results": 1, "results": {"published_date": "2022-06-19", "lists": [{"list_name_encoded": "manga", "books": [{"rank": 1}]}]}}`)
+	})
+
+	o, err := c.Overview(context.Background(), "2022-06-19")
+	if err != nil || len(o.Lists) != 1 || o.Lists[0].ListNameEncoded != "manga" || o.Lists[0].Books[0].Rank != 1 {
+		t.Errorf("got %+v, %v; expected the manga list", o, err)
+	}
+	// The results of the other calls are arrays, so only their requests are checked
+	_, _ = c.History(context.Background(), HistoryQuery{Author: "Sally Rooney", Offset: 20})
+	_, _ = c.Reviews(context.Background(), ReviewsQuery{ISBN: "9780593230572"})
+
+	want := []string{
+		"/lists/overview.json?published_date=2022-06-19",
+		"/lists/best-sellers/history.json?author=Sally+Rooney&offset=20",
+		"/reviews.json?isbn=9780593230572",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %v; expected %v", got, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	testCases := []struct {
+		status     int
+		retryAfter string
+		want       error
+	}{
+		{401, "", ErrUnauthorized},
+		{404, "", ErrNotFound},
+		{429, "30", ErrRateLimited},
+		{500, "", ErrServer},
+		{503, "", ErrServer},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.status), func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, `{"fault": "error"}`)
+			})
+
+			_, err := c.ListNames(context.Background())
+			if !errors.Is(err, tc.want) {
+				t.Errorf("got %v; expected it to be %v", err, tc.want)
+			}
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tc.status || apiErr.Body != `{"fault": "error"}` {
+				t.Errorf("got %#v; expected an *Error with status %d", err, tc.status)
+			}
+			if tc.retryAfter != "" && apiErr.RetryAfter != 30*time.Second {
+				t.Errorf("got RetryAfter %v; expected 30s", apiErr.RetryAfter)
+			}
+			for _, other := range []error{ErrUnauthorized, ErrNotFound, ErrRateLimited, ErrServer} {
+				if other != tc.want && errors.Is(err, other) {
+					t.Errorf("got %v; expected it not to be %v", err, other)
+				}
+			}
+		})
+	}
+
+	t.Run("request errors don't have the key", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		ts.Close()
+		c := New(Config{APIKey: "secret-key", BaseURL: ts.URL})
+		_, err := c.ListNames(context.Background())
+		if err == nil || strings.Contains(err.Error(), "secret-key") {
+			t.Errorf("got %v; expected an error without the key", err)
+		}
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := c.ListNames(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v; expected context.Canceled", err)
+		}
+	})
+}
+
+func TestRetries(t *testing.T) {
+	testCases := []struct {
+		name      string
+		statuses  []int
+		header    http.Header
+		wantErr   error
+		wantCalls int
+		wantWaits []time.Duration
+	}{
+		{
+			name:      "server error then success",
+			statuses:  []int{500, 502, 200},
+			wantCalls: 3,
+			wantWaits: []time.Duration{2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:      "retries run out",
+			statuses:  []int{503, 503, 503, 503, 503},
+			wantErr:   ErrServer,
+			wantCalls: 4,
+			wantWaits: []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			name:      "retry after",
+			statuses:  []int{429, 200},
+			header:    http.Header{"Retry-After": {"20"}},
+			wantCalls: 2,
+			wantWaits: []time.Duration{20 * time.Second},
+		},
+		{
+			name:      "retry after too long",
+			statuses:  []int{429, 200},
+			header:    http.Header{"Retry-After": {"3600"}},
+			wantErr:   ErrRateLimited,
+			wantCalls: 1,
+		},
+		{
+			name:      "quota exhausted",
+			statuses:  []int{429, 200},
+			header:    http.Header{"X-RateLimit-Remaining-Day": {"0"}},
+			wantErr:   ErrQuotaExhausted,
+			wantCalls: 1,
+		},
+		{
+			name:      "not found isn't retried",
+			statuses:  []int{404, 200},
+			wantErr:   ErrNotFound,
+			wantCalls: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			c, waits := newSleeplessClient(t, func(w http.ResponseWriter, r *http.Request) {
+				status := tc.statuses[calls]
+				calls++
+				if status != http.StatusOK {
+					for k, v := range tc.header {
+						w.Header()[k] = v
+					}
+					w.WriteHeader(status)
+					return
+				}
+				fmt.Fprint(w, `{"status": "OK", "results": []}`)
+			})
+
+			_, err := c.ListNames(context.Background())
+			if !errors.Is(err, tc.wantErr) || (tc.wantErr == nil && err != nil) {
+				t.Errorf("got err %v; expected %v", err, tc.wantErr)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("got %d requests; expected %d", calls, tc.wantCalls)
+			}
+			if len(*waits) != len(tc.wantWaits) {
+				t.Fatalf("got waits %v; expected %v", *waits, tc.wantWaits)
+			}
+			for i, want := range tc.wantWaits {
+				// Backoff is jittered, between half and all of the delay
+				got := (*waits)[i]
+				if tc.header == nil && (got < want/2 || got >= want) {
+					t.Errorf("got wait %v; expected it between %v and %v", got, want/2, want)
+				} else if tc.header != nil && got != want {
+					t.Errorf("got wait %v; expected %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2022, 6, 19, 0, 0, 0, 0, time.UTC)
+	l := newLimiter(2, 4, func() time.Time { return now })
+
+	reserve := func(want time.Duration) {
+		t.Helper()
+		if got, err := l.reserve(); err != nil || got != want {
+			t.Errorf("got %v, %v; expected %v, nil", got, err, want)
+		}
+	}
+	// The first two requests of the minute don't wait, and each later one waits for the
+	// next of the requests that the bucket gains every 30 seconds
+	reserve(0)
+	reserve(0)
+	reserve(30 * time.Second)
+	now = now.Add(30 * time.Second)
+	reserve(30 * time.Second)
+
+	// The day's quota of 4 is used up
+	if _, err := l.reserve(); !errors.Is(err, ErrQuotaExhausted) {
+		t.Errorf("got %v; expected ErrQuotaExhausted", err)
+	}
+
+	// The day's quota doesn't refill until the next UTC day
+	now = now.Add(23 * time.Hour)
+	if _, err := l.reserve(); !errors.Is(err, ErrQuotaExhausted) {
+		t.Errorf("got %v later in the day; expected ErrQuotaExhausted", err)
+	}
+
+	// The quota headers lower the remaining requests, but never raise them
+	now = now.Add(time.Hour)
+	l.update(http.Header{"X-Ratelimit-Remaining-Minute": {"5"}, "X-Ratelimit-Remaining-Day": {"1"}})
+	reserve(0)
+	if _, err := l.reserve(); !errors.Is(err, ErrQuotaExhausted) {
+		t.Errorf("got %v; expected ErrQuotaExhausted", err)
+	}
+
+	// Once the API reports no requests left, none are made until the next UTC day
+	now = now.Add(24 * time.Hour)
+	l.update(http.Header{"X-Ratelimit-Remaining-Day": {"0"}})
+	if _, err := l.reserve(); !errors.Is(err, ErrQuotaExhausted) {
+		t.Errorf("got %v after the API reported 0 requests left; expected ErrQuotaExhausted", err)
+	}
+	now = now.Add(24 * time.Hour)
+	reserve(0)
+}
+
+const listResponse = `{
+"status": "OK",
+"copyright": "Copyright",
+"num_results": 11,
+"last_modified": "2022-06-09T12:34:56-07:00",
+"results": {
+	"list_name": "Hardcover",
+	"list_name_encoded": "hardcover",
+	"bestsellers_date": "2022-01-23",
+	"published_date": "2022-03-21",
+	"published_date_description": "one_before_latest",
+	"next_published_date": "2021-01-11",
+	"previous_published_date": "2021-02-22",
+	"display_name": "Hardcover",
+	"normal_list_ends_at": 11,
+	"updated": "WEEKLY",
+	"books": [
+		{
+			"rank": 1,
+			"rank_last_week": 0,
+			"weeks_on_list": 1,
+			"asterisk": 0,
+			"dagger": 0,
+			"primary_isbn10": "1234567890",
+			"primary_isbn13": "1234567890123",
+			"publisher": "Test",
+			"description": "TestBook",
+			"price": "0.00",
+			"title": "TEST-BOOK",
+			"author": "Test",
+			"contributor": "Test",
+			"contributor_note": "ContributorNote",
+			"book_image": "ImageLink",
+			"book_image_width": 400,
+			"book_image_height": 500,
+			"amazon_product_url": "ProductURL",
+			"age_group": "all",
+			"book_review_link": "ReviewLink",
+			"first_chapter_link": "ChapterLink",
+			"sunday_review_link": "SundayReviewLink",
+			"article_chapter_link": "ArticleChapterLink",
+			"isbns": [
+				{
+					"isbn10": "1234567890",
+					"isbn13": "1234567890123"
+				}
+			],
+			"buy_links": [
+				{
+					"name": "Website1",
+					"url": "URL1"
+				}
+			],
+			"book_uri": "URI"
+		}
+	]
+}
+}
+`
+
+var wantList = BestSellerBookList{
+	ListName:                 "Hardcover",
+	ListNameEncoded:          "hardcover",
+	BestSellersDate:          "2022-01-23",
+	PublishedDate:            "2022-03-21",
+	PublishedDateDescription: "one_before_latest",
+	NextPublishedDate:        "2021-01-11",
+	PreviousPublishedDate:    "2021-02-22",
+	DisplayName:              "Hardcover",
+	NormalListEndsAt:         11,
+	Updated:                  "WEEKLY",
+	Books: []BestSellerBook{
+		{
+			Rank:               1,
+			RankLastWeek:       0,
+			WeeksOnList:        1,
+			Asterisk:           0,
+			Dagger:             0,
+			PrimaryISBN10:      "1234567890",
+			PrimaryISBN13:      "1234567890123",
+			Publisher:          "Test",
+			Description:        "TestBook",
+			Price:              "0.00",
+			Title:              "TEST-BOOK",
+			Author:             "Test",
+			Contributor:        "Test",
+			ContributorNote:    "ContributorNote",
+			ImageURL:           "ImageLink",
+			ImageWidth:         400,
+			ImageHeight:        500,
+			AmazonProductURL:   "ProductURL",
+			AgeGroup:           "all",
+			ReviewLink:         "ReviewLink",
+			FirstChapterLink:   "ChapterLink",
+			SundayReviewLink:   "SundayReviewLink",
+			ArticleChapterLink: "ArticleChapterLink",
+			ISBNs: []ISBNPair{
+				{
+					ISBN10: "1234567890",
+					ISBN13: "1234567890123",
+				},
+			},
+			BuyLinks: []BuyLink{
+				{
+					Name: "Website1",
+					URL:  "URL1",
+				},
+			},
+			URI: "URI",
+		},
+	},
+}
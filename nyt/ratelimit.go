@@ -0,0 +1,167 @@
+package nyt
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default quotas of an NYT developer app.
+const (
+	defaultPerMinute = 5
+	defaultPerDay    = 500
+)
+
+// Defaults for retrying rate limited and server error responses.
+const (
+	defaultMaxRetries = 3
+	retryBaseDelay    = 2 * time.Second
+
+	// maxRetryWait is the longest the client waits before a retry. A longer Retry-After
+	// returns the error instead.
+	maxRetryWait = time.Minute
+)
+
+// Headers with the number of requests left in the app's quotas.
+const (
+	remainingMinuteHeader = "X-RateLimit-Remaining-Minute"
+	remainingDayHeader    = "X-RateLimit-Remaining-Day"
+)
+
+// bucket is a token bucket that holds up to size tokens, and gains one every interval.
+// Taking a token from an empty bucket leaves it negative, so later callers wait their turn.
+type bucket struct {
+	size     float64
+	tokens   float64
+	interval time.Duration
+	last     time.Time
+}
+
+func newBucket(size int, period time.Duration, now time.Time) *bucket {
+	return &bucket{
+		size:     float64(size),
+		tokens:   float64(size),
+		interval: period / time.Duration(size),
+		last:     now,
+	}
+}
+
+// refill adds the tokens gained since the last refill.
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+		if b.tokens > b.size {
+			b.tokens = b.size
+		}
+		b.last = now
+	}
+}
+
+// wait returns how long until the bucket has a token.
+func (b *bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(b.interval))
+}
+
+// dayQuota counts the requests left in the day's quota, which starts over at the UTC
+// day boundary.
+type dayQuota struct {
+	size      int
+	remaining int
+	day       string
+}
+
+// reset starts over the quota when now is on a new UTC day.
+func (q *dayQuota) reset(now time.Time) {
+	if day := now.UTC().Format("2006-01-02"); day != q.day {
+		q.day = day
+		q.remaining = q.size
+	}
+}
+
+// limiter enforces the per-minute and per-day quotas of the API key.
+//
+// The limits are kept in process memory, so they're best-effort: a cold start begins
+// with the full quotas, and each Lambda only counts its own requests. The remaining
+// quotas in the headers of every response correct the counts, and once the API reports
+// no requests left today, the limiter returns ErrQuotaExhausted until the next UTC day
+// without making more requests.
+type limiter struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	minute *bucket
+	day    *dayQuota
+}
+
+func newLimiter(perMinute, perDay int, now func() time.Time) *limiter {
+	t := now()
+	l := &limiter{
+		now:    now,
+		minute: newBucket(perMinute, time.Minute, t),
+		day:    &dayQuota{size: perDay},
+	}
+	l.day.reset(t)
+	return l
+}
+
+// reserve takes a token for one request, and returns how long to wait before making it.
+// Rather than wait for the day's quota to refill, it returns ErrQuotaExhausted.
+func (l *limiter) reserve() (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.minute.refill(now)
+	l.day.reset(now)
+	if l.day.remaining <= 0 {
+		return 0, ErrQuotaExhausted
+	}
+	wait := l.minute.wait()
+	l.minute.tokens--
+	l.day.remaining--
+	return wait, nil
+}
+
+// update lowers the tokens to the remaining quotas in the headers of a response, when
+// the API has counted requests that the limiter hasn't, like those made by another Lambda.
+func (l *limiter) update(header http.Header) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.minute.refill(now)
+	if remaining, err := strconv.Atoi(header.Get(remainingMinuteHeader)); err == nil && float64(remaining) < l.minute.tokens {
+		l.minute.tokens = float64(remaining)
+	}
+	l.day.reset(now)
+	if remaining, err := strconv.Atoi(header.Get(remainingDayHeader)); err == nil && remaining < l.day.remaining {
+		l.day.remaining = remaining
+	}
+}
+
+// backoff returns the jittered delay before retry number attempt (starting at 0): a
+// random duration between half and all of retryBaseDelay doubled attempt times.
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << attempt
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,130 @@
+package nyt
+
+// BestSellerBookList is a Best-Seller list published on a date, from List.
+type BestSellerBookList struct {
+	ListName                 string           `json:"list_name"`
+	ListNameEncoded          string           `json:"list_name_encoded"`
+	BestSellersDate          string           `json:"bestsellers_date"`
+	PublishedDate            string           `json:"published_date"`
+	PublishedDateDescription string           `json:"published_date_description"`
+	NextPublishedDate        string           `json:"next_published_date"`
+	PreviousPublishedDate    string           `json:"previous_published_date"`
+	DisplayName              string           `json:"display_name"`
+	NormalListEndsAt         int              `json:"normal_list_ends_at"`
+	Updated                  string           `json:"updated"`
+	Books                    []BestSellerBook `json:"books"`
+}
+
+// BestSellerBook is a book on a Best-Seller list.
+type BestSellerBook struct {
+	Rank               int        `json:"rank"`
+	RankLastWeek       int        `json:"rank_last_week"`
+	WeeksOnList        int        `json:"weeks_on_list"`
+	Asterisk           int        `json:"asterisk"`
+	Dagger             int        `json:"dagger"`
+	PrimaryISBN10      string     `json:"primary_isbn10"`
+	PrimaryISBN13      string     `json:"primary_isbn13"`
+	Publisher          string     `json:"publisher"`
+	Description        string     `json:"description"`
+	Price              string     `json:"price"`
+	Title              string     `json:"title"`
+	Author             string     `json:"author"`
+	Contributor        string     `json:"contributor"`
+	ContributorNote    string     `json:"contributor_note"`
+	ImageURL           string     `json:"book_image"`
+	ImageWidth         int        `json:"book_image_width"`
+	ImageHeight        int        `json:"book_image_height"`
+	AmazonProductURL   string     `json:"amazon_product_url"`
+	AgeGroup           string     `json:"age_group"`
+	ReviewLink         string     `json:"book_review_link"`
+	FirstChapterLink   string     `json:"first_chapter_link"`
+	SundayReviewLink   string     `json:"sunday_review_link"`
+	ArticleChapterLink string     `json:"article_chapter_link"`
+	ISBNs              []ISBNPair `json:"isbns"`
+	BuyLinks           []BuyLink  `json:"buy_links"`
+	URI                string     `json:"book_uri"`
+}
+
+// ISBNPair is the ISBN-10 and ISBN-13 of an edition of a book.
+type ISBNPair struct {
+	ISBN10 string `json:"isbn10"`
+	ISBN13 string `json:"isbn13"`
+}
+
+// BuyLink is a store that sells a book.
+type BuyLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Overview contains every Best-Seller list published on a date, from Overview.
+type Overview struct {
+	BestSellersDate          string         `json:"bestsellers_date"`
+	PublishedDate            string         `json:"published_date"`
+	PublishedDateDescription string         `json:"published_date_description"`
+	PreviousPublishedDate    string         `json:"previous_published_date"`
+	NextPublishedDate        string         `json:"next_published_date"`
+	Lists                    []OverviewList `json:"lists"`
+}
+
+// OverviewList is a Best-Seller list in an Overview.
+type OverviewList struct {
+	ListID          int              `json:"list_id"`
+	ListName        string           `json:"list_name"`
+	ListNameEncoded string           `json:"list_name_encoded"`
+	DisplayName     string           `json:"display_name"`
+	Updated         string           `json:"updated"`
+	ListImage       string           `json:"list_image"`
+	ListImageWidth  int              `json:"list_image_width"`
+	ListImageHeight int              `json:"list_image_height"`
+	Books           []BestSellerBook `json:"books"`
+}
+
+// HistoryBook is a book that has been on a Best-Seller list, from History.
+type HistoryBook struct {
+	Title           string          `json:"title"`
+	Description     string          `json:"description"`
+	Contributor     string          `json:"contributor"`
+	Author          string          `json:"author"`
+	ContributorNote string          `json:"contributor_note"`
+	AgeGroup        string          `json:"age_group"`
+	Publisher       string          `json:"publisher"`
+	ISBNs           []ISBNPair      `json:"isbns"`
+	RanksHistory    []RankHistory   `json:"ranks_history"`
+	Reviews         []HistoryReview `json:"reviews"`
+}
+
+// RankHistory is the rank of a HistoryBook on a list published on a date.
+type RankHistory struct {
+	PrimaryISBN10   string `json:"primary_isbn10"`
+	PrimaryISBN13   string `json:"primary_isbn13"`
+	Rank            int    `json:"rank"`
+	ListName        string `json:"list_name"`
+	DisplayName     string `json:"display_name"`
+	PublishedDate   string `json:"published_date"`
+	BestSellersDate string `json:"bestsellers_date"`
+	WeeksOnList     int    `json:"weeks_on_list"`
+	Asterisk        int    `json:"asterisk"`
+	Dagger          int    `json:"dagger"`
+}
+
+// HistoryReview links to the reviews of a HistoryBook.
+type HistoryReview struct {
+	BookReviewLink     string `json:"book_review_link"`
+	FirstChapterLink   string `json:"first_chapter_link"`
+	SundayReviewLink   string `json:"sunday_review_link"`
+	ArticleChapterLink string `json:"article_chapter_link"`
+}
+
+// Review is a New York Times book review, from Reviews.
+type Review struct {
+	URL             string   `json:"url"`
+	PublicationDate string   `json:"publication_dt"`
+	Byline          string   `json:"byline"`
+	BookTitle       string   `json:"book_title"`
+	BookAuthor      string   `json:"book_author"`
+	Summary         string   `json:"summary"`
+	ISBN13          []string `json:"isbn13"`
+	UUID            string   `json:"uuid"`
+	URI             string   `json:"uri"`
+}
@@ -0,0 +1,3 @@
+module bookoftheday/openapi
+
+go 1.18
@@ -0,0 +1,416 @@
+// Package openapi provides the OpenAPI document of the HTTP API, and checks parameter
+// values and response types against it so that handlers can be tested against their
+// documentation.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Document is the part of an OpenAPI 3 document that describes parameters and schemas.
+type Document struct {
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Parameters map[string]*Parameter `json:"parameters"`
+		Responses  map[string]*Response  `json:"responses"`
+		Schemas    map[string]*Schema    `json:"schemas"`
+	} `json:"components"`
+}
+
+// Operation is a route of the API.
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Tags        []string             `json:"tags"`
+	Parameters  []*Parameter         `json:"parameters"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is a query, path, or header parameter of an Operation.
+type Parameter struct {
+	Ref             string  `json:"$ref"`
+	Name            string  `json:"name"`
+	In              string  `json:"in"`
+	Required        bool    `json:"required"`
+	AllowEmptyValue bool    `json:"allowEmptyValue"`
+	Schema          *Schema `json:"schema"`
+}
+
+// Response is a response of an Operation, by content type.
+type Response struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *Schema `json:"schema"`
+	} `json:"content"`
+}
+
+// Schema is the schema of a parameter or of a property of a response.
+type Schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Pattern    string             `json:"pattern"`
+	Enum       []string           `json:"enum"`
+	Minimum    *int               `json:"minimum"`
+	Maximum    *int               `json:"maximum"`
+	MaxItems   *int               `json:"maxItems"`
+	Items      *Schema            `json:"items"`
+	Properties map[string]*Schema `json:"properties"`
+	Example    json.RawMessage    `json:"example"`
+
+	pattern *regexp.Regexp
+}
+
+const dateLayout = "2006-01-02"
+
+// Load parses the document and resolves the references to its components.
+func Load() (*Document, error) {
+	var d Document
+	if err := json.Unmarshal(spec, &d); err != nil {
+		return nil, fmt.Errorf("could not unmarshal document: %w", err)
+	}
+
+	for _, s := range d.Components.Schemas {
+		if err := d.resolveSchema(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range d.Components.Parameters {
+		if err := d.resolveSchema(p.Schema); err != nil {
+			return nil, err
+		}
+	}
+	for _, ops := range d.Paths {
+		for _, op := range ops {
+			for i, p := range op.Parameters {
+				if p.Ref != "" {
+					ref, ok := d.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+					if !ok {
+						return nil, fmt.Errorf("unknown parameter %s", p.Ref)
+					}
+					op.Parameters[i] = ref
+				} else if err := d.resolveSchema(p.Schema); err != nil {
+					return nil, err
+				}
+			}
+			for status, r := range op.Responses {
+				if r.Ref != "" {
+					ref, ok := d.Components.Responses[strings.TrimPrefix(r.Ref, "#/components/responses/")]
+					if !ok {
+						return nil, fmt.Errorf("unknown response %s", r.Ref)
+					}
+					op.Responses[status] = ref
+				}
+			}
+		}
+	}
+	return &d, nil
+}
+
+// resolveSchema copies the component that s refers to into s, and compiles its pattern.
+func (d *Document) resolveSchema(s *Schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		ref, ok := d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("unknown schema %s", s.Ref)
+		}
+		r := s.Ref
+		*s = *ref
+		s.Ref = r
+	}
+	if s.Pattern != "" {
+		p, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %s: %w", s.Pattern, err)
+		}
+		s.pattern = p
+	}
+	return d.resolveSchema(s.Items)
+}
+
+// Operation returns the operation of an API Gateway route key, such as "GET /books".
+func (d *Document) Operation(routeKey string) (*Operation, bool) {
+	method, path, _ := strings.Cut(routeKey, " ")
+	op, ok := d.Paths[path][strings.ToLower(method)]
+	return op, ok
+}
+
+// Routes returns the route keys of the operations with tag, sorted.
+func (d *Document) Routes(tag string) []string {
+	var routes []string
+	for path, ops := range d.Paths {
+		for method, op := range ops {
+			for _, t := range op.Tags {
+				if t == tag {
+					routes = append(routes, strings.ToUpper(method)+" "+path)
+				}
+			}
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// ResponseSchemas returns the names of the component schemas that the responses of op
+// refer to.
+func (op *Operation) ResponseSchemas() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, r := range op.Responses {
+		for _, c := range r.Content {
+			name := strings.TrimPrefix(c.Schema.Ref, "#/components/schemas/")
+			if c.Schema.Ref != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check returns an error if value isn't valid for s.
+func (s *Schema) Check(value string) error {
+	switch s.Type {
+	case "integer":
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		if s.Minimum != nil && i < *s.Minimum {
+			return fmt.Errorf("%d is less than %d", i, *s.Minimum)
+		}
+		if s.Maximum != nil && i > *s.Maximum {
+			return fmt.Errorf("%d is greater than %d", i, *s.Maximum)
+		}
+	case "boolean":
+		if value != "true" && value != "false" {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+	case "array":
+		items := strings.Split(value, ",")
+		if s.MaxItems != nil && len(items) > *s.MaxItems {
+			return fmt.Errorf("more than %d items", *s.MaxItems)
+		}
+		for _, item := range items {
+			if err := s.Items.Check(item); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if len(s.Enum) != 0 && !contains(s.Enum, value) {
+			return fmt.Errorf("%q is not one of %v", value, s.Enum)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			return fmt.Errorf("%q does not match %s", value, s.Pattern)
+		}
+		if s.Format == "date" {
+			if _, err := time.Parse(dateLayout, value); err != nil {
+				return fmt.Errorf("%q is not a date", value)
+			}
+		}
+	}
+	return nil
+}
+
+// Samples returns values that are valid and invalid for s, from its example, enum,
+// bounds, pattern, and format.
+func (s *Schema) Samples() (valid, invalid []string) {
+	if len(s.Example) != 0 {
+		var example string
+		if json.Unmarshal(s.Example, &example) != nil {
+			example = string(s.Example)
+		}
+		valid = append(valid, example)
+	}
+	switch s.Type {
+	case "integer":
+		invalid = append(invalid, "one")
+		if s.Minimum != nil {
+			valid = append(valid, strconv.Itoa(*s.Minimum))
+			invalid = append(invalid, strconv.Itoa(*s.Minimum-1))
+		}
+		if s.Maximum != nil {
+			valid = append(valid, strconv.Itoa(*s.Maximum))
+			invalid = append(invalid, strconv.Itoa(*s.Maximum+1))
+		}
+	case "boolean":
+		valid = append(valid, "true", "false")
+		invalid = append(invalid, "yes")
+	case "array":
+		v, inv := s.Items.Samples()
+		valid = append(valid, v...)
+		invalid = append(invalid, inv...)
+		if len(v) != 0 {
+			invalid = append(invalid, v[0]+",!")
+		}
+	case "string":
+		valid = append(valid, s.Enum...)
+		if len(s.Enum) != 0 {
+			invalid = append(invalid, "invalid")
+		}
+		if s.pattern != nil {
+			invalid = append(invalid, "!")
+		}
+		if s.Format == "date" {
+			invalid = append(invalid, "2022-02-30", "June 1")
+		}
+	}
+	return valid, invalid
+}
+
+// Check returns an error if value isn't valid for p.
+func (p *Parameter) Check(value string) error {
+	if value == "" && p.AllowEmptyValue {
+		return nil
+	}
+	return p.Schema.Check(value)
+}
+
+// Samples returns values that are valid and invalid for p.
+func (p *Parameter) Samples() (valid, invalid []string) {
+	valid, invalid = p.Schema.Samples()
+	if p.AllowEmptyValue {
+		valid = append(valid, "")
+	}
+	return valid, invalid
+}
+
+// Request contains the parameters of a request to an Operation.
+type Request struct {
+	Query   map[string]string
+	Path    map[string]string
+	Headers map[string]string
+}
+
+// with returns a copy of r with p set to value, or without p if present is false.
+func (r Request) with(p *Parameter, value string, present bool) Request {
+	c := Request{Query: copyMap(r.Query), Path: copyMap(r.Path), Headers: copyMap(r.Headers)}
+	params := map[string]map[string]string{"query": c.Query, "path": c.Path, "header": c.Headers}[p.In]
+	if present {
+		params[p.Name] = value
+	} else {
+		delete(params, p.Name)
+	}
+	return c
+}
+
+// CheckParameters makes requests that add a sample value of one of op's parameters to
+// base, or leave a required parameter out. invalidFields returns the parameters that a
+// request has errors in. CheckParameters returns an error for each request where the
+// parameter was invalid and its value wasn't, or the other way around.
+func (op *Operation) CheckParameters(base Request, invalidFields func(Request) ([]string, error)) []error {
+	var errs []error
+	try := func(p *Parameter, value string, present, wantInvalid bool) {
+		fields, err := invalidFields(base.with(p, value, present))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s=%q: %w", p.Name, value, err))
+			return
+		}
+		if gotInvalid := contains(fields, p.Name); gotInvalid != wantInvalid {
+			errs = append(errs, fmt.Errorf("%s=%q (present %v): got invalid %v; expected %v", p.Name, value, present, gotInvalid, wantInvalid))
+		}
+	}
+
+	for _, p := range op.Parameters {
+		valid, invalid := p.Samples()
+		for _, v := range valid {
+			if err := p.Check(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: valid sample is invalid: %w", p.Name, err))
+				continue
+			}
+			try(p, v, true, false)
+		}
+		for _, v := range invalid {
+			if p.Check(v) == nil {
+				errs = append(errs, fmt.Errorf("%s: invalid sample %q is valid", p.Name, v))
+				continue
+			}
+			try(p, v, true, true)
+		}
+		if p.Required {
+			try(p, "", false, true)
+		}
+	}
+	return errs
+}
+
+// CheckType returns an error if the properties of the schema name are not the JSON
+// fields of v.
+func (d *Document) CheckType(name string, v interface{}) error {
+	s, ok := d.Components.Schemas[name]
+	if !ok {
+		return fmt.Errorf("unknown schema %s", name)
+	}
+
+	fields := jsonFields(reflect.TypeOf(v))
+	var missing, extra []string
+	for _, f := range fields {
+		if _, ok := s.Properties[f]; !ok {
+			extra = append(extra, f)
+		}
+	}
+	for p := range s.Properties {
+		if !contains(fields, p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) != 0 || len(extra) != 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%s: fields %v are not in the schema, and properties %v are not fields of %s", name, extra, missing, reflect.TypeOf(v))
+	}
+	return nil
+}
+
+// jsonFields returns the names that encoding/json gives the fields of t, including
+// the fields of embedded structs.
+func jsonFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(f.Type)...)
+			continue
+		}
+		if tag == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func copyMap(m map[string]string) map[string]string {
+	c := map[string]string{}
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
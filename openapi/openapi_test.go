@@ -0,0 +1,129 @@
+package openapi
+
+import (
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	d, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: got %v; expected nil", err)
+	}
+
+	for _, tag := range []string{"books", "lists", "subscribe"} {
+		if len(d.Routes(tag)) == 0 {
+			t.Errorf("no routes for tag %s", tag)
+		}
+	}
+	for path, ops := range d.Paths {
+		for method, op := range ops {
+			if op.OperationID == "" || len(op.Tags) != 1 {
+				t.Errorf("%s %s: expected an operationId and one tag", method, path)
+			}
+			for _, p := range op.Parameters {
+				if p.Name == "" || p.Schema == nil {
+					t.Errorf("%s %s: parameter %+v has no name or schema", method, path, p)
+				}
+			}
+			for _, name := range op.ResponseSchemas() {
+				if _, ok := d.Components.Schemas[name]; !ok {
+					t.Errorf("%s %s: unknown response schema %s", method, path, name)
+				}
+			}
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	d, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: got %v; expected nil", err)
+	}
+	op, ok := d.Operation("PATCH /subscribe")
+	if !ok {
+		t.Fatal("missing operation PATCH /subscribe")
+	}
+	params := map[string]*Parameter{}
+	for _, p := range op.Parameters {
+		params[p.Name] = p
+	}
+
+	testCases := []struct {
+		param string
+		value string
+		valid bool
+	}{
+		{"delivery_hour", "0", true},
+		{"delivery_hour", "23", true},
+		{"delivery_hour", "24", false},
+		{"delivery_hour", "noon", false},
+		{"paused", "true", true},
+		{"paused", "1", false},
+		{"paused_until", "2099-12-31", true},
+		{"paused_until", "", true},
+		{"paused_until", "2099-02-30", false},
+		{"frequency", "weekly", true},
+		{"frequency", "monthly", false},
+		{"lists", "manga,hardcover-fiction", true},
+		{"lists", "", true},
+		{"lists", "manga,", false},
+		{"time_zone", "America/Argentina/Buenos_Aires", true},
+		{"time_zone", "Etc/GMT+5", true},
+		{"time_zone", "../UTC", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.param+"="+tc.value, func(t *testing.T) {
+			if err := params[tc.param].Check(tc.value); (err == nil) != tc.valid {
+				t.Errorf("got error %v; expected valid %v", err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestCheckParameters(t *testing.T) {
+	d, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: got %v; expected nil", err)
+	}
+	op, _ := d.Operation("GET /lists/{encodedName}")
+
+	// A handler that accepts any limit
+	errs := op.CheckParameters(Request{}, func(r Request) ([]string, error) {
+		if r.Path["encodedName"] == "" {
+			return []string{"encodedName"}, nil
+		}
+		return nil, nil
+	})
+	// The encodedName pattern and the limit's type and bounds aren't enforced
+	if len(errs) != 4 {
+		t.Errorf("got errors %v; expected 4", errs)
+	}
+}
+
+type embedded struct {
+	Inner string `json:"inner"`
+}
+
+func TestCheckType(t *testing.T) {
+	d, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: got %v; expected nil", err)
+	}
+	type errorInfo struct {
+		Field    string `json:"field,omitempty"`
+		Message  string `json:"message,omitempty"`
+		Location string `json:"location"`
+		ignored  string
+		Skipped  string `json:"-"`
+	}
+	if err := d.CheckType("ErrorInfo", errorInfo{}); err != nil {
+		t.Errorf("unexpected error: got %v; expected nil", err)
+	}
+	type withExtra struct {
+		errorInfo
+		embedded
+	}
+	if err := d.CheckType("ErrorInfo", withExtra{}); err == nil {
+		t.Error("got nil error for a type with an extra field")
+	}
+}
@@ -0,0 +1,3 @@
+module bookoftheday/token
+
+go 1.18
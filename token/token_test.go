@@ -0,0 +1,80 @@
+package token
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	claims := Claims{
+		ID:        "id",
+		Subject:   "email@example.com",
+		Purpose:   "confirm",
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}
+
+	s := NewSigner([]byte("secret"))
+	tok, err := s.Sign(claims)
+	if err != nil {
+		t.Fatalf("Sign: got error %v; expected nil", err)
+	}
+
+	t.Run("verifies signed token", func(t *testing.T) {
+		got, err := s.Verify(tok, PurposeConfirm, now)
+		if err != nil {
+			t.Fatalf("got error %v; expected nil", err)
+		}
+		if got != claims {
+			t.Errorf("got claims %+v; expected %+v", got, claims)
+		}
+	})
+
+	t.Run("rejects expired token", func(t *testing.T) {
+		got, err := s.Verify(tok, PurposeConfirm, now.Add(time.Hour))
+		if !errors.Is(err, ErrExpired) {
+			t.Fatalf("got error %v; expected %v", err, ErrExpired)
+		}
+		if got.Subject != claims.Subject {
+			t.Errorf("got subject %q with expired token; expected %q", got.Subject, claims.Subject)
+		}
+	})
+
+	t.Run("rejects token for other purpose", func(t *testing.T) {
+		if _, err := s.Verify(tok, PurposeManage, now); !errors.Is(err, ErrInvalid) {
+			t.Errorf("got error %v; expected %v", err, ErrInvalid)
+		}
+	})
+
+	t.Run("rejects expired token for other purpose as invalid", func(t *testing.T) {
+		if _, err := s.Verify(tok, PurposeManage, now.Add(time.Hour)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("got error %v; expected %v", err, ErrInvalid)
+		}
+	})
+
+	testCases := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"missing signature", strings.Split(tok, ".")[0]},
+		{"tampered payload", "e30." + strings.Split(tok, ".")[1]},
+		{"bad encoding", tok + "!"},
+	}
+	for _, tc := range testCases {
+		t.Run("rejects "+tc.name, func(t *testing.T) {
+			if _, err := s.Verify(tc.token, PurposeConfirm, now); !errors.Is(err, ErrInvalid) {
+				t.Errorf("got error %v; expected %v", err, ErrInvalid)
+			}
+		})
+	}
+
+	t.Run("rejects token signed with different key", func(t *testing.T) {
+		other := NewSigner([]byte("other"))
+		if _, err := other.Verify(tok, PurposeConfirm, now); !errors.Is(err, ErrInvalid) {
+			t.Errorf("got error %v; expected %v", err, ErrInvalid)
+		}
+	})
+}
@@ -1,6 +1,10 @@
 // Package types provides common data types for querying and storing books.
 package types
 
+// Attribution is the attribution required by the NYT Books API terms of use, which every
+// response with NYT data includes.
+const Attribution = "Data provided by The New York Times: https://developer.nytimes.com"
+
 // BestSellerList models a single Best-Seller books list.
 type BestSellerList struct {
 	Name                string `json:"list_name"`
@@ -19,7 +23,7 @@ type BestSellerBook struct {
 	ListDisplayName   string `json:"list_display_name"`
 	ListUpdatePeriod  string `json:"list_update_period"`
 	PrimaryISBN10     string `json:"primary_isbn10"`
-	PrimaryISBN13     string `json:"primary_isbn13"`
+	PrimaryISBN13     string `json:"primary_isbn13" dynamodbav:",omitempty"`
 	Title             string `json:"title"`
 	Author            string `json:"author"`
 	Publisher         string `json:"publisher"`
@@ -30,6 +34,12 @@ type BestSellerBook struct {
 	ImageWidth        int    `json:"image_width"`
 	ImageHeight       int    `json:"image_height"`
 	Expiration        int64  `json:"-"`
+
+	// TitleKey and AuthorKey are the SearchKey of Title and Author, and are only
+	// stored to index books for lookups. They are omitted when empty because index
+	// keys can't be empty strings.
+	TitleKey  string `json:"-" dynamodbav:",omitempty"`
+	AuthorKey string `json:"-" dynamodbav:",omitempty"`
 }
 
 // BookItemKey contains the primary key data for a BestSellerBook item.
@@ -44,4 +54,19 @@ type BookItemKey struct {
 type SQSBookMessageBody struct {
 	ContactEmail string         `json:"contact_email"`
 	Book         BestSellerBook `json:"book"`
+
+	// Digest contains a week of books for subscribers that receive them weekly.
+	// Book is empty when Digest is set.
+	Digest []BestSellerBook `json:"digest,omitempty"`
+}
+
+// DeliveryRecord models a book that was sent to a subscriber. Records are keyed
+// by ContactEmail and the book's PrimaryISBN13.
+type DeliveryRecord struct {
+	ContactEmail string `json:"contact_email"`
+
+	// DateSent is the date (YYYY-MM-DD, UTC) that the book was sent on.
+	DateSent string `json:"date_sent"`
+
+	BestSellerBook
 }
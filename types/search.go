@@ -0,0 +1,16 @@
+package types
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SearchKey normalizes a title or author so that it can be matched exactly regardless
+// of case, punctuation, or spacing. For example, "The Sorcerer's Stone!" becomes
+// "the sorcerers stone".
+func SearchKey(s string) string {
+	s = strings.NewReplacer("'", "", "’", "").Replace(strings.ToLower(s))
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
@@ -0,0 +1,42 @@
+package types
+
+// Delivery frequencies of a Subscriber.
+const (
+	FrequencyDaily  = "daily"
+	FrequencyWeekly = "weekly"
+)
+
+// Subscriber models the delivery preferences of a single subscribed contact.
+// It is stored separately from the SES contact so that the contacts Lambda
+// can read every subscriber's preferences without a call per contact.
+type Subscriber struct {
+	EmailAddress string `json:"email_address"`
+
+	// Lists contains the encoded names of the Best-Seller lists the subscriber
+	// wants books from. An empty value means books from any list.
+	Lists []string `json:"lists"`
+
+	// Paused is set when the subscriber has asked to stop receiving books
+	// without unsubscribing.
+	Paused bool `json:"paused"`
+
+	// PausedUntil is the date (YYYY-MM-DD) that a paused subscriber resumes receiving
+	// books on. Dates in the past have no effect.
+	PausedUntil string `json:"paused_until,omitempty"`
+
+	// Frequency is how often the subscriber receives books, either FrequencyDaily
+	// or FrequencyWeekly. An empty value means FrequencyDaily.
+	Frequency string `json:"frequency,omitempty"`
+
+	// DeliveryDay is the lowercase name of the weekday that weekly subscribers receive
+	// a digest of the past week's books on, such as "monday".
+	DeliveryDay string `json:"delivery_day,omitempty"`
+
+	// TimeZone is the IANA name of the subscriber's time zone, such as
+	// "America/Los_Angeles". An empty value means UTC.
+	TimeZone string `json:"time_zone,omitempty"`
+
+	// DeliveryHour is the hour of the day (0-23) in TimeZone that the subscriber
+	// receives books at. DeliveryDay and PausedUntil are also in TimeZone.
+	DeliveryHour int `json:"delivery_hour,omitempty"`
+}